@@ -0,0 +1,332 @@
+package certmin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Default validity of the certificates issued by a CA.
+const (
+	DefaultRootValidity         = 10 * 365 * 24 * time.Hour
+	DefaultIntermediateValidity = 5 * 365 * 24 * time.Hour
+	DefaultLeafValidity         = 365 * 24 * time.Hour
+)
+
+// CertProfile holds the fields of a certificate issued by a CA. Subject holds the
+// subject and the Subject Alternative Names of the certificate. A zero NotBefore
+// is the current time and a zero Validity the default validity of the type of
+// certificate. When KeyUsage and ExtKeyUsages are not set, CA certificates can
+// sign certificates and CRLs and leaf certificates are TLS server certificates.
+// MaxPathLen, MaxPathLenZero (as in x509.Certificate) and the name constraints
+// are only used for CA certificates.
+type CertProfile struct {
+	Subject                                                    CSRTemplate
+	NotBefore                                                  time.Time
+	Validity                                                   time.Duration
+	KeyUsage                                                   x509.KeyUsage
+	ExtKeyUsages                                               []x509.ExtKeyUsage
+	MaxPathLen                                                 int
+	MaxPathLenZero                                             bool
+	PermittedDNSDomains, ExcludedDNSDomains                    []string
+	IssuingCertificateURLs, OCSPServers, CRLDistributionPoints []string
+}
+
+// CA is a certificate authority issuing certificates with its Certificate and
+// Key. Chain holds the issuers of Certificate up to the root CA and is empty for
+// a root CA.
+type CA struct {
+	Certificate *x509.Certificate
+	Key         *PrivateKey
+	Chain       []*x509.Certificate
+}
+
+// NewCA returns a *CA for a CA certificate and its chain ([]*x509.Certificate)
+// and the key (*PrivateKey) of the CA certificate, and an error if encountered.
+// The certificate matching the key is used as CA certificate and the other
+// certificates as its chain.
+func NewCA(certs []*x509.Certificate, key *PrivateKey) (*CA, error) {
+	if key == nil || key.Signer == nil {
+		return nil, errors.New("no key found")
+	}
+
+	var ca CA
+	for _, cert := range SortCerts(certs, false) {
+		if ca.Certificate == nil && VerifyCertAndPrivateKey(cert, key) == nil {
+			ca.Certificate = cert
+			continue
+		}
+		ca.Chain = append(ca.Chain, cert)
+	}
+	if ca.Certificate == nil {
+		return nil, errors.New("no certificate matching the key found")
+	}
+	if !ca.Certificate.IsCA {
+		return nil, fmt.Errorf("not a CA certificate (%s)", ca.Certificate.Subject)
+	}
+	if ca.Certificate.KeyUsage != 0 && ca.Certificate.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("the CA certificate can not sign certificates (%s)",
+			ca.Certificate.Subject)
+	}
+	ca.Key = key
+
+	return &ca, nil
+}
+
+// NewRootCA creates a self-signed root CA for a *CertProfile with a key
+// (*PrivateKey). A Common Name is required. It returns a *CA and an error if
+// encountered.
+func NewRootCA(profile *CertProfile, key *PrivateKey) (*CA, error) {
+	if key == nil || key.Signer == nil {
+		return nil, errors.New("no key found")
+	}
+	if profile == nil || profile.Subject.CommonName == "" {
+		return nil, errors.New("a CA certificate requires a Common Name")
+	}
+	template, err := newCertTemplate(profile, key.Signer.Public(), true, DefaultRootValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := createCert(template, template, key.Signer.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: cert, Key: key}, nil
+}
+
+// CertTree returns the CA certificate and its chain as a *CertTree.
+func (ca *CA) CertTree() *CertTree {
+	return ca.newCertTree(ca.Certificate)
+}
+
+// IssueCert issues a leaf certificate for a *CertProfile and a public key
+// (crypto.PublicKey). A Common Name or at least one Subject Alternative Name is
+// required. It returns the certificate and the chain of the CA as a *CertTree
+// and an error if encountered.
+func (ca *CA) IssueCert(profile *CertProfile, publicKey crypto.PublicKey) (*CertTree, error) {
+	if profile != nil && profile.Subject.CommonName == "" && len(profile.Subject.DNSNames) == 0 &&
+		len(profile.Subject.IPAddresses) == 0 && len(profile.Subject.EmailAddresses) == 0 &&
+		len(profile.Subject.URIs) == 0 {
+		return nil, errors.New("a certificate requires a Common Name or a Subject Alternative Name")
+	}
+	template, err := newCertTemplate(profile, publicKey, false, DefaultLeafValidity)
+	if err != nil {
+		return nil, err
+	}
+	return ca.issue(template, publicKey)
+}
+
+// IssueIntermediateCA issues an intermediate CA for a *CertProfile with a key
+// (*PrivateKey). A Common Name is required. It returns a *CA and an error if
+// encountered.
+func (ca *CA) IssueIntermediateCA(profile *CertProfile, key *PrivateKey) (*CA, error) {
+	if key == nil || key.Signer == nil {
+		return nil, errors.New("no key found")
+	}
+	if ca.Certificate != nil && ca.Certificate.MaxPathLenZero {
+		return nil, fmt.Errorf("the CA can not issue intermediate CAs (%s)", ca.Certificate.Subject)
+	}
+	if profile == nil || profile.Subject.CommonName == "" {
+		return nil, errors.New("a CA certificate requires a Common Name")
+	}
+	template, err := newCertTemplate(profile, key.Signer.Public(), true, DefaultIntermediateValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := ca.issue(template, key.Signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	chain := append([]*x509.Certificate{ca.Certificate}, ca.Chain...)
+	return &CA{Certificate: tree.Certificate, Key: key, Chain: chain}, nil
+}
+
+// SignCSR issues a leaf certificate for a certificate signing request
+// (*x509.CertificateRequest) after verifying its signature. The subject, the
+// Subject Alternative Names and the public key are the ones of the CSR, the other
+// fields are the ones of the *CertProfile (the extensions requested in the CSR
+// are ignored). It returns the certificate and the chain of the CA as a *CertTree
+// and an error if encountered.
+func (ca *CA) SignCSR(csr *x509.CertificateRequest, profile *CertProfile) (*CertTree, error) {
+	if csr == nil {
+		return nil, errors.New("no CSR found")
+	}
+	if err := VerifyCSR(csr); err != nil {
+		return nil, err
+	}
+
+	var csrProfile CertProfile
+	if profile != nil {
+		csrProfile = *profile
+	}
+	csrProfile.Subject = CSRTemplate{}
+	template, err := newCertTemplate(&csrProfile, csr.PublicKey, false, DefaultLeafValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.Subject = csr.Subject
+	template.DNSNames = csr.DNSNames
+	template.EmailAddresses = csr.EmailAddresses
+	template.IPAddresses = csr.IPAddresses
+	template.URIs = csr.URIs
+
+	return ca.issue(template, csr.PublicKey)
+}
+
+// createCert creates a certificate from a template signed by the parent
+// certificate and its key.
+func createCert(template, parent *x509.Certificate, publicKey crypto.PublicKey,
+	parentKey *PrivateKey) (*x509.Certificate, error) {
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, parentKey.Signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certBytes)
+}
+
+// issue signs a certificate template with the CA and returns the certificate
+// with the chain of the CA.
+func (ca *CA) issue(template *x509.Certificate, publicKey crypto.PublicKey) (*CertTree, error) {
+	if ca == nil || ca.Certificate == nil || ca.Key == nil || ca.Key.Signer == nil {
+		return nil, errors.New("no CA certificate and key found")
+	}
+	if template.NotAfter.After(ca.Certificate.NotAfter) {
+		return nil, fmt.Errorf("the certificate would expire after the CA (%s)",
+			ca.Certificate.NotAfter.Format(time.RFC3339))
+	}
+
+	// Set explicitly, as it is left out for certificates with the subject of the
+	// CA (e.g. a new key of a root CA cross-signed by its old key)
+	template.AuthorityKeyId = ca.Certificate.SubjectKeyId
+	cert, err := createCert(template, ca.Certificate, publicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return ca.newCertTree(cert), nil
+}
+
+// newCertTree returns a *CertTree for a certificate issued by the CA or for the
+// CA certificate itself.
+func (ca *CA) newCertTree(cert *x509.Certificate) *CertTree {
+	issuers := ca.Chain
+	if cert != ca.Certificate {
+		issuers = append([]*x509.Certificate{ca.Certificate}, ca.Chain...)
+	} else if IsRootCA(cert) {
+		issuers = []*x509.Certificate{cert}
+	}
+
+	tree := CertTree{Certificate: cert}
+	for _, issuer := range issuers {
+		if IsRootCA(issuer) {
+			tree.Roots = append(tree.Roots, issuer)
+		} else {
+			tree.Intermediates = append(tree.Intermediates, issuer)
+		}
+	}
+	return &tree
+}
+
+// newCertTemplate returns the *x509.Certificate template for a *CertProfile.
+func newCertTemplate(profile *CertProfile, publicKey crypto.PublicKey, isCA bool,
+	defaultValidity time.Duration) (*x509.Certificate, error) {
+	if profile == nil {
+		return nil, errors.New("no certificate profile found")
+	}
+	subject, ips, uris, err := profile.Subject.names()
+	if err != nil {
+		return nil, err
+	}
+	if profile.Validity < 0 {
+		return nil, fmt.Errorf("invalid validity (%s)", profile.Validity)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	subjectKeyID, err := newSubjectKeyID(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := profile.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now().UTC().Truncate(time.Second)
+	}
+	validity := profile.Validity
+	if validity == 0 {
+		validity = defaultValidity
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              profile.KeyUsage,
+		ExtKeyUsage:           profile.ExtKeyUsages,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		SubjectKeyId:          subjectKeyID,
+		DNSNames:              profile.Subject.DNSNames,
+		EmailAddresses:        profile.Subject.EmailAddresses,
+		IPAddresses:           ips,
+		URIs:                  uris,
+		IssuingCertificateURL: profile.IssuingCertificateURLs,
+		OCSPServer:            profile.OCSPServers,
+		CRLDistributionPoints: profile.CRLDistributionPoints,
+	}
+
+	if isCA {
+		template.MaxPathLen = profile.MaxPathLen
+		template.MaxPathLenZero = profile.MaxPathLenZero
+		if template.MaxPathLen == 0 && !template.MaxPathLenZero {
+			template.MaxPathLen = -1
+		}
+		template.PermittedDNSDomains = profile.PermittedDNSDomains
+		template.ExcludedDNSDomains = profile.ExcludedDNSDomains
+		if template.KeyUsage == 0 {
+			template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+		}
+		return &template, nil
+	}
+
+	if template.KeyUsage == 0 {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, ok := publicKey.(*rsa.PublicKey); ok {
+			template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+	}
+	if len(template.ExtKeyUsage) == 0 {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	return &template, nil
+}
+
+// newSubjectKeyID returns the Subject Key Identifier of a public key: the SHA-1
+// hash of the public key bits (RFC 5280, section 4.2.1.2).
+func newSubjectKeyID(publicKey crypto.PublicKey) ([]byte, error) {
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(publicKeyBytes, &info); err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(info.PublicKey.Bytes)
+	return hash[:], nil
+}
@@ -0,0 +1,244 @@
+package certmin
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCA(t *testing.T) {
+	certs, err := DecodeCertFile("t/chain.crt", "")
+	assert.NoError(t, err)
+	key, err := DecodePrivateKeyFile("t/ca.key", testPassword)
+	assert.NoError(t, err)
+	caCerts, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+
+	ca, err := NewCA(caCerts, key)
+	assert.NoError(t, err)
+	if assert.NotNil(t, ca) {
+		assert.Equal(t, caCerts[0].Raw, ca.Certificate.Raw)
+		assert.Empty(t, ca.Chain)
+		tree, err := ca.IssueCert(&CertProfile{Subject: CSRTemplate{DNSNames: []string{"myserver"}}},
+			key.Signer.Public())
+		assert.NoError(t, err)
+		assert.True(t, VerifyChain(tree).Verified)
+	}
+
+	_, err = NewCA(certs, nil)
+	assert.Error(t, err)
+	_, err = NewCA(caCerts, &PrivateKey{})
+	assert.Error(t, err)
+
+	// Not a CA
+	key, err = DecodePrivateKeyFile("t/myserver.key", "")
+	assert.NoError(t, err)
+	_, err = NewCA(certs, key)
+	assert.Error(t, err)
+	_, err = NewCA(caCerts, key)
+	assert.Error(t, err)
+}
+
+func TestNewRootCA(t *testing.T) {
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	ca, err := NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "certmin root", Organization: "certmin"}}, key)
+	assert.NoError(t, err)
+	if assert.NotNil(t, ca) {
+		cert := ca.Certificate
+		assert.True(t, IsRootCA(cert))
+		assert.Equal(t, "certmin root", cert.Subject.CommonName)
+		assert.Equal(t, []string{"certmin"}, cert.Subject.Organization)
+		assert.Equal(t, -1, cert.MaxPathLen)
+		assert.Equal(t, x509.KeyUsageCertSign|x509.KeyUsageCRLSign|x509.KeyUsageDigitalSignature, cert.KeyUsage)
+		assert.Equal(t, DefaultRootValidity, cert.NotAfter.Sub(cert.NotBefore))
+		assert.NotEmpty(t, cert.SubjectKeyId)
+		assert.NoError(t, cert.CheckSignatureFrom(cert))
+
+		tree := ca.CertTree()
+		assert.Equal(t, cert, tree.Certificate)
+		assert.Equal(t, []*x509.Certificate{cert}, tree.Roots)
+		assert.True(t, VerifyChain(tree).Verified)
+	}
+
+	_, err = NewRootCA(&CertProfile{Subject: CSRTemplate{DNSNames: []string{"certmin"}}}, key)
+	assert.Error(t, err)
+	_, err = NewRootCA(nil, key)
+	assert.Error(t, err)
+	_, err = NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "root"}}, nil)
+	assert.Error(t, err)
+	_, err = NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "root"}, Validity: -time.Hour}, key)
+	assert.Error(t, err)
+}
+
+func TestCAIssueIntermediateCA(t *testing.T) {
+	rootKey, err := GeneratePrivateKey(KeyAlgorithmRSA, 0, "")
+	assert.NoError(t, err)
+	root, err := NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "root"}}, rootKey)
+	assert.NoError(t, err)
+
+	key, err := GeneratePrivateKey(KeyAlgorithmEd25519, 0, "")
+	assert.NoError(t, err)
+	profile := CertProfile{
+		Subject:             CSRTemplate{CommonName: "intermediate"},
+		MaxPathLenZero:      true,
+		PermittedDNSDomains: []string{"example.com"},
+		ExcludedDNSDomains:  []string{"secret.example.com"},
+	}
+	inter, err := root.IssueIntermediateCA(&profile, key)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, inter) {
+		return
+	}
+	cert := inter.Certificate
+	assert.True(t, cert.IsCA)
+	assert.False(t, IsRootCA(cert))
+	assert.Equal(t, 0, cert.MaxPathLen)
+	assert.True(t, cert.MaxPathLenZero)
+	assert.Equal(t, []string{"example.com"}, cert.PermittedDNSDomains)
+	assert.Equal(t, []string{"secret.example.com"}, cert.ExcludedDNSDomains)
+	assert.Equal(t, root.Certificate.SubjectKeyId, cert.AuthorityKeyId)
+	assert.Equal(t, DefaultIntermediateValidity, cert.NotAfter.Sub(cert.NotBefore))
+	assert.Equal(t, []*x509.Certificate{root.Certificate}, inter.Chain)
+	assert.True(t, VerifyChain(inter.CertTree()).Verified)
+
+	// Path length and name constraints
+	_, err = inter.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "sub"}}, key)
+	assert.Error(t, err)
+	for _, test := range []struct {
+		dnsName  string
+		verified bool
+	}{
+		{"www.example.com", true},
+		{"www.secret.example.com", false},
+		{"www.example.org", false},
+	} {
+		tree, err := inter.IssueCert(&CertProfile{Subject: CSRTemplate{DNSNames: []string{test.dnsName}}},
+			rootKey.Signer.Public())
+		assert.NoError(t, err)
+		assert.Equal(t, []*x509.Certificate{cert}, tree.Intermediates)
+		assert.Equal(t, []*x509.Certificate{root.Certificate}, tree.Roots)
+		assert.Equal(t, test.verified, VerifyChain(tree).Verified, test.dnsName)
+	}
+
+	_, err = root.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "inter"},
+		Validity: 2 * DefaultRootValidity}, key)
+	assert.Error(t, err)
+	_, err = root.IssueIntermediateCA(&CertProfile{}, key)
+	assert.Error(t, err)
+	_, err = root.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "inter"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestCAIssueCert(t *testing.T) {
+	caKey, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "P-384")
+	assert.NoError(t, err)
+	ca, err := NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "root"}}, caKey)
+	assert.NoError(t, err)
+
+	key, err := GeneratePrivateKey(KeyAlgorithmRSA, 0, "")
+	assert.NoError(t, err)
+	notBefore := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	profile := CertProfile{
+		Subject: CSRTemplate{
+			CommonName:  "myserver",
+			DNSNames:    []string{"myserver", "myserver.example.com"},
+			IPAddresses: []string{"10.0.0.1"},
+			URIs:        []string{"https://myserver.example.com"},
+		},
+		NotBefore:              notBefore,
+		Validity:               30 * 24 * time.Hour,
+		IssuingCertificateURLs: []string{"http://ca.example.com/root.crt"},
+		OCSPServers:            []string{"http://ocsp.example.com"},
+		CRLDistributionPoints:  []string{"http://ca.example.com/root.crl"},
+		MaxPathLen:             3,
+	}
+	tree, err := ca.IssueCert(&profile, key.Signer.Public())
+	assert.NoError(t, err)
+	if assert.NotNil(t, tree) {
+		cert := tree.Certificate
+		assert.False(t, cert.IsCA)
+		assert.True(t, notBefore.Equal(cert.NotBefore))
+		assert.True(t, notBefore.Add(30*24*time.Hour).Equal(cert.NotAfter))
+		assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, cert.KeyUsage)
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+		assert.Equal(t, profile.Subject.DNSNames, cert.DNSNames)
+		assert.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+		assert.Equal(t, "https://myserver.example.com", cert.URIs[0].String())
+		assert.Equal(t, profile.IssuingCertificateURLs, cert.IssuingCertificateURL)
+		assert.Equal(t, profile.OCSPServers, cert.OCSPServer)
+		assert.Equal(t, profile.CRLDistributionPoints, cert.CRLDistributionPoints)
+		assert.NotEmpty(t, cert.SubjectKeyId)
+		assert.Equal(t, ca.Certificate.SubjectKeyId, cert.AuthorityKeyId)
+		assert.NoError(t, VerifyCertAndPrivateKey(cert, key))
+		assert.Empty(t, tree.Intermediates)
+		assert.True(t, VerifyChainWithOptions(tree, VerifyOptions{DNSName: "myserver.example.com"}).Verified)
+	}
+
+	profile = CertProfile{
+		Subject:      CSRTemplate{EmailAddresses: []string{"alice@example.com"}},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection},
+	}
+	tree, err = ca.IssueCert(&profile, key.Signer.Public())
+	assert.NoError(t, err)
+	if assert.NotNil(t, tree) {
+		assert.Equal(t, x509.KeyUsageDigitalSignature, tree.Certificate.KeyUsage)
+		assert.Equal(t, profile.ExtKeyUsages, tree.Certificate.ExtKeyUsage)
+		assert.Equal(t, DefaultLeafValidity, tree.Certificate.NotAfter.Sub(tree.Certificate.NotBefore))
+		result := VerifyChainWithOptions(tree, VerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+		assert.False(t, result.Verified)
+	}
+
+	_, err = ca.IssueCert(&CertProfile{}, key.Signer.Public())
+	assert.Error(t, err)
+	_, err = ca.IssueCert(nil, key.Signer.Public())
+	assert.Error(t, err)
+	_, err = ca.IssueCert(&CertProfile{Subject: CSRTemplate{IPAddresses: []string{"foo"}}}, key.Signer.Public())
+	assert.Error(t, err)
+	_, err = (&CA{}).IssueCert(&CertProfile{Subject: CSRTemplate{CommonName: "foo"}}, key.Signer.Public())
+	assert.Error(t, err)
+}
+
+func TestCASignCSR(t *testing.T) {
+	caCerts, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	caKey, err := DecodePrivateKeyFile("t/ca.key", testPassword)
+	assert.NoError(t, err)
+	ca, err := NewCA(caCerts, caKey)
+	assert.NoError(t, err)
+
+	csr, err := DecodeCSRFile("t/myserver.csr")
+	assert.NoError(t, err)
+	profile := CertProfile{
+		Subject:  CSRTemplate{CommonName: "ignored"},
+		Validity: 24 * time.Hour,
+	}
+	tree, err := ca.SignCSR(csr, &profile)
+	assert.NoError(t, err)
+	if assert.NotNil(t, tree) {
+		cert := tree.Certificate
+		assert.Equal(t, csr.Subject.String(), cert.Subject.String())
+		assert.Equal(t, csr.DNSNames, cert.DNSNames)
+		assert.Equal(t, csr.EmailAddresses, cert.EmailAddresses)
+		assert.Equal(t, csr.URIs, cert.URIs)
+		assert.Equal(t, 24*time.Hour, cert.NotAfter.Sub(cert.NotBefore))
+		key, err := DecodePrivateKeyFile("t/myserver.key", "")
+		assert.NoError(t, err)
+		assert.NoError(t, VerifyCertAndPrivateKey(cert, key))
+		assert.True(t, VerifyChain(tree).Verified)
+	}
+
+	tree, err = ca.SignCSR(csr, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, tree)
+
+	csr, err = DecodeCSRFile("t/myserver_bad_signature.csr")
+	assert.NoError(t, err)
+	_, err = ca.SignCSR(csr, &profile)
+	assert.Error(t, err)
+	_, err = ca.SignCSR(nil, &profile)
+	assert.Error(t, err)
+}
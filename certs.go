@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/youmark/pkcs8"
 	"go.mozilla.org/pkcs7"
@@ -24,9 +27,65 @@ type CertTree struct {
 	Intermediates, Roots []*x509.Certificate
 }
 
-// DecodeCertBytes reads a []byte with DER or PEM PKCS1, PKCS7 and PKCS12 encoded certificates,
-// and returns the contents as a []*x509.Certificate and an error if encountered. A password is
-// only needed for PKCS12.
+// Failure categories of a VerificationResult.
+const (
+	FailureNone             = ""
+	FailureExpired          = "expired"
+	FailureUnknownAuthority = "unknown authority"
+	FailureHostnameMismatch = "hostname mismatch"
+	FailureNameConstraints  = "name constraint violation"
+	FailureBadUsage         = "bad usage"
+	FailureOther            = "other"
+)
+
+// Encryption schemes of EncodeAsPKCS12. PKCS12EncryptionLegacy uses RC2 and 3DES
+// with a SHA-1 MAC, as expected by older software (e.g. Java 8 and Windows
+// Server 2016). PKCS12EncryptionAES uses AES-256-CBC with PBKDF2 and a SHA-256
+// MAC, the default of OpenSSL 3.
+const (
+	PKCS12EncryptionLegacy = "legacy"
+	PKCS12EncryptionAES    = "aes"
+)
+
+// Sources of the certificates in a VerifiedPath.
+const (
+	SourceCertTree = "cert tree"
+	SourceSystem   = "system"
+)
+
+// VerificationResult represents the outcome of a chain verification. When Verified
+// is true, Paths contains every valid path that was found. Otherwise Failure holds
+// the category of the failure, FailedCert the offending certificate (if known) and
+// Err the error returned by the verification.
+type VerificationResult struct {
+	Verified   bool
+	Paths      []VerifiedPath
+	Failure    string
+	FailedCert *x509.Certificate
+	Err        error
+}
+
+// VerifiedPath is a valid path from a certificate to a root. Sources has the same
+// length as Certificates and states if a certificate was part of the CertTree
+// (SourceCertTree) or was found in the OS trust store (SourceSystem).
+type VerifiedPath struct {
+	Certificates []*x509.Certificate
+	Sources      []string
+}
+
+// VerifyOptions holds the optional constraints of VerifyChainWithOptions. DNSName,
+// when not empty, must match the certificate. CurrentTime, when not zero, is used
+// instead of the current time. KeyUsages lists the required extended key usages,
+// with x509.ExtKeyUsageServerAuth being used when empty.
+type VerifyOptions struct {
+	DNSName     string
+	CurrentTime time.Time
+	KeyUsages   []x509.ExtKeyUsage
+}
+
+// DecodeCertBytes reads a []byte with DER or PEM PKCS1, PKCS7, PKCS12 and JKS/JCEKS encoded
+// certificates, and returns the contents as a []*x509.Certificate and an error if encountered.
+// A password is only needed for PKCS12 (and to check the integrity of a JKS/JCEKS keystore).
 func DecodeCertBytes(certBytes []byte, password string) ([]*x509.Certificate, error) {
 	var certs []*x509.Certificate
 	var err error
@@ -61,6 +120,13 @@ func DecodeCertBytes(certBytes []byte, password string) ([]*x509.Certificate, er
 			break
 		}
 
+		certs, err = DecodeCertBytesJKS(certBytes, password)
+		if err != nil {
+			errStrs = append(errStrs, err.Error())
+		} else {
+			break
+		}
+
 		certs, err = DecodeCertBytesPKCS12(certBytes, password)
 		if err != nil {
 			errStrs = append(errStrs, err.Error())
@@ -231,6 +297,13 @@ func DecodeKeyBytes(keyBytes []byte, password string) (*pem.Block, error) {
 			break
 		}
 
+		block, err = DecodeKeyBytesLegacyPEM(keyBytes, password)
+		if err != nil {
+			errStrs = append(errStrs, err.Error())
+		} else {
+			break
+		}
+
 		block, err = DecodeKeyBytesPKCS8(keyBytes, password)
 		if err != nil {
 			errStrs = append(errStrs, err.Error())
@@ -238,6 +311,20 @@ func DecodeKeyBytes(keyBytes []byte, password string) (*pem.Block, error) {
 			break
 		}
 
+		block, err = DecodeKeyBytesDER(keyBytes, password)
+		if err != nil {
+			errStrs = append(errStrs, err.Error())
+		} else {
+			break
+		}
+
+		block, err = DecodeKeyBytesJKS(keyBytes, password)
+		if err != nil {
+			errStrs = append(errStrs, err.Error())
+		} else {
+			break
+		}
+
 		block, err = DecodeKeyBytesPKCS12(keyBytes, password)
 		if err != nil {
 			errStrs = append(errStrs, err.Error())
@@ -270,10 +357,54 @@ func DecodeKeyBytesPKCS1(keyBytes []byte) (*pem.Block, error) {
 	if block == nil || !strings.Contains(block.Type, "PRIVATE KEY") {
 		return nil, errors.New("failed to decode private key")
 	}
+	if x509.IsEncryptedPEMBlock(block) {
+		return nil, errors.New("encrypted key")
+	}
 
 	return block, nil
 }
 
+// DecodeKeyBytesDER reads a []byte with a DER encoded PKCS1, SEC1, PKCS8 or
+// encrypted PKCS8 key (as exported by e.g. Java and Windows tooling) and returns
+// a *pem.Block and an error if encountered. A password is only needed for
+// encrypted keys. If you don't know in what format the data is encoded, use
+// DecodeKeyBytes.
+func DecodeKeyBytesDER(keyBytes []byte, password string) (*pem.Block, error) {
+	if strings.Contains(string(keyBytes), "-----BEGIN") {
+		return nil, errors.New("not a DER key")
+	}
+
+	key, err := decodePrivateKeyDER(keyBytes, password)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.Format {
+	case KeyFormatPKCS1:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: keyBytes}, nil
+	case KeyFormatSEC1:
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}, nil
+	default:
+		return key.PEMBlock()
+	}
+}
+
+// DecodeKeyBytesLegacyPEM reads a []byte with a RSA or EC PEM key encrypted by
+// OpenSSL with Proc-Type and DEK-Info headers (e.g. by "openssl genrsa -aes256")
+// and returns the decrypted *pem.Block and an error if encountered. If you don't
+// know in what format the data is encoded, use DecodeKeyBytes.
+func DecodeKeyBytesLegacyPEM(keyBytes []byte, password string) (*pem.Block, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil || !strings.Contains(block.Type, "PRIVATE KEY") {
+		return nil, errors.New("not a PEM key")
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return nil, errors.New("not a legacy encrypted PEM key")
+	}
+
+	return decryptLegacyPEMBlock(block, password)
+}
+
 // DecodeKeyBytesPKCS8 reads a []byte with an encrypted PKCS8 PEM encoded key and returns
 // a *pem.Block and an error if encountered. If you don't know in what format the data
 // is encoded, use DecodeKeyBytes.
@@ -306,8 +437,8 @@ func DecodeKeyBytesPKCS12(keyBytes []byte, password string) (*pem.Block, error)
 	return getPKCS8PEMBlock(parsedKey)
 }
 
-// DecodeKeyFile reads a file with PEM encoded key and returns the contents as a *pem.Block
-// and an error if encountered.
+// DecodeKeyFile reads a file with a PEM, DER or PKCS12 encoded key and returns the contents
+// as a *pem.Block and an error if encountered.
 func DecodeKeyFile(keyFile string, password string) (*pem.Block, error) {
 	keyBytes, err := ioutil.ReadFile(keyFile)
 	if err != nil {
@@ -316,6 +447,42 @@ func DecodeKeyFile(keyFile string, password string) (*pem.Block, error) {
 	return DecodeKeyBytes(keyBytes, password)
 }
 
+// EncodeAsPKCS12 converts a certificate, its CA certificates and a *pem.Block
+// private key to a []byte with data encoded as password protected PKCS12 and
+// an error. The encryption is PKCS12EncryptionLegacy or PKCS12EncryptionAES.
+func EncodeAsPKCS12(cert *x509.Certificate, caCerts []*x509.Certificate, key *pem.Block,
+	password, encryption string) ([]byte, error) {
+	if cert == nil {
+		return nil, errors.New("no certificate found")
+	}
+	if key == nil {
+		return nil, errors.New("no key found")
+	}
+
+	parsedKey, err := parseKeyBlock(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch encryption {
+	case PKCS12EncryptionLegacy:
+		return pkcs12.Encode(rand.Reader, parsedKey, cert, caCerts, password)
+	case PKCS12EncryptionAES:
+		return encodePKCS12AES(parsedKey, cert, caCerts, password)
+	default:
+		return nil, fmt.Errorf("unknown PKCS12 encryption (%s)", encryption)
+	}
+}
+
+// EncodeCertAsPKCS1DER converts *x509.Certificate to a []byte with
+// data encoded as PKCS1 DER and an error.
+func EncodeCertAsPKCS1DER(cert *x509.Certificate) ([]byte, error) {
+	if cert == nil {
+		return nil, errors.New("no certificate found")
+	}
+	return cert.Raw, nil
+}
+
 // EncodeCertAsPKCS1PEM converts *x509.Certificate to a []byte with
 // data encoded as PKCS1 PEM and an error.
 func EncodeCertAsPKCS1PEM(cert *x509.Certificate) ([]byte, error) {
@@ -334,6 +501,48 @@ func EncodeCertAsPKCS1PEM(cert *x509.Certificate) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+// EncodeCertsAsPKCS1PEM converts []*x509.Certificate to a []byte with
+// data encoded as PKCS1 PEM (one block per certificate) and an error.
+func EncodeCertsAsPKCS1PEM(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeCertsAsPKCS7DER converts []*x509.Certificate to a []byte with
+// data encoded as a PKCS7 DER certificate bundle (degenerate SignedData)
+// and an error.
+func EncodeCertsAsPKCS7DER(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	return pkcs7.DegenerateCertificate(raw)
+}
+
+// EncodeCertsAsPKCS7PEM converts []*x509.Certificate to a []byte with
+// data encoded as a PKCS7 PEM certificate bundle (degenerate SignedData)
+// and an error.
+func EncodeCertsAsPKCS7PEM(certs []*x509.Certificate) ([]byte, error) {
+	der, err := EncodeCertsAsPKCS7DER(certs)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: der}), nil
+}
+
 // EncodeKeyAsPKCS1PEM converts *pem.Block private key to a []byte with
 // data encoded as PKCS1 PEM and an error.
 func EncodeKeyAsPKCS1PEM(key *pem.Block) ([]byte, error) {
@@ -346,188 +555,153 @@ func EncodeKeyAsPKCS1PEM(key *pem.Block) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+// EncodeKeyAsPKCS8DER converts *pem.Block private key to a []byte with data
+// encoded as PKCS8 DER and an error. The key is encrypted (AES-256-CBC) when
+// password is not empty.
+func EncodeKeyAsPKCS8DER(key *pem.Block, password string) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("no key found")
+	}
+
+	parsedKey, err := parseKeyBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	if password == "" {
+		return x509.MarshalPKCS8PrivateKey(parsedKey)
+	}
+	return pkcs8.MarshalPrivateKey(parsedKey, []byte(password), nil)
+}
+
+// EncodeKeyAsPKCS8PEM converts *pem.Block private key to a []byte with data
+// encoded as PKCS8 PEM and an error. The key is encrypted (AES-256-CBC) when
+// password is not empty.
+func EncodeKeyAsPKCS8PEM(key *pem.Block, password string) ([]byte, error) {
+	der, err := EncodeKeyAsPKCS8DER(key, password)
+	if err != nil {
+		return nil, err
+	}
+
+	blockType := "PRIVATE KEY"
+	if password != "" {
+		blockType = "ENCRYPTED PRIVATE KEY"
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+}
+
 // FindLeaf looks for the leaf certificate in a chain, this being the
 // farthest certificate from the Root CA (usually the certificate of
 // a server). It takes a []*x509.Certificate as chain with cert and
 // it returns a *x509.Certificate as leaf and an error if zero or
 // more than one leaf could be found.
 func FindLeaf(certs []*x509.Certificate) (*x509.Certificate, error) {
-	candidates := make(map[string]bool)
-	var found *x509.Certificate
-	for _, cert := range certs {
-		if cert.IsCA {
-			continue
+	var found []*x509.Certificate
+	for _, cert := range NewCertGraph(certs).Leaves() {
+		if !cert.IsCA {
+			found = append(found, cert)
 		}
-		found = cert
-		candidates[cert.Subject.String()+cert.Subject.SerialNumber] = true
 	}
 
-	switch len(candidates) {
+	switch len(found) {
 	case 0:
 		return nil, errors.New("no leaf found")
 	case 1:
-		return found, nil
+		return found[0], nil
 	default:
 		return nil, errors.New("more than one leaf found")
 	}
 }
 
 // IsRootCA returns for a given *x509.Certificate true if
-// the CA is marked as IsCA and the Subject and the Issuer
-// are the same.
+// the CA is marked as IsCA and is self-signed: the Subject and
+// the Issuer are the same and the Authority Key Identifier (if
+// any) is its own Subject Key Identifier.
 func IsRootCA(cert *x509.Certificate) bool {
-	return cert.IsCA && cert.Subject.String() == cert.Issuer.String()
+	return cert.IsCA && isSelfSigned(cert)
 }
 
 // SortCerts sorts a []*x509.Certificate from leaf to root CA, or the other
 // way around if a the supplied boolean is set to true. Double elements are
 // removed.
 func SortCerts(certs []*x509.Certificate, reverse bool) []*x509.Certificate {
-	chainAsCerts, certByName, order := SortCertsAsChains(certs, reverse)
-
-	var orderedFromLeaves []*x509.Certificate
-	var orderedNoLeaves []*x509.Certificate
-	for _, subj := range order {
-		if ! certByName[subj].IsCA {
-			orderedFromLeaves = append(orderedFromLeaves, chainAsCerts[subj]...)
-		} else {
-			orderedNoLeaves = append(orderedNoLeaves, chainAsCerts[subj]...)
-		}
-	}
+	chains, _ := SortCertsAsChains(certs, reverse)
 
 	var ordered []*x509.Certificate
-	tmpOrdered := append(orderedFromLeaves, orderedNoLeaves...)
 	seen := make(map[string]bool)
-	for _, cert := range tmpOrdered {
-		if _, ok := seen[cert.Subject.String()]; ok {
-			continue
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if seen[string(cert.Raw)] {
+				continue
+			}
+			ordered = append(ordered, cert)
+			seen[string(cert.Raw)] = true
 		}
-		ordered = append(ordered, cert)
-		seen[cert.Subject.String()] = true
 	}
 
 	return ordered
 }
 
-// SortCertsAsChains sorts a []*x509.Certificate from leaf to root CA, or the other
-// way around if a the boolean parameter is set to true. The function returns three
-// elements: a map[string][]*x509.Certificate with the subject as key and the chain as
-// value, a map[string]*x509.Certificate with the the subject as key and the
-// corresponding *x509.Certificate as value and a []string with Subjects that are used
-// as keys in the first map, in the order the where found in the given []*x509.Certificate
-// parameter.
-func SortCertsAsChains(
-	certs []*x509.Certificate, reverse bool) (map[string][]*x509.Certificate, map[string]*x509.Certificate, []string) {
-	// Get the information needed to follow the chain
-	var certByNameOrder []string
-	issuerName := make(map[string]string)
-	certByName := make(map[string]*x509.Certificate)
-	isLeaf := make(map[string]bool)
-	for _, cert := range certs {
-		subj := cert.Subject.String()
-		issuer := cert.Issuer.String()
-		if _, ok := certByName[subj]; ok {
-			continue
-		}
-		if !cert.IsCA {
-			isLeaf[subj] = true
-		}
-		certByName[subj] = cert
-		issuerName[subj] = issuer
-		certByNameOrder = append(certByNameOrder, subj)
-	}
-
-	// Create chains
-	var order []string
-	chain := make(map[string][]string)
-	skip := make(map[string]bool)
-	for subj, issuer := range issuerName {
-		if _, ok := skip[subj]; ok {
-			continue
-		}
-
-		skip[issuer] = true // we follow the issuers below
-		chain[subj] = []string{subj}
-		order = append(order, subj)
-		presentIssuer := issuer
-		for {
-			if _, ok := certByName[subj]; !ok {
-				continue
-			}
-
-			tmpChain := []string{}
-			tmpChain = append(tmpChain, chain[subj]...)
-			tmpChain = append(tmpChain, presentIssuer)
-			skip[presentIssuer] = true
-			chain[subj] = tmpChain
-			delete(chain, presentIssuer)
-
-			if nextIssuer, ok := issuerName[presentIssuer]; ok {
-				if nextIssuer == presentIssuer { // end of this chain
-					break
-				}
-
-				presentIssuer = nextIssuer
-				continue
-			}
-			break
-		}
-	}
-
-	chainAsCerts := make(map[string][]*x509.Certificate)
-	for subj, chainElems := range chain {
-		var ordered []*x509.Certificate
-		for _, chainElem := range chainElems {
-			if cert, ok := certByName[chainElem]; ok {
-				ordered = append(ordered, cert)
-			}
-		}
-		if reverse {
+// SortCertsAsChains sorts a []*x509.Certificate as chains from leaf to root CA, or
+// the other way around if a the boolean parameter is set to true. The function
+// returns the chains as a [][]*x509.Certificate (see CertGraph.Chains), chains
+// starting at a leaf first, and the *CertGraph the chains were built from.
+// Certificates shared by several chains are part of each chain.
+func SortCertsAsChains(certs []*x509.Certificate, reverse bool) ([][]*x509.Certificate, *CertGraph) {
+	graph := NewCertGraph(certs)
+	chains := graph.Chains()
+	if reverse {
+		for idx, chain := range chains {
 			var reversed []*x509.Certificate
-			for idx := len(ordered) - 1; idx >= 0; idx-- {
-				reversed = append(reversed, ordered[idx])
+			for certIdx := len(chain) - 1; certIdx >= 0; certIdx-- {
+				reversed = append(reversed, chain[certIdx])
 			}
-			ordered = reversed
+			chains[idx] = reversed
 		}
-		chainAsCerts[subj] = ordered
 	}
 
-	return chainAsCerts, certByName, order
+	return chains, graph
 }
 
 // SplitCertsAsTree returns a *CertTree where the given certificates
 // are assigned as Certificate, Intermediates and Roots. The starting
-// leaf certificate must be the first element of the given
-// []*x509.Certificate.
+// certificate of the first chain (see SortCertsAsChains), usually
+// the leaf, is assigned as Certificate.
 func SplitCertsAsTree(certs []*x509.Certificate) *CertTree {
-	if len(certs) == 0 {
+	chains := NewCertGraph(certs).Chains()
+	if len(chains) == 0 {
 		return nil
 	}
 
-	ordered := SortCerts(certs, false)
-	var roots, inters []*x509.Certificate
-	for _, cert := range ordered[1:] {
-		if IsRootCA(cert) {
-			roots = append(roots, cert)
-		} else {
-			inters = append(inters, cert)
+	certTree := CertTree{Certificate: chains[0][0]}
+	seen := map[string]bool{string(certTree.Certificate.Raw): true}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if seen[string(cert.Raw)] {
+				continue
+			}
+			seen[string(cert.Raw)] = true
+			if IsRootCA(cert) {
+				certTree.Roots = append(certTree.Roots, cert)
+			} else {
+				certTree.Intermediates = append(certTree.Intermediates, cert)
+			}
 		}
 	}
 
-	certTree := CertTree{
-		Certificate:   ordered[0],
-		Intermediates: inters,
-		Roots:         roots,
-	}
-
 	return &certTree
 }
 
 // VerifyChain verifies the chain of a certificate as part of a CertTree. When the
-// Roots field is nil, the OS trust store is used. The function return a boolean with
-// the verification result and an string with an associated message with the reason
-// of a negative result.
-func VerifyChain(tree *CertTree) (bool, string) {
+// Roots field is nil, the OS trust store is used. The function returns a
+// *VerificationResult with the valid paths or the reason of a negative result.
+func VerifyChain(tree *CertTree) *VerificationResult {
+	return VerifyChainWithOptions(tree, VerifyOptions{})
+}
+
+// VerifyChainWithOptions verifies the chain of a certificate as part of a CertTree
+// like VerifyChain, additionally checking the hostname, verification time and
+// extended key usages given in VerifyOptions.
+func VerifyChainWithOptions(tree *CertTree, options VerifyOptions) *VerificationResult {
 	rootPool := x509.NewCertPool()
 	for _, cert := range tree.Roots {
 		rootPool.AddCert(cert)
@@ -538,19 +712,20 @@ func VerifyChain(tree *CertTree) (bool, string) {
 		interPool.AddCert(cert)
 	}
 
-	var verifyOptions x509.VerifyOptions
-	if len(rootPool.Subjects()) != 0 {
+	verifyOptions := x509.VerifyOptions{
+		DNSName:     options.DNSName,
+		CurrentTime: options.CurrentTime,
+		KeyUsages:   options.KeyUsages,
+	}
+	if len(tree.Roots) != 0 {
 		verifyOptions.Roots = rootPool
 	}
-	if len(interPool.Subjects()) != 0 {
+	if len(tree.Intermediates) != 0 {
 		verifyOptions.Intermediates = interPool
 	}
 
-	if _, err := tree.Certificate.Verify(verifyOptions); err != nil {
-		return false, err.Error()
-	}
-
-	return true, ""
+	chains, err := tree.Certificate.Verify(verifyOptions)
+	return newVerificationResult(tree, chains, err)
 }
 
 // VerifyCertAndKey verifies that a certificate (*x509.Certificate) and a key (*pem.Block)
@@ -569,6 +744,30 @@ func VerifyCertAndKey(cert *x509.Certificate, key *pem.Block) bool {
 	return false
 }
 
+// decryptLegacyPEMBlock decrypts a PEM block encrypted with Proc-Type and
+// DEK-Info headers and verifies that it holds a RSA or EC key, as a wrong
+// password is not always detected by the decryption.
+func decryptLegacyPEMBlock(block *pem.Block, password string) (*pem.Block, error) {
+	if block.Type != "RSA PRIVATE KEY" && block.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("unsupported legacy encrypted key (%s)", block.Type)
+	}
+	keyBytes, err := x509.DecryptPEMBlock(block, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		_, err = x509.ParsePKCS1PrivateKey(keyBytes)
+	} else {
+		_, err = x509.ParseECPrivateKey(keyBytes)
+	}
+	if err != nil {
+		return nil, x509.IncorrectPasswordError
+	}
+
+	return &pem.Block{Type: block.Type, Bytes: keyBytes}, nil
+}
+
 // getPKCS8PEMBlock is used to return a *pem.Block with the correct type
 // (so it can be used as reliable metadata).
 func getPKCS8PEMBlock(parsedKey interface{}) (*pem.Block, error) {
@@ -598,3 +797,76 @@ func getPKCS8PEMBlock(parsedKey interface{}) (*pem.Block, error) {
 	}
 	return &pemBlock, nil
 }
+
+// newVerificationResult creates a *VerificationResult from the return values
+// of x509.Certificate.Verify for the given CertTree.
+func newVerificationResult(tree *CertTree, chains [][]*x509.Certificate, err error) *VerificationResult {
+	if err != nil {
+		result := VerificationResult{Failure: FailureOther, FailedCert: tree.Certificate, Err: err}
+		var invalidErr x509.CertificateInvalidError
+		var authorityErr x509.UnknownAuthorityError
+		var hostnameErr x509.HostnameError
+		switch {
+		case errors.As(err, &invalidErr):
+			if invalidErr.Cert != nil {
+				result.FailedCert = invalidErr.Cert
+			}
+			switch invalidErr.Reason {
+			case x509.Expired:
+				result.Failure = FailureExpired
+			case x509.CANotAuthorizedForThisName, x509.NameConstraintsWithoutSANs, x509.UnconstrainedName:
+				result.Failure = FailureNameConstraints
+			case x509.NotAuthorizedToSign, x509.IncompatibleUsage, x509.CANotAuthorizedForExtKeyUsage:
+				result.Failure = FailureBadUsage
+			}
+		case errors.As(err, &authorityErr):
+			result.Failure = FailureUnknownAuthority
+			if authorityErr.Cert != nil {
+				result.FailedCert = authorityErr.Cert
+			}
+		case errors.As(err, &hostnameErr):
+			result.Failure = FailureHostnameMismatch
+			if hostnameErr.Certificate != nil {
+				result.FailedCert = hostnameErr.Certificate
+			}
+		}
+		return &result
+	}
+
+	inTree := map[string]bool{string(tree.Certificate.Raw): true}
+	for _, cert := range tree.Intermediates {
+		inTree[string(cert.Raw)] = true
+	}
+	for _, cert := range tree.Roots {
+		inTree[string(cert.Raw)] = true
+	}
+
+	result := VerificationResult{Verified: true}
+	for _, chain := range chains {
+		path := VerifiedPath{Certificates: chain}
+		for _, cert := range chain {
+			if inTree[string(cert.Raw)] {
+				path.Sources = append(path.Sources, SourceCertTree)
+			} else {
+				path.Sources = append(path.Sources, SourceSystem)
+			}
+		}
+		result.Paths = append(result.Paths, path)
+	}
+	return &result
+}
+
+// parseKeyBlock parses the (unencrypted) private key of a *pem.Block, being
+// PKCS1, PKCS8 or SEC1 encoded.
+func parseKeyBlock(key *pem.Block) (interface{}, error) {
+	if parsedKey, err := x509.ParsePKCS1PrivateKey(key.Bytes); err == nil {
+		return parsedKey, nil
+	}
+	if parsedKey, err := x509.ParsePKCS8PrivateKey(key.Bytes); err == nil {
+		return parsedKey, nil
+	}
+	if parsedKey, err := x509.ParseECPrivateKey(key.Bytes); err == nil {
+		return parsedKey, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
@@ -1,13 +1,17 @@
 package certmin
 
 import (
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/pem"
 	"github.com/youmark/pkcs8"
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 var (
@@ -55,6 +59,13 @@ func TestDecodeCertBytes(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, certs)
 	assert.Contains(t, certs[0].Subject.CommonName, "myserver")
+
+	certBytes, err = ioutil.ReadFile("t/myserver.jks")
+	assert.NoError(t, err)
+	certs, err = DecodeCertBytes(certBytes, testPassword)
+	assert.NoError(t, err)
+	assert.NotNil(t, certs)
+	assert.Contains(t, certs[0].Subject.CommonName, "myserver")
 }
 
 func TestDecodeCertBytesPKCS1DER(t *testing.T) {
@@ -159,6 +170,26 @@ func TestDecodeKeyBytes(t *testing.T) {
 	if assert.NotNil(t, key) {
 		assert.Contains(t, key.Type, "PRIVATE KEY")
 	}
+
+	keyBytes, err = ioutil.ReadFile("t/ca.key")
+	assert.NoError(t, err)
+	assert.NotNil(t, keyBytes)
+	key, err = DecodeKeyBytes(keyBytes, testPassword)
+	assert.NoError(t, err)
+	if assert.NotNil(t, key) {
+		assert.Equal(t, "RSA PRIVATE KEY", key.Type)
+		assert.Empty(t, key.Headers)
+	}
+	_, err = DecodeKeyBytes(keyBytes, "")
+	assert.Error(t, err)
+
+	keyBytes, err = ioutil.ReadFile("t/ecdsa_prime256v1.jceks")
+	assert.NoError(t, err)
+	key, err = DecodeKeyBytes(keyBytes, testPassword)
+	assert.NoError(t, err)
+	if assert.NotNil(t, key) {
+		assert.Equal(t, "PRIVATE KEY", key.Type)
+	}
 }
 
 func TestDecodeKeyBytesPKCS1(t *testing.T) {
@@ -170,6 +201,94 @@ func TestDecodeKeyBytesPKCS1(t *testing.T) {
 	if assert.NotNil(t, key) {
 		assert.Equal(t, "RSA PRIVATE KEY", key.Type)
 	}
+	keyBytes, err = ioutil.ReadFile("t/ca.key")
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesPKCS1(keyBytes)
+	assert.Error(t, err)
+}
+
+func TestDecodeKeyBytesDER(t *testing.T) {
+	tests := []struct {
+		keyFile, certFile, keyType string
+	}{
+		{"t/myserver_key.der", "t/myserver.crt", "RSA PRIVATE KEY"},
+		{"t/myserver_key_pkcs8.der", "t/myserver.crt", "PRIVATE KEY"},
+		{"t/myserver_key_enc.der", "t/myserver.crt", "PRIVATE KEY"},
+		{"t/ecdsa_prime256v1_key.der", "t/ecdsa_prime256v1.crt", "EC PRIVATE KEY"},
+		{"t/ecdsa_prime256v1_key_pkcs8.der", "t/ecdsa_prime256v1.crt", "PRIVATE KEY"},
+		{"t/ecdsa_prime256v1_key_enc.der", "t/ecdsa_prime256v1.crt", "PRIVATE KEY"},
+		{"t/ecdsa_secp384r1_key.der", "t/ecdsa_secp384r1.crt", "EC PRIVATE KEY"},
+		{"t/ecdsa_secp384r1_key_pkcs8.der", "t/ecdsa_secp384r1.crt", "PRIVATE KEY"},
+		{"t/ecdsa_secp384r1_key_enc.der", "t/ecdsa_secp384r1.crt", "PRIVATE KEY"},
+		{"t/ed25519_key_pkcs8.der", "t/ed25519.crt", "PRIVATE KEY"},
+		{"t/ed25519_key_enc.der", "t/ed25519.crt", "PRIVATE KEY"},
+	}
+	for _, test := range tests {
+		keyBytes, err := ioutil.ReadFile(test.keyFile)
+		assert.NoError(t, err)
+		key, err := DecodeKeyBytesDER(keyBytes, testPassword)
+		if !assert.NoError(t, err, test.keyFile) {
+			continue
+		}
+		assert.Equal(t, test.keyType, key.Type, test.keyFile)
+		certs, err := DecodeCertFile(test.certFile, "")
+		assert.NoError(t, err)
+		assert.True(t, VerifyCertAndKey(certs[0], key), test.keyFile)
+	}
+
+	keyBytes, err := ioutil.ReadFile("t/myserver_key_enc.der")
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesDER(keyBytes, "wrong")
+	assert.Error(t, err)
+	keyBytes, err = ioutil.ReadFile("t/myserver.der")
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesDER(keyBytes, "")
+	assert.Error(t, err)
+	keyBytes, err = ioutil.ReadFile("t/myserver.key")
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesDER(keyBytes, "")
+	assert.Error(t, err)
+}
+
+func TestDecodeKeyBytesLegacyPEM(t *testing.T) {
+	certs, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	keyBytes, err := ioutil.ReadFile("t/ca.key")
+	assert.NoError(t, err)
+	key, err := DecodeKeyBytesLegacyPEM(keyBytes, testPassword)
+	assert.NoError(t, err)
+	if assert.NotNil(t, key) {
+		assert.Equal(t, "RSA PRIVATE KEY", key.Type)
+		assert.True(t, VerifyCertAndKey(certs[0], key))
+	}
+
+	certs, err = DecodeCertFile("t/ecdsa_prime256v1.crt", "")
+	assert.NoError(t, err)
+	keyBytes, err = ioutil.ReadFile("t/ecdsa_prime256v1_legacy_enc.key")
+	assert.NoError(t, err)
+	key, err = DecodeKeyBytesLegacyPEM(keyBytes, testPassword)
+	assert.NoError(t, err)
+	if assert.NotNil(t, key) {
+		assert.Equal(t, "EC PRIVATE KEY", key.Type)
+		assert.True(t, VerifyCertAndKey(certs[0], key))
+	}
+
+	_, err = DecodeKeyBytesLegacyPEM(keyBytes, "wrong")
+	assert.Equal(t, x509.IncorrectPasswordError, err)
+	keyBytes, err = ioutil.ReadFile("t/myserver.key")
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesLegacyPEM(keyBytes, testPassword)
+	assert.Error(t, err)
+
+	// An unsupported block type is not reported as a wrong password
+	block, err := x509.EncryptPEMBlock(rand.Reader, "PRIVATE KEY", []byte("foo"),
+		[]byte(testPassword), x509.PEMCipherAES256)
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesLegacyPEM(pem.EncodeToMemory(block), testPassword)
+	if assert.Error(t, err) {
+		assert.NotEqual(t, x509.IncorrectPasswordError, err)
+		assert.Contains(t, err.Error(), "unsupported legacy encrypted key")
+	}
 }
 
 func TestDecodeKeyBytesPKCS8(t *testing.T) {
@@ -209,6 +328,66 @@ func TestDecodeKeyFile(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, key)
 	assert.Contains(t, key.Type, "PRIVATE KEY")
+
+	key, err = DecodeKeyFile("t/ecdsa_secp384r1_key.der", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Equal(t, "EC PRIVATE KEY", key.Type)
+
+	key, err = DecodeKeyFile("t/ed25519_key_enc.der", testPassword)
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Contains(t, key.Type, "PRIVATE KEY")
+}
+
+func TestEncodeAsPKCS12(t *testing.T) {
+	caCerts, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"t/myserver", "t/ecdsa_prime256v1", "t/ed25519"} {
+		certs, err := DecodeCertFile(name+".crt", "")
+		assert.NoError(t, err)
+		key, err := DecodeKeyFile(name+".key", "")
+		assert.NoError(t, err)
+		for _, encryption := range []string{PKCS12EncryptionLegacy, PKCS12EncryptionAES} {
+			pfx, err := EncodeAsPKCS12(certs[0], caCerts, key, testPassword, encryption)
+			if !assert.NoError(t, err, name+" "+encryption) {
+				continue
+			}
+			decodedKey, decodedCert, decodedCACerts, err := pkcs12.DecodeChain(pfx, testPassword)
+			if assert.NoError(t, err, name+" "+encryption) {
+				assert.NotNil(t, decodedKey)
+				assert.Equal(t, certs[0].Raw, decodedCert.Raw)
+				assert.Equal(t, len(caCerts), len(decodedCACerts))
+			}
+			_, _, _, err = pkcs12.DecodeChain(pfx, "wrong")
+			assert.Error(t, err)
+		}
+	}
+
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	key, err := DecodeKeyFile("t/myserver.key", "")
+	assert.NoError(t, err)
+	_, err = EncodeAsPKCS12(certs[0], caCerts, key, testPassword, "foo")
+	assert.Error(t, err)
+	_, err = EncodeAsPKCS12(certs[0], caCerts, nil, testPassword, PKCS12EncryptionAES)
+	assert.Error(t, err)
+	_, err = EncodeAsPKCS12(nil, caCerts, key, testPassword, PKCS12EncryptionAES)
+	assert.Error(t, err)
+}
+
+func TestEncodeCertAsPKCS1DER(t *testing.T) {
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	bytes, err := EncodeCertAsPKCS1DER(certs[0])
+	assert.NoError(t, err)
+	decoded, err := DecodeCertBytesPKCS1DER(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, certs, decoded)
+
+	_, err = EncodeCertAsPKCS1DER(nil)
+	assert.Error(t, err)
 }
 
 func TestEncodeCertAsPKCS1PEM(t *testing.T) {
@@ -219,6 +398,43 @@ func TestEncodeCertAsPKCS1PEM(t *testing.T) {
 	assert.Contains(t, string(bytes), "-BEGIN CERTIFICATE-")
 }
 
+func TestEncodeCertsAsPKCS1PEM(t *testing.T) {
+	certs, err := DecodeCertFile("t/chain.crt", "")
+	assert.NoError(t, err)
+	bytes, err := EncodeCertsAsPKCS1PEM(certs)
+	assert.NoError(t, err)
+	decoded, err := DecodeCertBytesPKCS1PEM(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, len(certs), len(decoded))
+
+	_, err = EncodeCertsAsPKCS1PEM(nil)
+	assert.Error(t, err)
+}
+
+func TestEncodeCertsAsPKCS7DER(t *testing.T) {
+	certs, err := DecodeCertFile("t/chain.crt", "")
+	assert.NoError(t, err)
+	bytes, err := EncodeCertsAsPKCS7DER(certs)
+	assert.NoError(t, err)
+	decoded, err := DecodeCertBytesPKCS7DER(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, len(certs), len(decoded))
+
+	_, err = EncodeCertsAsPKCS7DER(nil)
+	assert.Error(t, err)
+}
+
+func TestEncodeCertsAsPKCS7PEM(t *testing.T) {
+	certs, err := DecodeCertFile("t/chain.crt", "")
+	assert.NoError(t, err)
+	bytes, err := EncodeCertsAsPKCS7PEM(certs)
+	assert.NoError(t, err)
+	assert.Contains(t, string(bytes), "-BEGIN PKCS7-")
+	decoded, err := DecodeCertBytesPKCS7PEM(bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, len(certs), len(decoded))
+}
+
 func TestEncodeKeyAsPKCS1PEM(t *testing.T) {
 	key, err := DecodeKeyFile("t/myserver.key", testPassword)
 	assert.NoError(t, err)
@@ -239,6 +455,40 @@ func TestEncodeKeyAsPKCS1PEM(t *testing.T) {
 	assert.Contains(t, string(bytes), "PRIVATE KEY")
 }
 
+func TestEncodeKeyAsPKCS8DER(t *testing.T) {
+	key, err := DecodeKeyFile("t/ecdsa_prime256v1.key", "")
+	assert.NoError(t, err)
+	bytes, err := EncodeKeyAsPKCS8DER(key, "")
+	assert.NoError(t, err)
+	_, err = x509.ParsePKCS8PrivateKey(bytes)
+	assert.NoError(t, err)
+
+	bytes, err = EncodeKeyAsPKCS8DER(key, testPassword)
+	assert.NoError(t, err)
+	_, err = pkcs8.ParsePKCS8PrivateKey(bytes, []byte(testPassword))
+	assert.NoError(t, err)
+
+	_, err = EncodeKeyAsPKCS8DER(nil, "")
+	assert.Error(t, err)
+}
+
+func TestEncodeKeyAsPKCS8PEM(t *testing.T) {
+	key, err := DecodeKeyFile("t/myserver.key", "")
+	assert.NoError(t, err)
+	bytes, err := EncodeKeyAsPKCS8PEM(key, "")
+	assert.NoError(t, err)
+	assert.Contains(t, string(bytes), "-BEGIN PRIVATE KEY-")
+
+	bytes, err = EncodeKeyAsPKCS8PEM(key, testPassword)
+	assert.NoError(t, err)
+	assert.Contains(t, string(bytes), "-BEGIN ENCRYPTED PRIVATE KEY-")
+	decoded, err := DecodeKeyBytes(bytes, testPassword)
+	assert.NoError(t, err)
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	assert.True(t, VerifyCertAndKey(certs[0], decoded))
+}
+
 func TestFindLeaf(t *testing.T) {
 	certs, err := DecodeCertFile("t/chain-out-of-order.crt", "")
 	assert.NotNil(t, certs)
@@ -294,20 +544,28 @@ func TestSortCertsAsChains(t *testing.T) {
 	certs, err := DecodeCertFile("t/chain-out-of-order.crt", "")
 	assert.NotNil(t, certs)
 	assert.NoError(t, err)
-	ordered := SortCerts(certs, false)
-	assert.NotNil(t, ordered)
 
-	chainAsCerts, certsByName, order := SortCertsAsChains(certs, false)
-	assert.NotNil(t, chainAsCerts)
-	assert.NotNil(t, certsByName)
-	assert.NotNil(t, order)
-	assert.Contains(t, chainAsCerts[ordered[0].Subject.String()][0].Subject.String(), "exporl.med.kuleuven.be")
+	chains, graph := SortCertsAsChains(certs, false)
+	assert.NotNil(t, graph)
+	if assert.Equal(t, 3, len(chains)) {
+		assert.Equal(t, 4, len(chains[0]))
+		assert.Contains(t, chains[0][0].Subject.CommonName, "exporl.med.kuleuven.be")
+		assert.Equal(t, "AAA Certificate Services", chains[0][3].Subject.CommonName)
+		// The root is not part of the bundle
+		assert.Equal(t, 2, len(chains[1]))
+		assert.Equal(t, "DigiCert Global CA G2", chains[1][0].Subject.CommonName)
+		assert.Equal(t, "Sectigo RSA Domain Validation Secure Server CA", chains[2][0].Subject.CommonName)
+	}
+
+	chains, _ = SortCertsAsChains(certs, true)
+	if assert.Equal(t, 3, len(chains)) {
+		assert.Equal(t, "AAA Certificate Services", chains[0][0].Subject.CommonName)
+		assert.Contains(t, chains[0][3].Subject.CommonName, "exporl.med.kuleuven.be")
+	}
 
-	chainAsCerts, certsByName, order = SortCertsAsChains(certs, true)
-	assert.NotNil(t, chainAsCerts)
-	assert.NotNil(t, certsByName)
-	assert.NotNil(t, order)
-	assert.Contains(t, chainAsCerts[ordered[0].Subject.String()][0].Subject.CommonName, "AAA Certificate Services")
+	chains, graph = SortCertsAsChains(nil, false)
+	assert.Empty(t, chains)
+	assert.Empty(t, graph.Certificates)
 }
 
 func TestSplitCertsAsTree(t *testing.T) {
@@ -327,20 +585,72 @@ func TestVerifyChain(t *testing.T) {
 	assert.NoError(t, err)
 	certs, err := DecodeCertFile("t/myserver.crt", "")
 	assert.NoError(t, err)
-	verified, output := VerifyChain(&CertTree{
+	result := VerifyChain(&CertTree{
 		Certificate: certs[0],
 		Roots:       ca,
 	})
-	assert.Equal(t, "", output)
+	assert.False(t, result.Verified)
+	assert.Equal(t, FailureExpired, result.Failure)
+	assert.Equal(t, certs[0], result.FailedCert)
+	assert.Error(t, result.Err)
 
-	certs, err = DecodeCertFile("t/myserver-fromca2.crt", "")
+	certs, err = DecodeCertFile("t/ecdsa_prime256v1.crt", "")
 	assert.NoError(t, err)
-	verified, output = VerifyChain(&CertTree{
+	result = VerifyChain(&CertTree{
 		Certificate: certs[0],
 		Roots:       ca,
 	})
-	assert.False(t, verified)
-	assert.NotEqual(t, "", output)
+	assert.False(t, result.Verified)
+	assert.Equal(t, FailureUnknownAuthority, result.Failure)
+	assert.Equal(t, certs[0], result.FailedCert)
+
+	result = VerifyChain(&CertTree{
+		Certificate: certs[0],
+		Roots:       certs,
+	})
+	assert.True(t, result.Verified)
+	assert.Equal(t, FailureNone, result.Failure)
+	assert.NoError(t, result.Err)
+	if assert.Equal(t, 1, len(result.Paths)) {
+		assert.Equal(t, certs[0], result.Paths[0].Certificates[0])
+		assert.Equal(t, []string{SourceCertTree}, result.Paths[0].Sources)
+	}
+}
+
+func TestVerifyChainWithOptions(t *testing.T) {
+	ca, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	tree := &CertTree{Certificate: certs[0], Roots: ca}
+	validTime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	result := VerifyChainWithOptions(tree, VerifyOptions{CurrentTime: validTime})
+	assert.True(t, result.Verified)
+	assert.NoError(t, result.Err)
+	if assert.Equal(t, 1, len(result.Paths)) {
+		assert.Equal(t, 2, len(result.Paths[0].Certificates))
+		assert.Equal(t, []string{SourceCertTree, SourceCertTree}, result.Paths[0].Sources)
+	}
+
+	result = VerifyChainWithOptions(tree, VerifyOptions{CurrentTime: validTime, DNSName: "myserver"})
+	assert.True(t, result.Verified)
+
+	result = VerifyChainWithOptions(tree, VerifyOptions{CurrentTime: validTime, DNSName: "otherserver"})
+	assert.False(t, result.Verified)
+	assert.Equal(t, FailureHostnameMismatch, result.Failure)
+	assert.Equal(t, certs[0], result.FailedCert)
+
+	result = VerifyChainWithOptions(tree, VerifyOptions{
+		CurrentTime: validTime,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.False(t, result.Verified)
+	assert.Equal(t, FailureBadUsage, result.Failure)
+
+	result = VerifyChainWithOptions(tree, VerifyOptions{CurrentTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.False(t, result.Verified)
+	assert.Equal(t, FailureExpired, result.Failure)
 }
 
 func TestVerifyCertAndKey(t *testing.T) {
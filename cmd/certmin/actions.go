@@ -2,32 +2,700 @@ package main
 
 import (
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
-	"github.com/fatih/color"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/nxadm/certmin"
 )
 
 // actionFunc is a type for actions and their expected output as string and error.
 type actionFunc func() (string, error)
 
+// Exit statuses of monitoring plugins (Nagios/Icinga).
+const (
+	statusOK       = 0
+	statusWarning  = 1
+	statusCritical = 2
+	statusUnknown  = 3
+)
+
+// statusNames are the names of the exit statuses of monitoring plugins.
+var statusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// exitStatus is an error returned by actions that need a specific exit status.
+// The status is already reported in the output of the action.
+type exitStatus int
+
+func (status exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(status))
+}
+
+// bundleCerts completes the chain of a local or remote certificate location,
+// verifies it and writes an ordered bundle in the requested layout.
+func bundleCerts(input string, params Params) (string, error) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{Location: input}
+	reports := []*certmin.LocationReport{report}
+
+	loc, _, remote, err := getLocation(input)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+
+	var certs []*x509.Certificate
+	password := params.inPassword
+	if remote {
+		var warn error
+		certs, _, warn, err = getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
+	} else {
+		certs, password, err = decodeCertFile(loc, password)
+	}
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	tree, err := getCertTree(certs, params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+
+	options := certmin.VerifyOptions{DNSName: params.hostname, CurrentTime: params.at, KeyUsages: params.usages}
+	verification := certmin.VerifyChainWithOptions(tree, options)
+	if !verification.Verified {
+		retrieved, err := completeCertTree(tree)
+		if err != nil {
+			sb.WriteString(color.YellowString("the chain could not be completed ("+err.Error()+")") + "\n")
+		}
+		if retrieved > 0 {
+			sb.WriteString(fmt.Sprintf("%d intermediate(s) retrieved from the Issuer Certificate URLs\n", retrieved))
+			verification = certmin.VerifyChainWithOptions(tree, options)
+		}
+	}
+	if verification.Verified {
+		msg := "certificate " + certName(tree.Certificate) + " and its chain match\n"
+		sb.WriteString(color.GreenString(msg))
+	} else {
+		msg := "certificate " + certName(tree.Certificate) + " and its chain do not match\n"
+		sb.WriteString(color.RedString(msg))
+	}
+	printVerificationResult(verification, &sb)
+	report.Verification = certmin.NewVerificationReport(verification)
+	if !verification.Verified && !params.force {
+		return renderOutput(&sb, reports, params.output,
+			errors.New("the bundle was not written as its chain does not verify (see --force)"))
+	}
+
+	// The verified path is ordered from the leaf to the root
+	var bundle []*x509.Certificate
+	if verification.Verified {
+		bundle = verification.Paths[0].Certificates
+	} else {
+		all := append([]*x509.Certificate{tree.Certificate}, tree.Intermediates...)
+		graph := certmin.NewCertGraph(append(all, tree.Roots...))
+		bundle = graph.Paths(tree.Certificate)[0]
+	}
+	root := bundle[len(bundle)-1]
+	if len(bundle) > 1 && certmin.IsRootCA(root) && params.layout != layoutFullChain {
+		bundle = bundle[:len(bundle)-1]
+	} else if params.layout == layoutFullChain && !certmin.IsRootCA(root) {
+		sb.WriteString(color.YellowString("no root found: the bundle ends with an intermediate") + "\n")
+	}
+
+	var bundleBytes []byte
+	if params.layout == layoutHAProxy {
+		var privateKey *certmin.PrivateKey
+		switch {
+		case params.key != "":
+			privateKey, err = decodeKeyFile(params.key, params.inPassword)
+			if err != nil {
+				return renderOutput(&sb, reports, params.output, err)
+			}
+		case !remote:
+			privateKey, _ = certmin.DecodePrivateKeyFile(loc, password) // e.g. the key of a PKCS12 file
+		}
+		if privateKey == nil {
+			return renderOutput(&sb, reports, params.output,
+				errors.New("the haproxy layout requires a key (--key)"))
+		}
+		if err = certmin.VerifyCertAndPrivateKey(tree.Certificate, privateKey); err != nil {
+			return renderOutput(&sb, reports, params.output, fmt.Errorf(
+				"certificate %s and its key do not match (%s)", certName(tree.Certificate), err))
+		}
+		key, err := privateKey.PEMBlock()
+		if err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+		if bundleBytes, err = certmin.EncodeKeyAsPKCS8PEM(key, params.outPassword); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+	}
+	certBytes, err := certmin.EncodeCertsAsPKCS1PEM(bundle)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	bundleBytes = append(bundleBytes, certBytes...)
+
+	bundleFile := params.out
+	if bundleFile == "" {
+		name := tree.Certificate.Subject.CommonName
+		if name == "" && len(tree.Certificate.DNSNames) > 0 {
+			name = tree.Certificate.DNSNames[0]
+		}
+		bundleFile = fileBaseName(name) + map[string]string{
+			layoutChain: ".chain.pem", layoutFullChain: ".fullchain.pem", layoutHAProxy: ".haproxy.pem"}[params.layout]
+	}
+	perm := os.FileMode(0644)
+	if params.layout == layoutHAProxy {
+		perm = 0600
+	}
+	if err = ioutil.WriteFile(bundleFile, bundleBytes, perm); err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	for _, cert := range bundle {
+		report.Certificates = append(report.Certificates, certmin.NewCertReport(cert))
+	}
+	report.Files = []string{bundleFile}
+
+	sb.WriteString("---\nThe following files were written:\n" + bundleFile + "\n")
+	return renderOutput(&sb, reports, params.output, nil)
+}
+
+// caInit creates a self-signed root CA and writes its certificate and key.
+func caInit(params Params) (string, error) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{}
+	reports := []*certmin.LocationReport{report}
+
+	profile, err := newCertProfile(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	key, isNewKey, err := newKey(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	ca, err := certmin.NewRootCA(profile, key)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	if !isNewKey {
+		key = nil
+	}
+	err = writeIssuedCert(ca.CertTree(), key, params, report, &sb)
+	return renderOutput(&sb, reports, params.output, err)
+}
+
+// caIssue issues an intermediate CA or a leaf certificate with the CA given
+// with --ca and writes it with its chain and key.
+func caIssue(params Params) (string, error) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{}
+	reports := []*certmin.LocationReport{report}
+
+	ca, err := loadCA(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	profile, err := newCertProfile(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	key, isNewKey, err := newKey(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+
+	var tree *certmin.CertTree
+	if params.intermediate {
+		var inter *certmin.CA
+		if inter, err = ca.IssueIntermediateCA(profile, key); err == nil {
+			tree = inter.CertTree()
+		}
+	} else {
+		tree, err = ca.IssueCert(profile, key.Signer.Public())
+	}
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	if !isNewKey {
+		key = nil
+	}
+	err = writeIssuedCert(tree, key, params, report, &sb)
+	return renderOutput(&sb, reports, params.output, err)
+}
+
+// caSign signs a CSR with the CA given with --ca and writes the certificate
+// with its chain.
+func caSign(csrFile string, params Params) (string, error) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{Location: csrFile}
+	reports := []*certmin.LocationReport{report}
+
+	csr, err := certmin.DecodeCSRFile(csrFile)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	ca, err := loadCA(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	profile, err := newCertProfile(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	tree, err := ca.SignCSR(csr, profile)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	err = writeIssuedCert(tree, nil, params, report, &sb)
+	return renderOutput(&sb, reports, params.output, err)
+}
+
+// checkExpiry checks the expiry of local or remote certificates and their chain
+// against the warning and critical thresholds. It returns a single line status
+// with performance data and an exitStatus, as expected by monitoring plugins.
+func checkExpiry(locations []string, params Params) (string, error) {
+	now := time.Now()
+	status := statusOK
+	var criticals, warnings, perfData []string
+	var checked int
+	var earliest *x509.Certificate
+
+	for _, input := range locations {
+		var sb strings.Builder // warnings are not relevant for the status
+		certs, err := getCerts(input, &sb)
+		if err == nil && params.follow {
+			certs, err = certmin.RetrieveChainFromIssuerURLs(certs[0], timeOut)
+		}
+		if err != nil {
+			return fmt.Sprintf("EXPIRY %s - %s: %s", statusNames[statusUnknown], input, err),
+				exitStatus(statusUnknown)
+		}
+
+		for _, cert := range certs {
+			checked++
+			left := cert.NotAfter.Sub(now)
+			certStatus := statusOK
+			switch {
+			case left <= params.critical:
+				certStatus = statusCritical
+			case left <= params.warning:
+				certStatus = statusWarning
+			}
+			if certStatus > status {
+				status = certStatus
+			}
+			description := fmt.Sprintf("%s (%s) %s", certName(cert), input, expiryDescription(left))
+			switch certStatus {
+			case statusCritical:
+				criticals = append(criticals, description)
+			case statusWarning:
+				warnings = append(warnings, description)
+			}
+			if earliest == nil || cert.NotAfter.Before(earliest.NotAfter) {
+				earliest = cert
+			}
+			perfData = append(perfData, fmt.Sprintf("'%s'=%ds;%d:;%d:", perfDataLabel(input, cert),
+				int64(left.Seconds()), int64(params.warning.Seconds()), int64(params.critical.Seconds())))
+		}
+	}
+
+	if checked == 0 {
+		return "EXPIRY " + statusNames[statusUnknown] + " - no certificates found", exitStatus(statusUnknown)
+	}
+
+	summary := strings.Join(append(criticals, warnings...), ", ")
+	if status == statusOK {
+		summary = fmt.Sprintf("%d certificates checked, first: %s %s", checked,
+			certName(earliest), expiryDescription(earliest.NotAfter.Sub(now)))
+	}
+	output := fmt.Sprintf("EXPIRY %s - %s | %s", statusNames[status], summary, strings.Join(perfData, " "))
+	if status == statusOK {
+		return output, nil
+	}
+	return output, exitStatus(status)
+}
+
+// expiryUnknown returns an action reporting an error (e.g. in the arguments of
+// check-expiry) with the UNKNOWN status, as expected by monitoring plugins.
+func expiryUnknown(err error) actionFunc {
+	return func() (string, error) {
+		return "EXPIRY " + statusNames[statusUnknown] + " - " + err.Error(), exitStatus(statusUnknown)
+	}
+}
+
+// checkRevocation checks the revocation status of local or remote certificates
+// by querying the OCSP servers found in the certificates.
+func checkRevocation(locations []string, params Params) (string, error) {
+	var sb strings.Builder
+	var reports []*certmin.LocationReport
+	for _, input := range locations {
+		report := &certmin.LocationReport{Location: input}
+		reports = append(reports, report)
+		sb.WriteString("\nCertificate location " + input + ":\n\n")
+		certs, _, warn, err := getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
+		if err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+
+		if params.follow {
+			certs, err = certmin.RetrieveChainFromIssuerURLs(certs[0], timeOut)
+			if err != nil {
+				return renderOutput(&sb, reports, params.output, err)
+			}
+		}
+
+		tree, err := getCertTree(certs, params)
+		if err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+
+		sb.WriteString("certificate " + tree.Certificate.Subject.CommonName + ":\n")
+		result, err := certmin.CheckOCSP(tree, timeOut)
+		printOCSPResult(result, err, &sb)
+		report.Certificates = []*certmin.CertReport{certmin.NewCertReport(tree.Certificate)}
+		report.OCSP = certmin.NewOCSPResultReport(result, err)
+		sb.WriteString("---\n")
+	}
+
+	return renderOutput(&sb, reports, params.output, nil)
+}
+
+// convertCerts converts a local or remote certificate location, with its chain
+// and optionally a key, to DER, PEM, PKCS7 or PKCS12 files.
+func convertCerts(input string, params Params) (string, error) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{Location: input}
+	reports := []*certmin.LocationReport{report}
+
+	loc, _, remote, err := getLocation(input)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+
+	var certs []*x509.Certificate
+	password := params.inPassword
+	if remote {
+		var warn error
+		certs, _, warn, err = getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
+	} else {
+		certs, password, err = decodeCertFile(loc, password)
+	}
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	tree := certmin.SplitCertsAsTree(certs)
+	if tree == nil || tree.Certificate == nil {
+		return renderOutput(&sb, reports, params.output, errors.New("no certificate found"))
+	}
+	caCerts := append(tree.Intermediates, tree.Roots...)
+	ordered := append([]*x509.Certificate{tree.Certificate}, caCerts...)
+
+	var privateKey *certmin.PrivateKey
+	switch {
+	case params.key != "":
+		privateKey, err = decodeKeyFile(params.key, params.inPassword)
+		if err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+	case !remote:
+		privateKey, _ = certmin.DecodePrivateKeyFile(loc, password) // e.g. the key of a PKCS12 file
+	}
+	var key *pem.Block
+	if privateKey != nil {
+		if err = certmin.VerifyCertAndPrivateKey(tree.Certificate, privateKey); err != nil {
+			return renderOutput(&sb, reports, params.output, fmt.Errorf(
+				"certificate %s and its key do not match (%s)", tree.Certificate.Subject.CommonName, err))
+		}
+		if key, err = privateKey.PEMBlock(); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+	}
+
+	certFile := params.out
+	if certFile == "" {
+		certFile = fileBaseName(tree.Certificate.Subject.CommonName) + map[string]string{
+			formatDER: ".der", formatPEM: ".crt", formatPKCS7: ".p7b", formatPKCS12: ".p12",
+			formatJKS: ".jks"}[params.to]
+	}
+	keyFile := strings.TrimSuffix(certFile, filepath.Ext(certFile))
+
+	var certBytes, keyBytes []byte
+	switch params.to {
+	case formatDER:
+		if len(ordered) > 1 {
+			sb.WriteString(color.YellowString("DER holds a single certificate: only the leaf is converted") + "\n")
+		}
+		certBytes, err = certmin.EncodeCertAsPKCS1DER(tree.Certificate)
+		if err == nil && key != nil {
+			keyBytes, err = certmin.EncodeKeyAsPKCS8DER(key, params.outPassword)
+			keyFile += "_key.der"
+		}
+	case formatPEM:
+		certBytes, err = certmin.EncodeCertsAsPKCS1PEM(ordered)
+		if err == nil && key != nil {
+			keyBytes, err = certmin.EncodeKeyAsPKCS8PEM(key, params.outPassword)
+			keyFile += ".key"
+		}
+	case formatPKCS7:
+		if params.key != "" {
+			return renderOutput(&sb, reports, params.output, errors.New("PKCS7 can not hold a key"))
+		}
+		if key != nil {
+			sb.WriteString(color.YellowString("PKCS7 holds certificates only: the key is not converted") + "\n")
+		}
+		certBytes, err = certmin.EncodeCertsAsPKCS7DER(ordered)
+	case formatPKCS12:
+		if key == nil {
+			return renderOutput(&sb, reports, params.output,
+				errors.New("a PKCS12 conversion requires a key (--key)"))
+		}
+		certBytes, err = certmin.EncodeAsPKCS12(
+			tree.Certificate, caCerts, key, params.outPassword, params.pkcs12Encryption)
+	case formatJKS:
+		if params.key != "" {
+			return renderOutput(&sb, reports, params.output, errors.New("a JKS truststore can not hold a key"))
+		}
+		if key != nil {
+			sb.WriteString(color.YellowString("A JKS truststore holds certificates only: the key is not converted") + "\n")
+		}
+		certBytes, err = certmin.EncodeCertsAsJKS(ordered, params.outPassword)
+	}
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	if keyBytes != nil && keyFile == certFile {
+		return renderOutput(&sb, reports, params.output,
+			fmt.Errorf("the key can not be written to the certificate file (%s)", certFile))
+	}
+
+	perm := os.FileMode(0644)
+	if params.to == formatPKCS12 || params.to == formatJKS {
+		perm = 0600
+	}
+	if err = ioutil.WriteFile(certFile, certBytes, perm); err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	report.Files = append(report.Files, certFile)
+	if keyBytes != nil {
+		if err = ioutil.WriteFile(keyFile, keyBytes, 0600); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+		report.Files = append(report.Files, keyFile)
+	}
+
+	sb.WriteString("The following files were written:\n")
+	sb.WriteString(strings.Join(report.Files, "\n") + "\n")
+	return renderOutput(&sb, reports, params.output, nil)
+}
+
+// diagnoseChain compares the certificates served by local or remote locations
+// with the chain that should be served and reports the findings.
+func diagnoseChain(locations []string, params Params) (string, error) {
+	var sb strings.Builder
+	var reports []*certmin.LocationReport
+	for _, input := range locations {
+		report := &certmin.LocationReport{Location: input}
+		reports = append(reports, report)
+		sb.WriteString("\nCertificate location " + input + ":\n\n")
+		certs, _, warn, err := getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
+		if err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+
+		diagnosis, err := certmin.DiagnoseChain(certs, timeOut)
+		if err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+		printDiagnosis(diagnosis, &sb)
+		report.Diagnosis = certmin.NewDiagnosisReport(diagnosis)
+		sb.WriteString("---\n")
+	}
+
+	return renderOutput(&sb, reports, params.output, nil)
+}
+
+// newCSR generates a certificate signing request with a new or an existing key
+// and writes the CSR and the new key (if any) to files.
+func newCSR(params Params) (string, error) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{}
+	reports := []*certmin.LocationReport{report}
+
+	template := &certmin.CSRTemplate{}
+	var err error
+	if params.template != "" {
+		if template, err = loadCSRTemplate(params.template); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+	}
+	if err = parseSubject(params.subject, template); err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	for _, san := range params.sans {
+		if err = parseSAN(san, template); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+	}
+
+	key, isNewKey, err := newKey(params)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+
+	csr, err := certmin.NewCSR(template, key)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	csrBytes, err := certmin.EncodeCSRAsPEM(csr)
+	if err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+
+	csrFile := params.out
+	if csrFile == "" {
+		name := template.CommonName
+		if name == "" && len(csr.DNSNames) > 0 {
+			name = csr.DNSNames[0]
+		}
+		csrFile = fileBaseName(name) + ".csr"
+	}
+	report.Location = csrFile
+	report.CSR = certmin.NewCSRReport(csr)
+
+	var keyFile string
+	var keyBytes []byte
+	if isNewKey {
+		if keyFile, keyBytes, err = encodeNewKey(key, csrFile, params.outPassword); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+	}
+
+	if err = ioutil.WriteFile(csrFile, csrBytes, 0644); err != nil {
+		return renderOutput(&sb, reports, params.output, err)
+	}
+	report.Files = append(report.Files, csrFile)
+	if keyBytes != nil {
+		if err = ioutil.WriteFile(keyFile, keyBytes, 0600); err != nil {
+			return renderOutput(&sb, reports, params.output, err)
+		}
+		report.Files = append(report.Files, keyFile)
+	}
+
+	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	printCSR(csr, w)
+	fmt.Fprint(w, "---\n")
+	w.Flush()
+	sb.WriteString("The following files were written:\n")
+	sb.WriteString(strings.Join(report.Files, "\n") + "\n")
+	return renderOutput(&sb, reports, params.output, nil)
+}
+
+// serveMetrics retrieves the targets of a configuration file periodically and
+// exposes the results as Prometheus metrics on /metrics.
+func serveMetrics(configFile string, params Params) (string, error) {
+	config, err := loadMetricsConfig(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	collector := newMetricsCollector(config)
+	stop := make(chan struct{})
+	defer close(stop)
+	collector.run(stop)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", collector)
+	return "", http.ListenAndServe(config.Listen, mux)
+}
+
 // skimCerts prints relevant information of local or remote certificates,
 // optionally including a remote chain.
 func skimCerts(locations []string, params Params) (string, error) {
 	var sb strings.Builder
+	var reports []*certmin.LocationReport
 	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
 
 	for _, input := range locations {
+		if crl, err := certmin.DecodeCRLFile(input); err == nil {
+			reports = append(reports, &certmin.LocationReport{Location: input, CRL: certmin.NewCRLReport(crl)})
+			sb.WriteString("\nCRL location " + input + ":\n\n")
+			printCRL(crl, w)
+			fmt.Fprint(w, "---\n")
+			continue
+		}
+
+		if csr, err := certmin.DecodeCSRFile(input); err == nil {
+			reports = append(reports, &certmin.LocationReport{Location: input, CSR: certmin.NewCSRReport(csr)})
+			sb.WriteString("\nCSR location " + input + ":\n\n")
+			printCSR(csr, w)
+			fmt.Fprint(w, "---\n")
+			continue
+		}
+
+		if sshKeys, err := certmin.DecodeSSHKeyFile(input); err == nil {
+			report := &certmin.LocationReport{Location: input}
+			reports = append(reports, report)
+			sb.WriteString("\nOpenSSH key location " + input + ":\n\n")
+			for idx, sshKey := range sshKeys {
+				printSSHKey(sshKey, w)
+				report.SSHKeys = append(report.SSHKeys, certmin.NewSSHKeyReport(sshKey))
+				if idx < len(sshKeys)-1 {
+					fmt.Fprintln(w, "\t")
+				}
+			}
+			fmt.Fprint(w, "---\n")
+			continue
+		}
+
 		var certs []*x509.Certificate
 		colourKeeper := make(colourKeeper)
 
+		report := &certmin.LocationReport{Location: input}
+		reports = append(reports, report)
 		sb.WriteString("\nCertificate location " + input + ":\n\n")
-		certs, err := getCerts(input, &sb)
+		certs, info, warn, err := getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
 		if err != nil {
 			w.Flush()
-			return sb.String(), err
+			return renderOutput(&sb, reports, params.output, err)
+		}
+		if info != nil {
+			report.Connection = certmin.NewConnectionReport(info)
+			printConnection(info, w)
+			fmt.Fprintln(w, "\t")
+		}
+		if keyStore := getKeyStore(input); info == nil && keyStore != nil {
+			report.KeyStore = certmin.NewKeyStoreReport(keyStore)
+			printKeyStore(keyStore, w)
+			fmt.Fprintln(w, "\t")
 		}
 
 		if params.leaf || params.follow { // We only want the leaf
@@ -41,10 +709,10 @@ func skimCerts(locations []string, params Params) (string, error) {
 		}
 
 		if params.follow {
-			certs, err = certmin.RetrieveChainFromIssuerURLs(certs[0], timeOut)
+			certs, err = retrieveChain(certs[0], params)
 			if err != nil {
 				w.Flush()
-				return sb.String(), err
+				return renderOutput(&sb, reports, params.output, err)
 			}
 		}
 
@@ -57,145 +725,227 @@ func skimCerts(locations []string, params Params) (string, error) {
 					certs = certmin.SortCerts(certs, true)
 				}
 			} else {
-				var chainAsCerts map[string][]*x509.Certificate
-				var order []string
-				switch {
-				case params.sort:
-					chainAsCerts, _, order = certmin.SortCertsAsChains(certs, false)
-				case params.rsort:
-					chainAsCerts, _, order = certmin.SortCertsAsChains(certs, true)
-				}
-
+				chains, _ := certmin.SortCertsAsChains(certs, params.rsort)
 				var tmpCerts []*x509.Certificate
-				for _, subj := range order {
-					tmpCerts = append(tmpCerts, chainAsCerts[subj]...)
+				for _, chain := range chains {
+					tmpCerts = append(tmpCerts, chain...)
 				}
 				certs = tmpCerts
 			}
 		}
 
-		for idx, cert := range certs {
+		shown := certs
+		if params.noRoots {
+			shown = stripRoots(certs)
+		}
+
+		for idx, cert := range shown {
 			printCert(cert, w, colourKeeper)
-			if idx < len(certs)-1 {
+			certReport := certmin.NewCertReport(cert)
+			report.Certificates = append(report.Certificates, certReport)
+			scts, err := getSCTs(cert, info)
+			if err != nil {
+				fmt.Fprintf(w, "SCTs:\tinvalid (%s)\n", err)
+			} else if len(scts) > 0 {
+				tree := &certmin.CertTree{Certificate: cert, Intermediates: certs}
+				results := verifySCTs(scts, tree, params.ctLogs)
+				printSCTs(results, w)
+				for _, result := range results {
+					certReport.SCTs = append(certReport.SCTs, certmin.NewSCTReport(result))
+				}
+			}
+			if idx < len(shown)-1 {
 				fmt.Fprintln(w, "\t")
 			}
 		}
+		if params.paths {
+			paths, err := findChainPaths(certs, params)
+			if err != nil {
+				w.Flush()
+				return renderOutput(&sb, reports, params.output, err)
+			}
+			fmt.Fprintln(w, "\t")
+			w.Flush()
+			printChainPaths(paths, &sb)
+			for _, path := range paths {
+				report.ChainPaths = append(report.ChainPaths, certmin.NewChainPathReport(path))
+			}
+		}
 		fmt.Fprint(w, "---\n")
 
 		if params.keep {
-			output, err := writeCertFiles(certs, false)
+			output, files, err := writeCertFiles(shown, false)
 			if err != nil {
 				w.Flush()
-				return sb.String(), err
+				return renderOutput(&sb, reports, params.output, err)
 			}
 			sb.WriteString("\n" + output)
+			report.Files = files
 		}
 
 	}
 
 	w.Flush()
-	return sb.String(), nil
+	return renderOutput(&sb, reports, params.output, nil)
 }
 
 // verifyChain verifies that local or remote certificates match their chain,
 // supplied as local files, system-trust and/or remotely.
 func verifyChain(locations []string, params Params) (string, error) {
 	var sb strings.Builder
+	var reports []*certmin.LocationReport
 	for _, input := range locations {
-		var certs []*x509.Certificate
+		report := &certmin.LocationReport{Location: input}
+		reports = append(reports, report)
 		sb.WriteString("\nCertificate location " + input + ":\n\n")
-		certs, err := getCerts(input, &sb)
+		certs, info, warn, err := getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
 		if err != nil {
-			return sb.String(), err
+			return renderOutput(&sb, reports, params.output, err)
 		}
 
 		cert := certs[0]
 		if params.follow {
-			certs, err = certmin.RetrieveChainFromIssuerURLs(cert, timeOut)
+			certs, err = retrieveChain(cert, params)
 			if err != nil {
-				return sb.String(), err
+				return renderOutput(&sb, reports, params.output, err)
 			}
 		}
 
-		tree := certmin.SplitCertsAsTree(certs)
-		result, err := appendToCertTree(tree.Roots, params.roots)
+		tree, err := getCertTree(certs, params)
 		if err != nil {
-			return sb.String(), err
+			return renderOutput(&sb, reports, params.output, err)
 		}
-		tree.Roots = result
-		result, err = appendToCertTree(tree.Intermediates, params.inters)
-		if err != nil {
-			return sb.String(), err
-		}
-		tree.Intermediates = result
 
-		verified, _ := certmin.VerifyChain(tree)
-		if verified {
+		verification := certmin.VerifyChainWithOptions(tree, certmin.VerifyOptions{
+			DNSName:     params.hostname,
+			CurrentTime: params.at,
+			KeyUsages:   params.usages,
+		})
+		if verification.Verified {
 			msg := "certificate " + cert.Subject.CommonName + " and its chain match\n"
 			sb.WriteString(color.GreenString((msg)))
 		} else {
 			msg := "certificate " + cert.Subject.CommonName + " and its chain do not match\n"
 			sb.WriteString(color.RedString((msg)))
 		}
+		if params.noRoots {
+			stripVerifiedRoots(verification)
+		}
+		printVerificationResult(verification, &sb)
+		report.Verification = certmin.NewVerificationReport(verification)
+		if params.paths {
+			paths, err := findChainPaths(certs, params)
+			if err != nil {
+				return renderOutput(&sb, reports, params.output, err)
+			}
+			printChainPaths(paths, &sb)
+			for _, path := range paths {
+				report.ChainPaths = append(report.ChainPaths, certmin.NewChainPathReport(path))
+			}
+		}
+		if params.ocsp {
+			ocspResult, err := certmin.CheckOCSP(tree, timeOut)
+			printOCSPResult(ocspResult, err, &sb)
+			report.OCSP = certmin.NewOCSPResultReport(ocspResult, err)
+		}
+		if params.crl {
+			crlResult, err := certmin.CheckCRL(tree, timeOut)
+			printCRLResult(crlResult, err, &sb)
+			report.CRLStatus = certmin.NewCRLResultReport(crlResult, err)
+		}
+		if params.ctLogs != nil {
+			scts, err := getSCTs(tree.Certificate, info)
+			if err != nil {
+				sb.WriteString(color.YellowString("SCTs: could not be parsed (" + err.Error() + ")\n"))
+			}
+			results := certmin.VerifySCTs(scts, tree, params.ctLogs)
+			printCTPolicyResult(results, params.ctPolicy, &sb)
+			report.CTPolicy = certmin.NewCTPolicyReport(results, params.ctPolicy)
+		}
 		sb.WriteString("---\n")
 
 		if params.keep {
-			output, err := writeCertFiles(certs, false)
+			if params.noRoots {
+				certs = stripRoots(certs)
+			}
+			output, files, err := writeCertFiles(certs, false)
 			if err != nil {
-				return sb.String(), err
+				return renderOutput(&sb, reports, params.output, err)
 			}
 			sb.WriteString("\n" + output)
+			report.Files = files
 		}
 
 	}
 
-	return sb.String(), nil
+	return renderOutput(&sb, reports, params.output, nil)
 }
 
 // verifyKey verifies a local or remote certificate and a key match
 func verifyKey(keyFile string, locations []string, params Params) (string, error) {
 	var sb strings.Builder
-	key, err := certmin.DecodeKeyFile(keyFile, "")
+	var reports []*certmin.LocationReport
+	key, err := decodeKeyFile(keyFile, "")
 	if err != nil {
-		passwordBytes, err := promptForKeyPassword()
-		if err != nil {
-			return "", err
-		}
-
-		key, err = certmin.DecodeKeyFile(keyFile, string(passwordBytes))
-		if err != nil {
-			return "", err
-		}
+		return "", err
 	}
 
 	for _, input := range locations {
-		var certs []*x509.Certificate
+		report := &certmin.LocationReport{Location: input}
+		reports = append(reports, report)
 		sb.WriteString("\nCertificate location " + input + ":\n\n")
-		certs, err := getCerts(input, &sb)
+		if csr, err := certmin.DecodeCSRFile(input); err == nil {
+			printKeyMatch(keyFile, "CSR "+csr.Subject.CommonName,
+				certmin.VerifyCSRAndPrivateKey(csr, key), report, &sb)
+			sb.WriteString("---\n")
+			continue
+		}
+		if sshKeys, err := certmin.DecodeSSHKeyFile(input); err == nil {
+			printKeyMatch(keyFile, sshKeyName(sshKeys[0]),
+				certmin.VerifySSHKeyAndPrivateKey(sshKeys[0].PublicKey, key), report, &sb)
+			sb.WriteString("---\n")
+			continue
+		}
+		certs, _, warn, err := getCertsAndConnection(input)
+		if warn != nil {
+			report.Warning = warn.Error()
+			sb.WriteString(color.YellowString(warn.Error()) + "\n")
+		}
 		if err != nil {
-			return sb.String(), err
+			return renderOutput(&sb, reports, params.output, err)
 		}
 		cert := certs[0]
 
-		verified := certmin.VerifyCertAndKey(cert, key)
-		if verified {
+		err = certmin.VerifyCertAndPrivateKey(cert, key)
+		report.KeyMatch = &certmin.KeyMatchReport{
+			Key:         keyFile,
+			Certificate: cert.Subject.String(),
+			Match:       err == nil,
+		}
+		if err == nil {
 			msg := "certificate " + cert.Subject.CommonName + " and its key match\n"
 			sb.WriteString(color.GreenString((msg)))
 		} else {
-			msg := "certificate " + cert.Subject.CommonName + " and its key do not match\n"
+			report.KeyMatch.Reason = err.Error()
+			msg := "certificate " + cert.Subject.CommonName + " and its key do not match: " + err.Error() + "\n"
 			sb.WriteString(color.RedString((msg)))
 		}
 		sb.WriteString("---\n")
 
 		if params.keep {
-			output, err := writeCertFiles([]*x509.Certificate{cert}, false)
+			output, files, err := writeCertFiles([]*x509.Certificate{cert}, false)
 			if err != nil {
-				return sb.String(), err
+				return renderOutput(&sb, reports, params.output, err)
 			}
 			sb.WriteString("\n" + output)
+			report.Files = files
 		}
 
 	}
 
-	return sb.String(), nil
+	return renderOutput(&sb, reports, params.output, nil)
 }
@@ -1,10 +1,432 @@
 package main
 
-import "testing"
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
 
-func TestSkim(t *testing.T)        { t.SkipNow() }
-func TestVerifyChain(t *testing.T) { t.SkipNow() }
-func TestVerifyKey(t *testing.T)   { t.SkipNow() }
+	"github.com/nxadm/certmin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleCerts(t *testing.T) {
+	dir := t.TempDir()
+	var interCert []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(interCert)
+	}))
+	defer server.Close()
+
+	root, inter, leaf, leafKey := newTestChain(t, server.URL)
+	interCert = inter.Certificate.Raw
+	leafFile := writeTestCerts(t, dir, "leaf.crt", leaf)
+	rootFile := writeTestCerts(t, dir, "root.crt", root.Certificate)
+	keyBlock, err := leafKey.PEMBlock()
+	assert.NoError(t, err)
+	keyBytes, err := certmin.EncodeKeyAsPKCS8PEM(keyBlock, "")
+	assert.NoError(t, err)
+	keyFile := filepath.Join(dir, "leaf.key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, keyBytes, 0600))
+
+	countPEM := func(file, blockType string) int {
+		content, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		return strings.Count(string(content), "-----BEGIN "+blockType+"-----")
+	}
+
+	// The intermediate is retrieved from the Issuer Certificate URLs
+	params := Params{roots: []string{rootFile}, layout: layoutChain, out: filepath.Join(dir, "chain.pem")}
+	output, err := bundleCerts(leafFile, params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "1 intermediate(s) retrieved from the Issuer Certificate URLs")
+	assert.Contains(t, output, "certificate leaf and its chain match")
+	certs, err := certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{leaf, inter.Certificate}, certs)
+
+	params.layout = layoutFullChain
+	params.out = filepath.Join(dir, "fullchain.pem")
+	_, err = bundleCerts(leafFile, params)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, countPEM(params.out, "CERTIFICATE"))
+
+	params.layout = layoutHAProxy
+	_, err = bundleCerts(leafFile, params)
+	assert.Error(t, err) // no key
+	params.key = keyFile
+	params.out = filepath.Join(dir, "haproxy.pem")
+	_, err = bundleCerts(leafFile, params)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countPEM(params.out, "PRIVATE KEY"))
+	assert.Equal(t, 2, countPEM(params.out, "CERTIFICATE"))
+
+	// Without a trusted root, the bundle is only written when forced
+	params = Params{layout: layoutChain, out: filepath.Join(dir, "forced.pem")}
+	_, err = bundleCerts(leafFile, params)
+	assert.Error(t, err)
+	assert.NoFileExists(t, params.out)
+	params.force = true
+	output, err = bundleCerts(leafFile, params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "certificate leaf and its chain do not match")
+	assert.Equal(t, 2, countPEM(params.out, "CERTIFICATE"))
+
+	// A forced bundle follows the issuers up from the certificate
+	interFile := writeTestCerts(t, dir, "inter.crt", inter.Certificate)
+	params = Params{inters: []string{leafFile}, layout: layoutChain, force: true,
+		out: filepath.Join(dir, "forced-inter.pem")}
+	_, err = bundleCerts(interFile, params)
+	assert.NoError(t, err)
+	certs, err = certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{inter.Certificate}, certs)
+}
+
+func TestCA(t *testing.T) {
+	dir := t.TempDir()
+	params := Params{
+		subject:    "CN=certmin root,O=certmin",
+		keyType:    "ecdsa",
+		maxPathLen: -1,
+		out:        filepath.Join(dir, "root.crt"),
+	}
+	output, err := caInit(params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "certificate certmin root and its chain match")
+	assert.FileExists(t, filepath.Join(dir, "root.key"))
+	_, err = caInit(params)
+	assert.Error(t, err)
+
+	params = Params{
+		caCert:       filepath.Join(dir, "root.crt"),
+		intermediate: true,
+		subject:      "CN=certmin intermediate",
+		maxPathLen:   0,
+		permittedDNS: []string{"example.com"},
+		out:          filepath.Join(dir, "inter.crt"),
+		outPassword:  "1234",
+	}
+	_, err = caIssue(params)
+	assert.NoError(t, err)
+	certs, err := certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(certs)) {
+		assert.True(t, certs[0].MaxPathLenZero)
+		assert.Equal(t, []string{"example.com"}, certs[0].PermittedDNSDomains)
+		assert.Equal(t, "certmin root", certs[1].Subject.CommonName)
+	}
+
+	params = Params{
+		caCert:     filepath.Join(dir, "inter.crt"),
+		inPassword: "1234",
+		sans:       []string{"www.example.com"},
+		keyType:    "ed25519",
+		maxPathLen: -1,
+		usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ocspURLs:   []string{"http://ocsp.example.com"},
+		out:        filepath.Join(dir, "www.crt"),
+		output:     outputJSON,
+	}
+	output, err = caIssue(params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, `"verified": true`)
+	certs, err = certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	if assert.Equal(t, 3, len(certs)) {
+		assert.Equal(t, []string{"www.example.com"}, certs[0].DNSNames)
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, certs[0].ExtKeyUsage)
+		assert.Equal(t, []string{"http://ocsp.example.com"}, certs[0].OCSPServer)
+		key, err := certmin.DecodePrivateKeyFile(filepath.Join(dir, "www.key"), "")
+		assert.NoError(t, err)
+		assert.NoError(t, certmin.VerifyCertAndPrivateKey(certs[0], key))
+	}
+
+	// An intermediate with MaxPathLen 0 can not issue intermediates
+	params.intermediate = true
+	params.subject = "CN=sub"
+	params.out = filepath.Join(dir, "sub.crt")
+	_, err = caIssue(params)
+	assert.Error(t, err)
+
+	params = Params{
+		caCert:     filepath.Join(dir, "inter.crt"),
+		caKey:      filepath.Join(dir, "inter.key"),
+		inPassword: "1234",
+		maxPathLen: -1,
+		validity:   48 * time.Hour,
+		out:        filepath.Join(dir, "signed.crt"),
+	}
+	output, err = caSign("../../t/myserver.csr", params)
+	assert.NoError(t, err)
+	// myserver is not within the permitted DNS domains of the intermediate
+	assert.Contains(t, output, "and its chain do not match")
+	certs, err = certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "myserver", certs[0].Subject.CommonName)
+	assert.Equal(t, 48*time.Hour, certs[0].NotAfter.Sub(certs[0].NotBefore))
+	assert.NoFileExists(t, filepath.Join(dir, "signed.key"))
+
+	_, err = caSign("../../t/myserver_bad_signature.csr", params)
+	assert.Error(t, err)
+	params.caCert = "../../t/myserver.crt"
+	params.caKey = "../../t/myserver.key"
+	_, err = caSign("../../t/myserver.csr", params)
+	assert.Error(t, err)
+}
+
+func TestCheckExpiry(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "certmin expiry"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(60 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := certmin.EncodeCertAsPKCS1PEM(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(t.TempDir(), "expiry.crt")
+	if err := ioutil.WriteFile(certFile, pemBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	params := Params{warning: 30 * 24 * time.Hour, critical: 7 * 24 * time.Hour}
+	output, err := checkExpiry([]string{certFile}, params)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^EXPIRY OK - 1 certificates checked, first: certmin expiry expires in 5\\d days \\| "+
+		"'.*expiry.crt certmin expiry'=\\d+s;2592000:;604800:$", output)
+
+	params.warning = 90 * 24 * time.Hour
+	output, err = checkExpiry([]string{certFile}, params)
+	assert.Equal(t, exitStatus(statusWarning), err)
+	assert.Contains(t, output, "EXPIRY WARNING - certmin expiry")
+
+	output, err = checkExpiry([]string{certFile, "t/myserver.crt"}, params)
+	assert.Equal(t, exitStatus(statusCritical), err)
+	assert.Contains(t, output, "EXPIRY CRITICAL - myserver (t/myserver.crt) expired")
+	assert.Contains(t, output, "certmin expiry (")
+
+	output, err = checkExpiry([]string{"t/doesnotexist.crt"}, params)
+	var status exitStatus
+	assert.True(t, errors.As(err, &status))
+	assert.Equal(t, exitStatus(statusUnknown), status)
+	assert.Contains(t, output, "EXPIRY UNKNOWN - t/doesnotexist.crt")
+}
+
+func TestConvertCerts(t *testing.T) {
+	dir := t.TempDir()
+	params := Params{
+		to:               formatPKCS12,
+		key:              "../../t/myserver.key",
+		out:              filepath.Join(dir, "myserver.p12"),
+		outPassword:      "secret",
+		pkcs12Encryption: certmin.PKCS12EncryptionAES,
+	}
+	output, err := convertCerts("../../t/myserver.crt", params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, params.out)
+
+	// The key is taken from the PKCS12 file
+	params = Params{
+		to:          formatPEM,
+		out:         filepath.Join(dir, "myserver.pem"),
+		inPassword:  "secret",
+		outPassword: "1234",
+		output:      outputJSON,
+	}
+	output, err = convertCerts(filepath.Join(dir, "myserver.p12"), params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, filepath.Join(dir, "myserver.key"))
+	key, err := certmin.DecodeKeyFile(filepath.Join(dir, "myserver.key"), "1234")
+	assert.NoError(t, err)
+	certs, err := certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	assert.True(t, certmin.VerifyCertAndKey(certs[0], key))
+
+	params = Params{to: formatPKCS7, out: filepath.Join(dir, "myserver.p7b")}
+	_, err = convertCerts(filepath.Join(dir, "myserver.pem"), params)
+	assert.NoError(t, err)
+	certs, err = certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(certs))
+
+	params = Params{to: formatDER, out: filepath.Join(dir, "myserver.der")}
+	_, err = convertCerts(filepath.Join(dir, "myserver.p7b"), params)
+	assert.NoError(t, err)
+	certs, err = certmin.DecodeCertFile(params.out, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(certs))
+
+	params = Params{to: formatJKS, outPassword: "secret", out: filepath.Join(dir, "truststore.jks")}
+	_, err = convertCerts("../../t/myserver.jks", params)
+	assert.NoError(t, err)
+	trustStore, err := ioutil.ReadFile(params.out)
+	assert.NoError(t, err)
+	keyStore, err := certmin.DecodeKeyStore(trustStore, "secret", "")
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(keyStore.Entries)) {
+		assert.Equal(t, certmin.KeyStoreEntryTrustedCert, keyStore.Entries[0].Type)
+	}
+	params.key = "../../t/myserver.key"
+	_, err = convertCerts("../../t/myserver.crt", params)
+	assert.Error(t, err)
+
+	// Mismatched key
+	params = Params{to: formatPEM, key: "../../t/myserver-fromca2.key", out: filepath.Join(dir, "mismatch.pem")}
+	_, err = convertCerts("../../t/myserver.crt", params)
+	assert.Error(t, err)
+
+	// PKCS12 without a key
+	params = Params{to: formatPKCS12, outPassword: "secret", out: filepath.Join(dir, "nokey.p12")}
+	_, err = convertCerts("../../t/myserver.crt", params)
+	assert.Error(t, err)
+}
+
+func TestNewCSR(t *testing.T) {
+	dir := t.TempDir()
+	params := Params{
+		template:    "../../t/csr_template.yaml",
+		sans:        []string{"admin@example.com"},
+		keyType:     "ecdsa",
+		out:         filepath.Join(dir, "myserver.csr"),
+		outPassword: "1234",
+	}
+	output, err := newCSR(params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, filepath.Join(dir, "myserver.key"))
+	csr, err := certmin.DecodeCSRFile(params.out)
+	assert.NoError(t, err)
+	assert.Equal(t, "myserver", csr.Subject.CommonName)
+	assert.Equal(t, []string{"admin@example.com"}, csr.EmailAddresses)
+	key, err := certmin.DecodePrivateKeyFile(filepath.Join(dir, "myserver.key"), "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, certmin.KeyFormatEncryptedPKCS8, key.Format)
+	assert.NoError(t, certmin.VerifyCSRAndPrivateKey(csr, key))
+
+	// The key file is not overwritten
+	_, err = newCSR(params)
+	assert.Error(t, err)
+
+	// An existing key
+	params = Params{
+		subject: "/CN=myserver",
+		key:     "../../t/myserver.key",
+		out:     filepath.Join(dir, "existing.csr"),
+		output:  outputJSON,
+	}
+	output, err = newCSR(params)
+	assert.NoError(t, err)
+	assert.Contains(t, output, `"public_key": "RSA 2048 bits"`)
+	assert.NoFileExists(t, filepath.Join(dir, "existing.key"))
+	csr, err = certmin.DecodeCSRFile(params.out)
+	assert.NoError(t, err)
+	key, err = certmin.DecodePrivateKeyFile(params.key, "")
+	assert.NoError(t, err)
+	assert.NoError(t, certmin.VerifyCSRAndPrivateKey(csr, key))
+
+	params = Params{subject: "CN=myserver", keyType: "rsa:1024", out: filepath.Join(dir, "weak.csr")}
+	_, err = newCSR(params)
+	assert.Error(t, err)
+	params = Params{subject: "FOO=bar", out: filepath.Join(dir, "foo.csr")}
+	_, err = newCSR(params)
+	assert.Error(t, err)
+}
+
+func TestSkim(t *testing.T) {
+	dir := t.TempDir()
+	root, inter, leaf, _ := newTestChain(t)
+	certFile := writeTestCerts(t, dir, "chain.crt", leaf, inter.Certificate, root.Certificate)
+	rootFile := writeTestCerts(t, dir, "root.crt", root.Certificate)
+
+	// The paths are found with the roots, but they are not shown
+	output, err := skimCerts([]string{certFile}, Params{paths: true, noRoots: true, roots: []string{rootFile}})
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Path 1: ends in a root of the --root files")
+	assert.Contains(t, output, "  CN=intermediate (")
+	assert.NotContains(t, output, "  CN=root (")
+}
+
+func TestVerifyChain(t *testing.T) {
+	dir := t.TempDir()
+	root, inter, leaf, _ := newTestChain(t)
+	certFile := writeTestCerts(t, dir, "chain.crt", leaf, inter.Certificate, root.Certificate)
+	rootFile := writeTestCerts(t, dir, "root.crt", root.Certificate)
+
+	// The root served in the location is used for the verification
+	output, err := verifyChain([]string{certFile}, Params{})
+	assert.NoError(t, err)
+	assert.Contains(t, output, "certificate leaf and its chain match")
+
+	// --no-roots only changes what is shown
+	output, err = verifyChain([]string{certFile}, Params{noRoots: true, paths: true})
+	assert.NoError(t, err)
+	assert.Contains(t, output, "certificate leaf and its chain match")
+	assert.Contains(t, output, "Path 1: does not end in a trusted root")
+	assert.NotContains(t, output, "  CN=root (")
+
+	// Only the --root files and the OS trust store are trusted for the paths
+	output, err = verifyChain([]string{certFile}, Params{paths: true, roots: []string{rootFile}})
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Path 1: ends in a root of the --root files")
+	assert.Contains(t, output, "  CN=root (")
+}
+
+func TestVerifyKey(t *testing.T) { t.SkipNow() }
+
+// newTestChain returns a root and an intermediate CA, and a leaf certificate
+// (with its key) issued by the intermediate with the given Issuing Certificate
+// URLs.
+func newTestChain(t *testing.T, issuerURLs ...string) (*certmin.CA, *certmin.CA, *x509.Certificate,
+	*certmin.PrivateKey) {
+	newKey := func() *certmin.PrivateKey {
+		key, err := certmin.GeneratePrivateKey(certmin.KeyAlgorithmECDSA, 0, "")
+		assert.NoError(t, err)
+		return key
+	}
+	root, err := certmin.NewRootCA(&certmin.CertProfile{Subject: certmin.CSRTemplate{CommonName: "root"}}, newKey())
+	assert.NoError(t, err)
+	inter, err := root.IssueIntermediateCA(
+		&certmin.CertProfile{Subject: certmin.CSRTemplate{CommonName: "intermediate"}}, newKey())
+	assert.NoError(t, err)
+	leafKey := newKey()
+	tree, err := inter.IssueCert(&certmin.CertProfile{Subject: certmin.CSRTemplate{CommonName: "leaf"},
+		IssuingCertificateURLs: issuerURLs}, leafKey.Signer.Public())
+	assert.NoError(t, err)
+	return root, inter, tree.Certificate, leafKey
+}
+
+// writeTestCerts writes the certificates as PEM to a file in dir and returns
+// the path of the file.
+func writeTestCerts(t *testing.T, dir, name string, certs ...*x509.Certificate) string {
+	certBytes, err := certmin.EncodeCertsAsPKCS1PEM(certs)
+	assert.NoError(t, err)
+	file := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(file, certBytes, 0644))
+	return file
+}
 
 //
 //import (
@@ -1,58 +1,271 @@
 package main
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/nxadm/certmin"
 	flag "github.com/spf13/pflag"
 )
 
+// Output formats.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// Conversion formats.
+const (
+	formatDER    = "der"
+	formatPEM    = "pem"
+	formatPKCS7  = "pkcs7"
+	formatPKCS12 = "pkcs12"
+	formatJKS    = "jks"
+)
+
+// Bundle layouts.
+const (
+	layoutChain     = "chain"
+	layoutFullChain = "fullchain"
+	layoutHAProxy   = "haproxy"
+)
+
 const usage = `certmin, ` + version + `. A minimalist certificate utility.
 See ` + website + ` for more information.
 
 Usage:
-  certmin skim cert-location1 [cert-location2...] 
-    [--leaf|--follow] [--no-roots]
-    [--sort|--rsort] [--once] [--keep] [--no-colour]
+  certmin skim cert-location1 [cert-location2...]
+    [--leaf|--follow] [--no-roots] [--ct-logs=log-list-file]
+    [--sort|--rsort] [--once] [--paths] [--keep] [--output=format]
+    [--no-colour]
   certmin verify-chain cert-location [cert-location2...]
     [--root=ca-file1 --root=ca-file2...]
     [--inter=inter-file1 --inter=inter-file2...]
+    [--hostname=name] [--at=date] [--usage=usage1 --usage=usage2...]
     [--leaf|--follow] [--no-roots]
-    [--sort|--rsort] [--keep] [--no-colour]
+    [--sort|--rsort] [--paths] [--ocsp] [--crl]
+    [--ct-logs=log-list-file --ct-policy=operators] [--keep]
+    [--output=format] [--no-colour]
+  certmin check-revocation cert-location [cert-location2...]
+    [--root=ca-file1 --root=ca-file2...]
+    [--inter=inter-file1 --inter=inter-file2...]
+    [--follow] [--output=format] [--no-colour]
+  certmin diagnose cert-location [cert-location2...]
+    [--output=format] [--no-colour]
+  certmin check-expiry cert-location [cert-location2...]
+    [--warning=duration] [--critical=duration] [--follow]
+  certmin serve-metrics config-file
+  certmin convert cert-location --to=format [--key=key-file] [--out=file]
+    [--in-password=password] [--out-password=password]
+    [--pkcs12-encryption=encryption] [--output=format] [--no-colour]
+  certmin bundle cert-location [--inter=inter-file1 --inter=inter-file2...]
+    [--root=ca-file] [--layout=layout] [--key=key-file] [--hostname=name]
+    [--at=date] [--usage=usage1 --usage=usage2...] [--force] [--out=file]
+    [--in-password=password] [--out-password=password]
+    [--output=format] [--no-colour]
+  certmin new-csr [--subject=subject] [--san=name1 --san=name2...]
+    [--template=file] [--key=key-file|--key-type=type] [--out=file]
+    [--in-password=password] [--out-password=password]
+    [--output=format] [--no-colour]
   certmin verify-key key-file cert-location1 [cert-location2...]
-    [--keep] [--no-colour]
+    [--keep] [--output=format] [--no-colour]
+  certmin ca init [--subject=subject] [--template=file]
+    [--key=key-file|--key-type=type] [--validity=duration]
+    [--max-path-len=number] [--permitted-dns=domain1...]
+    [--excluded-dns=domain1...] [--out=file] [--in-password=password]
+    [--out-password=password] [--output=format] [--no-colour]
+  certmin ca issue --ca=ca-file [--ca-key=key-file] [--intermediate]
+    [--subject=subject] [--san=name1 --san=name2...] [--template=file]
+    [--key=key-file|--key-type=type] [--validity=duration]
+    [--key-usage=usage1...] [--usage=usage1...] [--max-path-len=number]
+    [--permitted-dns=domain1...] [--excluded-dns=domain1...]
+    [--aia-url=url1...] [--ocsp-url=url1...] [--crl-url=url1...]
+    [--out=file] [--in-password=password] [--out-password=password]
+    [--output=format] [--no-colour]
+  certmin ca sign csr-file --ca=ca-file [--ca-key=key-file]
+    [--validity=duration] [--key-usage=usage1...] [--usage=usage1...]
+    [--aia-url=url1...] [--ocsp-url=url1...] [--crl-url=url1...]
+    [--out=file] [--in-password=password] [--output=format] [--no-colour]
   certmin [-h]
   certmin [-v]
 
 Certificate locations can be local files or remote addresses. Remote locations
 can be a hostname with optionally a port attached by ":" (defaults to port
 443) or an URL (scheme://hostname for known schemes like https, ldaps, smtps,
-etc. or scheme://hostname:port for non-standard ports). When verifying a
-chain, the OS trust store will be used if no roots certificates are given as
-files or remotely requested. 
+etc. or scheme://hostname:port for non-standard ports). Plaintext protocols
+with a TLS upgrade (smtp, submission, imap, pop3, ftp, ldap and xmpp) are
+negotiated with STARTTLS, postgres and mysql with their own SSL request.
+When verifying a chain, the OS trust store will be used if no roots
+certificates are given as files or remotely requested.
 
 Actions:
-  skim         | sc : skim certificates (including bundles).
+  skim         | sc : skim certificates (including bundles), CSRs, CRLs
+                      and OpenSSH public keys and certificates.
   verify-chain | vc : match certificates again its chain(s).
-  verify-key   | vk : match keys against certificate(s).
+  verify-key   | vk : match keys against certificate(s), CSRs or OpenSSH
+                      public keys and certificates.
+  check-revocation
+               | cr : check the revocation status of certificate(s) with
+                      the OCSP servers in the certificate(s).
+  diagnose     | dg : diagnose the chain served by a host (or in a file):
+                      missing intermediates (retrieved from the Issuer
+                      Certificate URLs), served roots, certificates out of
+                      order, duplicate or unrelated certificates and a
+                      leaf that is not first, each with a severity (error,
+                      warning or info), and the chain that should be
+                      served.
+  check-expiry | ce : check the expiry of certificate(s) and their chain
+                      with a monitoring plugin (Nagios/Icinga) status line
+                      and exit code (0 OK, 1 warning, 2 critical, 3 unknown).
+  serve-metrics
+               | sm : expose the expiry, chain verification, key size and
+                      signature algorithm of the certificates of the
+                      locations in a YAML configuration file as Prometheus
+                      metrics on /metrics.
+  convert      | co : convert a certificate location (with its chain and
+                      optionally a key) to DER, PEM, PKCS7, PKCS12 or JKS.
+  bundle       | bu : write a correctly ordered bundle (PEM) for a
+                      certificate location, completing its chain with the
+                      --inter files, by following the Issuer Certificate
+                      URLs and with the optional --root file. The bundle
+                      is only written if the chain verifies (see --force).
+  new-csr      | nc : generate a certificate signing request (PEM) with a
+                      new or an existing key.
+  ca                : a minimal CA for test environments. "ca init" creates
+                      a self-signed root CA, "ca issue" issues a leaf
+                      certificate (or an intermediate CA with
+                      --intermediate) with a new or an existing key and
+                      "ca sign" signs a CSR. The certificates are written
+                      (PEM) followed by their chain.
 
 Global options (optional):
   --leaf      | -l  : show only the local or remote leaf, not the chain.
-  --no-roots  | -n  : don't retrieve root certificates.
+  --no-roots  | -n  : leave the self-signed roots out of the shown and kept
+                      (--keep) certificates, of the verified paths and of
+                      the chain paths (--paths), and don't retrieve the
+                      root when following Issuer Certificate URLs.
   --follow    | -f  : follow Issuer Certificate URIs to retrieve chain.
   --root      | -r  : root certificate file(s).
   --inter     | -i  : intermediate certificate file(s).
+  --hostname  | -d  : hostname that must match the certificate when
+                      verifying a chain.
+  --at        | -a  : verify the chain at the given date (YYYY-MM-DD or
+                      RFC3339) instead of now.
+  --usage     | -u  : required extended key usage(s) when verifying a chain:
+                      server (default), client, code-signing, email,
+                      time-stamping, ocsp-signing or any. For ca, the
+                      extended key usage(s) of a leaf certificate (default:
+                      server).
   --sort      | -s  : sort the certificates and chains from leaf to root.
   --rsort     | -z  : sort the certificates and chains from root to leaf.
   --once      | -o  : if within a location several certificates share an
                       intermediate/root, don't show certificates more than
                       once to visually complete the chain. If "rsort" not
                       given it enables "sort".
+  --paths     | -e  : show every possible path from the certificate to a
+                      root (e.g. through the self-signed and the
+                      cross-signed version of a CA), whether it ends in a
+                      root of the OS trust store or of the --root files
+                      and its expired certificates.
+  --ocsp      | -O  : check the revocation status of the certificate with
+                      its OCSP servers when verifying a chain.
+  --crl       | -L  : check the revocation status of the certificate with
+                      its CRL distribution points when verifying a chain.
+  --warning   | -w  : expiry warning threshold as a number of days (e.g. 30
+                      or 30d) or a duration (e.g. 36h). Default: 30 days.
+  --critical  | -C  : expiry critical threshold, see --warning. Default: 7
+                      days. It must not exceed --warning, which defaults
+                      to the critical threshold when it is above 30 days.
+  --ct-logs   | -t  : Certificate Transparency log list (JSON, version 3 as
+                      published by Google or Apple) used to verify the
+                      Signed Certificate Timestamps (SCTs).
+  --ct-policy | -p  : minimum number of valid SCTs from distinct log
+                      operators when verifying a chain (needs --ct-logs).
+  --to        | -T  : conversion format: der (leaf and key only), pem,
+                      pkcs7 (certificates only), pkcs12 (needs a key and
+                      --out-password) or jks (truststore with the
+                      certificates only, needs --out-password).
+  --key       | -K  : key file to convert or bundle with the certificates.
+                      When not given, the key of a local certificate
+                      location (e.g. a PKCS12 file) is used if found. For
+                      new-csr and ca, the existing key to use instead of a
+                      new key.
+  --out       | -F  : file to write the converted, bundled, issued or
+                      signed certificates or the CSR to. The key is
+                      written next to it for der, pem and new keys.
+                      Default: a name based on the Common Name of the leaf
+                      or the CSR.
+  --in-password
+              | -P  : password of the input PKCS12 or JKS file or of an
+                      encrypted key (including the key of the CA).
+                      Prompted for when needed and not given.
+  --out-password
+              | -Q  : password to protect the converted or new key or
+                      the PKCS12 or JKS file.
+  --pkcs12-encryption
+              | -E  : PKCS12 encryption: aes (default, AES-256-CBC with a
+                      SHA-256 MAC) or legacy (RC2/3DES with a SHA-1 MAC,
+                      for older software).
+  --subject   | -S  : subject of a new CSR or certificate as
+                      "CN=name,O=org,C=BE" or "/C=BE/O=org/CN=name"
+                      (fields: CN, C, ST, L, O, OU).
+  --san       | -N  : Subject Alternative Name(s) of a new CSR or
+                      certificate. The type is detected (DNS name, IP
+                      address, email address or URI) or given as prefix:
+                      dns:, ip:, email: or uri:.
+  --template  | -M  : YAML or JSON file with the subject and Subject
+                      Alternative Names of a new CSR or certificate
+                      (common_name, country, province, locality,
+                      organization, organizational_unit, dns_names,
+                      ip_addresses, email_addresses and uris).
+                      --subject and --san add to the template.
+  --key-type  | -Y  : type of the new key of a CSR or certificate: rsa
+                      (default, 2048 bits), rsa:bits, ecdsa (P-256),
+                      ecdsa:curve (P-384 or P-521) or ed25519.
+  --ca        | -A  : certificate file of the CA (optionally followed by
+                      its chain) issuing or signing certificates.
+  --ca-key    | -B  : key file of the CA. Default: the .key file next to
+                      the --ca file (as written by ca init and ca issue).
+  --intermediate
+              | -I  : issue an intermediate CA instead of a leaf.
+  --validity  | -V  : validity of an issued certificate as a number of days
+                      or a duration. Default: 3650 days for a root CA, 1825
+                      for an intermediate CA and 365 for a leaf.
+  --key-usage | -U  : key usage(s) of an issued certificate:
+                      digital-signature, content-commitment,
+                      key-encipherment, data-encipherment, key-agreement,
+                      cert-sign or crl-sign. Default: cert-sign, crl-sign
+                      and digital-signature for a CA, digital-signature
+                      (and key-encipherment for RSA) for a leaf.
+  --max-path-len
+              | -m  : maximum number of intermediate CAs below an issued
+                      CA. Default: no limit.
+  --permitted-dns
+              | -D  : DNS domain(s) an issued CA is limited to.
+  --excluded-dns
+              | -X  : DNS domain(s) an issued CA may not issue for.
+  --aia-url   | -G  : Issuer Certificate URL(s) (AIA) of an issued
+                      certificate.
+  --ocsp-url  | -q  : OCSP server URL(s) of an issued certificate.
+  --crl-url   | -R  : CRL distribution point URL(s) of an issued
+                      certificate.
+  --layout    | -y  : layout of a bundle: chain (default, the leaf followed
+                      by the intermediates), fullchain (the leaf, the
+                      intermediates and the root) or haproxy (the key, the
+                      leaf and the intermediates, needs a key).
+  --force     | -W  : write a bundle even if its chain does not verify.
   --keep      | -k  : write the requested certificates and chains to files
                       as PKCS1 PEM files (converting if necessary).
+  --output    | -x  : output format: text (default), json or yaml. The
+                      json and yaml output is a list with a report per
+                      location (see the LocationReport type of the library).
   --no-colour | -c  : don't colourise the output.
   --help      | -h  : this help message.
   --version   | -v  : version message.
@@ -60,13 +273,37 @@ Global options (optional):
 
 type Params struct {
 	help, progVersion, leaf, follow, noRoots, sort, rsort, once, keep bool
+	paths, ocsp, crl                                                  bool
 	roots, inters                                                     []string
+	hostname                                                          string
+	at                                                                time.Time
+	usages                                                            []x509.ExtKeyUsage
+	ctLogs                                                            *certmin.CTLogList
+	ctPolicy                                                          int
+	output                                                            string
+	warning, critical                                                 time.Duration
+	to, key, out, inPassword, outPassword, pkcs12Encryption           string
+	subject, template, keyType                                        string
+	sans                                                              []string
+	caCert, caKey                                                     string
+	layout                                                            string
+	force                                                             bool
+	intermediate                                                      bool
+	validity                                                          time.Duration
+	keyUsage                                                          x509.KeyUsage
+	maxPathLen                                                        int
+	permittedDNS, excludedDNS, aiaURLs, ocspURLs, crlURLs             []string
 }
 
 // getAction returns an action function, a msg for early exit and an error.
-func getAction() (actionFunc, string, error) {
-	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+func getAction() (action actionFunc, msg string, err error) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	flags.Usage = func() { fmt.Print(usage) }
+	defer func() {
+		if err != nil && isCheckExpiry(flags.Args()) {
+			action, msg, err = expiryUnknown(err), "", nil
+		}
+	}()
 
 	if len(os.Args) == 1 {
 		flags.Usage()
@@ -77,6 +314,9 @@ func getAction() (actionFunc, string, error) {
 	progVersion := flags.BoolP("version", "v", false, "")
 	roots := flags.StringSliceP("root", "r", []string{}, "")
 	inters := flags.StringSliceP("inter", "i", []string{}, "")
+	hostname := flags.StringP("hostname", "d", "", "")
+	at := flags.StringP("at", "a", "", "")
+	usages := flags.StringSliceP("usage", "u", []string{}, "")
 	leaf := flags.BoolP("leaf", "l", false, "")
 	follow := flags.BoolP("follow", "f", false, "")
 	noRoots := flags.BoolP("no-roots", "n", false, "")
@@ -84,11 +324,50 @@ func getAction() (actionFunc, string, error) {
 	rsort := flags.BoolP("rsort", "z", false, "")
 	once := flags.BoolP("once", "o", false, "")
 	keep := flags.BoolP("keep", "k", false, "")
+	paths := flags.BoolP("paths", "e", false, "")
+	ocsp := flags.BoolP("ocsp", "O", false, "")
+	crl := flags.BoolP("crl", "L", false, "")
+	ctLogs := flags.StringP("ct-logs", "t", "", "")
+	ctPolicy := flags.IntP("ct-policy", "p", 0, "")
+	output := flags.StringP("output", "x", outputText, "")
+	warning := flags.StringP("warning", "w", "30d", "")
+	critical := flags.StringP("critical", "C", "7d", "")
+	to := flags.StringP("to", "T", "", "")
+	key := flags.StringP("key", "K", "", "")
+	out := flags.StringP("out", "F", "", "")
+	inPassword := flags.StringP("in-password", "P", "", "")
+	outPassword := flags.StringP("out-password", "Q", "", "")
+	pkcs12Encryption := flags.StringP("pkcs12-encryption", "E", certmin.PKCS12EncryptionAES, "")
+	subject := flags.StringP("subject", "S", "", "")
+	sans := flags.StringSliceP("san", "N", []string{}, "")
+	template := flags.StringP("template", "M", "", "")
+	keyType := flags.StringP("key-type", "Y", "", "")
+	caCert := flags.StringP("ca", "A", "", "")
+	caKey := flags.StringP("ca-key", "B", "", "")
+	intermediate := flags.BoolP("intermediate", "I", false, "")
+	validity := flags.StringP("validity", "V", "", "")
+	keyUsages := flags.StringSliceP("key-usage", "U", []string{}, "")
+	maxPathLen := flags.IntP("max-path-len", "m", -1, "")
+	permittedDNS := flags.StringSliceP("permitted-dns", "D", []string{}, "")
+	excludedDNS := flags.StringSliceP("excluded-dns", "X", []string{}, "")
+	aiaURLs := flags.StringSliceP("aia-url", "G", []string{}, "")
+	ocspURLs := flags.StringSliceP("ocsp-url", "q", []string{}, "")
+	crlURLs := flags.StringSliceP("crl-url", "R", []string{}, "")
+	layout := flags.StringP("layout", "y", layoutChain, "")
+	force := flags.BoolP("force", "W", false, "")
 	noColour := flags.BoolP("no-colour", "c", false, "")
 
-	err := flags.Parse(os.Args)
-	if err != nil {
-		panic(err)
+	// The flag errors of check-expiry are reported with the UNKNOWN status, the
+	// ones of the other actions like with flag.ExitOnError
+	flags.SetOutput(ioutil.Discard)
+	flags.Usage = func() {}
+	if err = flags.Parse(os.Args); err != nil {
+		if !isCheckExpiry(flags.Args()) {
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Print(usage)
+			os.Exit(2)
+		}
+		return nil, "", err
 	}
 
 	if *noColour {
@@ -110,32 +389,135 @@ func getAction() (actionFunc, string, error) {
 		return nil, "", fmt.Errorf("can not find the given file (%s)", strings.Join(notFound, ", "))
 	}
 
+	var atTime time.Time
+	if *at != "" {
+		atTime, err = parseTime(*at)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	extKeyUsages, err := parseExtKeyUsages(*usages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var warningDuration, criticalDuration time.Duration
+	if isCheckExpiry(flags.Args()) {
+		warningDuration, criticalDuration, err = parseThresholds(*warning, *critical, flags.Changed("warning"))
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var validityDuration time.Duration
+	if *validity != "" {
+		if validityDuration, err = parseThreshold(*validity); err != nil {
+			return nil, "", fmt.Errorf("invalid validity (%s)", *validity)
+		}
+	}
+
+	keyUsage, err := parseKeyUsages(*keyUsages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var logList *certmin.CTLogList
+	if *ctLogs != "" {
+		logList, err = certmin.DecodeCTLogListFile(*ctLogs)
+		if err != nil {
+			return nil, "", fmt.Errorf("can not read the CT log list (%s)", err)
+		}
+	}
+
 	params := Params{
-		help:        *help,
-		progVersion: *progVersion,
-		leaf:        *leaf,
-		follow:      *follow,
-		noRoots:     *noRoots,
-		sort:        *sort,
-		rsort:       *rsort,
-		once:        *once,
-		keep:        *keep,
-		roots:       *roots,
-		inters:      *inters,
+		help:             *help,
+		progVersion:      *progVersion,
+		leaf:             *leaf,
+		follow:           *follow,
+		noRoots:          *noRoots,
+		sort:             *sort,
+		rsort:            *rsort,
+		once:             *once,
+		keep:             *keep,
+		paths:            *paths,
+		ocsp:             *ocsp,
+		crl:              *crl,
+		roots:            *roots,
+		inters:           *inters,
+		hostname:         *hostname,
+		at:               atTime,
+		usages:           extKeyUsages,
+		ctLogs:           logList,
+		ctPolicy:         *ctPolicy,
+		output:           *output,
+		warning:          warningDuration,
+		critical:         criticalDuration,
+		to:               *to,
+		key:              *key,
+		out:              *out,
+		inPassword:       *inPassword,
+		outPassword:      *outPassword,
+		pkcs12Encryption: *pkcs12Encryption,
+		subject:          *subject,
+		sans:             *sans,
+		template:         *template,
+		keyType:          *keyType,
+		caCert:           *caCert,
+		caKey:            *caKey,
+		intermediate:     *intermediate,
+		validity:         validityDuration,
+		keyUsage:         keyUsage,
+		maxPathLen:       *maxPathLen,
+		permittedDNS:     *permittedDNS,
+		excludedDNS:      *excludedDNS,
+		aiaURLs:          *aiaURLs,
+		ocspURLs:         *ocspURLs,
+		crlURLs:          *crlURLs,
+		layout:           *layout,
+		force:            *force,
 	}
 	return verifyAndDispatch(params, flags.Args())
 }
 
 // verifyAndDispatch takes the cli parameters, verifies them
 // and returns an action to be run and an possible exit status.
-func verifyAndDispatch(params Params, args []string) (actionFunc, string, error) {
+// Errors in the arguments of check-expiry are reported by an
+// action with the UNKNOWN status, as expected by monitoring plugins.
+func verifyAndDispatch(params Params, args []string) (action actionFunc, msg string, err error) {
+	defer func() {
+		if err != nil && isCheckExpiry(args) {
+			action, msg, err = expiryUnknown(err), "", nil
+		}
+	}()
+
 	cmds := map[string]bool{
-		"sc":           true,
-		"skim":         true,
-		"vc":           true,
-		"verify-chain": true,
-		"vk":           true,
-		"verify-key":   true,
+		"sc":               true,
+		"skim":             true,
+		"vc":               true,
+		"verify-chain":     true,
+		"vk":               true,
+		"verify-key":       true,
+		"cr":               true,
+		"check-revocation": true,
+		"dg":               true,
+		"diagnose":         true,
+		"ce":               true,
+		"check-expiry":     true,
+		"sm":               true,
+		"serve-metrics":    true,
+		"co":               true,
+		"convert":          true,
+		"bu":               true,
+		"bundle":           true,
+		"nc":               true,
+		"new-csr":          true,
+		"ca":               true,
+	}
+	caCmds := map[string]bool{
+		"init":  true,
+		"issue": true,
+		"sign":  true,
 	}
 	var invalidAction bool
 	if len(args) > 1 {
@@ -159,9 +541,83 @@ func verifyAndDispatch(params Params, args []string) (actionFunc, string, error)
 		return nil, "", errors.New("--sort and --rsort are mutually exclusive")
 	case params.once && !(params.sort || params.rsort):
 		return nil, "", errors.New("--once requires --sort and --rsort")
+	case params.output != "" && params.output != outputText &&
+		params.output != outputJSON && params.output != outputYAML:
+		return nil, "", errors.New("--output must be text, json or yaml")
+	case params.ctPolicy > 0 && params.ctLogs == nil:
+		return nil, "", errors.New("--ct-policy requires --ct-logs")
+	case isCheckExpiry(args) && params.critical > params.warning:
+		return nil, "", errors.New("--critical must not exceed --warning")
+	case (args[1] == "serve-metrics" || args[1] == "sm") && len(args) != 3:
+		return nil, "", errors.New("serve-metrics needs 1 configuration file")
+	case args[1] == "serve-metrics" || args[1] == "sm":
+		return func() (string, error) { return serveMetrics(args[2], params) }, "", nil
+
+	case (args[1] == "new-csr" || args[1] == "nc") && len(args) != 2:
+		return nil, "", errors.New("new-csr takes no locations")
+	case (args[1] == "new-csr" || args[1] == "nc") && params.key != "" && params.keyType != "":
+		return nil, "", errors.New("--key and --key-type are mutually exclusive")
+	case (args[1] == "new-csr" || args[1] == "nc") &&
+		params.subject == "" && len(params.sans) == 0 && params.template == "":
+		return nil, "", errors.New("new-csr needs --subject, --san or --template")
+	case args[1] == "new-csr" || args[1] == "nc":
+		return func() (string, error) { return newCSR(params) }, "", nil
+
+	case args[1] == "ca" && (len(args) < 3 || !caCmds[args[2]]):
+		return nil, "", errors.New("ca needs init, issue or sign")
+	case args[1] == "ca" && args[2] == "sign" && len(args) != 4:
+		return nil, "", errors.New("ca sign needs 1 CSR file")
+	case args[1] == "ca" && args[2] != "sign" && len(args) != 3:
+		return nil, "", errors.New("ca " + args[2] + " takes no locations")
+	case args[1] == "ca" && args[2] != "init" && params.caCert == "":
+		return nil, "", errors.New("ca " + args[2] + " needs --ca")
+	case args[1] == "ca" && args[2] != "issue" && params.intermediate:
+		return nil, "", errors.New("--intermediate is only supported by ca issue")
+	case args[1] == "ca" && params.key != "" && params.keyType != "":
+		return nil, "", errors.New("--key and --key-type are mutually exclusive")
+	case args[1] == "ca" && args[2] == "sign" && (params.subject != "" || len(params.sans) > 0 ||
+		params.template != "" || params.key != "" || params.keyType != ""):
+		return nil, "", errors.New("the subject, Subject Alternative Names and key of ca sign are the ones of the CSR")
+	case args[1] == "ca" && args[2] != "sign" &&
+		params.subject == "" && len(params.sans) == 0 && params.template == "":
+		return nil, "", errors.New("ca " + args[2] + " needs --subject, --san or --template")
+	case args[1] == "ca" && args[2] == "init":
+		return func() (string, error) { return caInit(params) }, "", nil
+	case args[1] == "ca" && args[2] == "issue":
+		return func() (string, error) { return caIssue(params) }, "", nil
+	case args[1] == "ca":
+		return func() (string, error) { return caSign(args[3], params) }, "", nil
+
 	case len(args) < 3:
 		return nil, "", errors.New("no certificate location given")
 
+	case (args[1] == "convert" || args[1] == "co") && len(args) != 3:
+		return nil, "", errors.New("convert needs 1 certificate location")
+	case (args[1] == "convert" || args[1] == "co") && params.to != formatDER &&
+		params.to != formatPEM && params.to != formatPKCS7 && params.to != formatPKCS12 &&
+		params.to != formatJKS:
+		return nil, "", errors.New("--to must be der, pem, pkcs7, pkcs12 or jks")
+	case (args[1] == "convert" || args[1] == "co") &&
+		params.pkcs12Encryption != certmin.PKCS12EncryptionAES &&
+		params.pkcs12Encryption != certmin.PKCS12EncryptionLegacy:
+		return nil, "", errors.New("--pkcs12-encryption must be aes or legacy")
+	case (args[1] == "convert" || args[1] == "co") && params.to == formatPKCS12 && params.outPassword == "":
+		return nil, "", errors.New("a PKCS12 conversion requires --out-password")
+	case (args[1] == "convert" || args[1] == "co") && params.to == formatJKS && params.outPassword == "":
+		return nil, "", errors.New("a JKS conversion requires --out-password")
+	case args[1] == "convert" || args[1] == "co":
+		return func() (string, error) { return convertCerts(args[2], params) }, "", nil
+
+	case (args[1] == "bundle" || args[1] == "bu") && len(args) != 3:
+		return nil, "", errors.New("bundle needs 1 certificate location")
+	case (args[1] == "bundle" || args[1] == "bu") && params.layout != layoutChain &&
+		params.layout != layoutFullChain && params.layout != layoutHAProxy:
+		return nil, "", errors.New("--layout must be chain, fullchain or haproxy")
+	case (args[1] == "bundle" || args[1] == "bu") && params.key != "" && params.layout != layoutHAProxy:
+		return nil, "", errors.New("only the haproxy layout holds a key")
+	case args[1] == "bundle" || args[1] == "bu":
+		return func() (string, error) { return bundleCerts(args[2], params) }, "", nil
+
 	case args[1] == "skim" || args[1] == "sc":
 		// Add them quietly
 		locs := args[2:]
@@ -172,6 +628,15 @@ func verifyAndDispatch(params Params, args []string) (actionFunc, string, error)
 	case args[1] == "verify-chain" || args[1] == "vc":
 		return func() (string, error) { return verifyChain(args[2:], params) }, "", nil
 
+	case args[1] == "check-revocation" || args[1] == "cr":
+		return func() (string, error) { return checkRevocation(args[2:], params) }, "", nil
+
+	case args[1] == "diagnose" || args[1] == "dg":
+		return func() (string, error) { return diagnoseChain(args[2:], params) }, "", nil
+
+	case isCheckExpiry(args):
+		return func() (string, error) { return checkExpiry(args[2:], params) }, "", nil
+
 	case (args[1] == "verify-key" || args[1] == "vk") && len(args) < 4:
 		return nil, "", errors.New("verify-key needs 1 key file and at least 1 location")
 	case args[1] == "verify-key" || args[1] == "vk":
@@ -181,3 +646,8 @@ func verifyAndDispatch(params Params, args []string) (actionFunc, string, error)
 		return nil, "", errors.New("unknown command")
 	}
 }
+
+// isCheckExpiry returns true if the action of the arguments is check-expiry.
+func isCheckExpiry(args []string) bool {
+	return len(args) > 1 && (args[1] == "check-expiry" || args[1] == "ce")
+}
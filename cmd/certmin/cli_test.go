@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -64,6 +65,142 @@ func TestVerifyAndDispatch(t *testing.T) {
 	params.sort = false
 	params.rsort = false
 
+	params.warning = time.Hour
+	params.critical = 2 * time.Hour
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "check-expiry", "foo"})
+	assert.Nil(t, err)
+	if assert.NotNil(t, action) {
+		output, err := action()
+		assert.Equal(t, "EXPIRY UNKNOWN - --critical must not exceed --warning", output)
+		assert.Equal(t, exitStatus(statusUnknown), err)
+	}
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "skim", "foo"})
+	assert.NotNil(t, action) // the thresholds are only used by check-expiry
+	assert.Nil(t, err)
+	params.critical = 0
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ce"})
+	assert.Nil(t, err)
+	if assert.NotNil(t, action) {
+		_, err := action()
+		assert.Equal(t, exitStatus(statusUnknown), err)
+	}
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "check-expiry", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "serve-metrics"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "serve-metrics", "foo.yaml"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "convert", "foo", "bar"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "convert", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.to = formatPKCS12
+	params.pkcs12Encryption = "rc4"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "convert", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.pkcs12Encryption = "legacy"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "convert", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.outPassword = "1234"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "co", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	params.to = formatJKS
+	params.outPassword = ""
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "convert", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.outPassword = "1234"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "convert", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	params.to = ""
+	params.outPassword = ""
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "new-csr"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.subject = "CN=myserver"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "new-csr", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.key = "myserver.key"
+	params.keyType = "ed25519"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "nc"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.key = ""
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "new-csr"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	params.subject = ""
+	params.keyType = ""
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "init"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.subject = "CN=root"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "init", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.intermediate = true
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "init"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.intermediate = false
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "init"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "issue"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.caCert = "ca.crt"
+	params.intermediate = true
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "issue"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	params.intermediate = false
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "sign", "myserver.csr"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.subject = ""
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "sign"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "ca", "sign", "myserver.csr"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	params.caCert = ""
+
+	params.output = "xml"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "skim", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.output = ""
+
+	params.ctPolicy = 2
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "verify-chain", "foo"})
+	assert.Nil(t, action)
+	assert.NotNil(t, err)
+	params.ctPolicy = 0
+
 	// illegal verify key
 	action, msg, err = verifyAndDispatch(params, []string{"certmin", "verify-key", "foo"})
 	assert.Nil(t, action)
@@ -89,4 +226,41 @@ func TestVerifyAndDispatch(t *testing.T) {
 	action, msg, err = verifyAndDispatch(params, []string{"certmin", "verify-key", "foo", "bar"})
 	assert.NotNil(t, action)
 	assert.Nil(t, err)
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "check-revocation", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "diagnose", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "dg"})
+	assert.Nil(t, action)
+	assert.Error(t, err)
+
+	params.layout = layoutChain
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "bundle", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "bu", "foo", "bar"})
+	assert.Nil(t, action)
+	assert.Error(t, err)
+
+	params.key = "foo.key"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "bundle", "foo"})
+	assert.Nil(t, action)
+	assert.Error(t, err)
+
+	params.layout = layoutHAProxy
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "bundle", "foo"})
+	assert.NotNil(t, action)
+	assert.Nil(t, err)
+	params.key = ""
+
+	params.layout = "nginx"
+	action, msg, err = verifyAndDispatch(params, []string{"certmin", "bundle", "foo"})
+	assert.Nil(t, action)
+	assert.Error(t, err)
 }
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nxadm/certmin"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the metrics configuration.
+const (
+	defaultMetricsListen      = ":9799"
+	defaultMetricsInterval    = 5 * time.Minute
+	defaultMetricsConcurrency = 4
+)
+
+// metricsConfig is the configuration of serve-metrics, read from a YAML file.
+// Interval and Timeout are the defaults for the targets.
+type metricsConfig struct {
+	Listen      string          `yaml:"listen"`
+	Interval    time.Duration   `yaml:"interval"`
+	Timeout     time.Duration   `yaml:"timeout"`
+	Concurrency int             `yaml:"concurrency"`
+	Targets     []metricsTarget `yaml:"targets"`
+}
+
+// metricsTarget is a location to be monitored. Roots and Inters are added to
+// the retrieved certificates when verifying the chain. Password is used for
+// password protected PKCS12 files.
+type metricsTarget struct {
+	Location string        `yaml:"location"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Password string        `yaml:"password"`
+	Roots    []string      `yaml:"roots"`
+	Inters   []string      `yaml:"inters"`
+}
+
+// targetResult is the result of the last retrieval of a target.
+type targetResult struct {
+	certs     []*x509.Certificate
+	verified  bool
+	err       error
+	timestamp time.Time
+	duration  time.Duration
+}
+
+// metricsCollector retrieves the targets periodically and exposes the results
+// as Prometheus metrics.
+type metricsCollector struct {
+	config    *metricsConfig
+	mutex     sync.RWMutex
+	results   map[string]*targetResult
+	semaphore chan struct{}
+}
+
+// loadMetricsConfig reads and validates a metrics configuration file, filling
+// in the defaults.
+func loadMetricsConfig(configFile string) (*metricsConfig, error) {
+	configBytes, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var config metricsConfig
+	if err = yaml.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("invalid configuration (%s)", err)
+	}
+	if len(config.Targets) == 0 {
+		return nil, errors.New("no targets found in the configuration")
+	}
+	if config.Listen == "" {
+		config.Listen = defaultMetricsListen
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultMetricsInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = timeOut
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaultMetricsConcurrency
+	}
+
+	seen := make(map[string]bool)
+	for idx := range config.Targets {
+		target := &config.Targets[idx]
+		if target.Location == "" {
+			return nil, fmt.Errorf("target %d has no location", idx+1)
+		}
+		if seen[target.Location] {
+			return nil, fmt.Errorf("duplicate target (%s)", target.Location)
+		}
+		seen[target.Location] = true
+		if target.Interval <= 0 {
+			target.Interval = config.Interval
+		}
+		if target.Timeout <= 0 {
+			target.Timeout = config.Timeout
+		}
+	}
+
+	return &config, nil
+}
+
+// newMetricsCollector returns a collector for the given configuration.
+func newMetricsCollector(config *metricsConfig) *metricsCollector {
+	return &metricsCollector{
+		config:    config,
+		results:   make(map[string]*targetResult),
+		semaphore: make(chan struct{}, config.Concurrency),
+	}
+}
+
+// run retrieves every target at its interval until stop is closed. The targets
+// are retrieved concurrently, limited by the configured concurrency.
+func (collector *metricsCollector) run(stop <-chan struct{}) {
+	for _, target := range collector.config.Targets {
+		go func(target metricsTarget) {
+			ticker := time.NewTicker(target.Interval)
+			defer ticker.Stop()
+			for {
+				collector.scrape(target)
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(target)
+	}
+}
+
+// scrape retrieves a target and stores the result.
+func (collector *metricsCollector) scrape(target metricsTarget) {
+	collector.semaphore <- struct{}{}
+	defer func() { <-collector.semaphore }()
+
+	start := time.Now()
+	result := targetResult{timestamp: start}
+	result.certs, result.err = retrieveTarget(target)
+	if result.err == nil {
+		tree, err := getCertTree(result.certs, Params{roots: target.Roots, inters: target.Inters})
+		if err == nil {
+			result.verified = certmin.VerifyChain(tree).Verified
+		}
+	}
+	result.duration = time.Since(start)
+
+	collector.mutex.Lock()
+	collector.results[target.Location] = &result
+	collector.mutex.Unlock()
+}
+
+// ServeHTTP writes the metrics of the last results.
+func (collector *metricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	collector.writeMetrics(w)
+}
+
+// writeMetrics writes the metrics of the last results in the Prometheus text
+// exposition format.
+func (collector *metricsCollector) writeMetrics(w io.Writer) {
+	collector.mutex.RLock()
+	defer collector.mutex.RUnlock()
+
+	var locations []string
+	for location := range collector.results {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+
+	families := []struct {
+		name, help, metricType string
+		values                 func(location string, result *targetResult) []string
+	}{
+		{"certmin_target_up", "Whether the certificates of the target could be retrieved.", "gauge",
+			func(location string, result *targetResult) []string {
+				return []string{metricLine("certmin_target_up", boolValue(result.err == nil), "location", location)}
+			}},
+		{"certmin_target_last_scrape_timestamp_seconds", "Time of the last retrieval of the target.", "gauge",
+			func(location string, result *targetResult) []string {
+				return []string{metricLine("certmin_target_last_scrape_timestamp_seconds",
+					unixValue(result.timestamp), "location", location)}
+			}},
+		{"certmin_target_scrape_duration_seconds", "Duration of the last retrieval of the target.", "gauge",
+			func(location string, result *targetResult) []string {
+				return []string{metricLine("certmin_target_scrape_duration_seconds",
+					strconv.FormatFloat(result.duration.Seconds(), 'f', -1, 64), "location", location)}
+			}},
+		{"certmin_chain_verified", "Whether the chain of the target could be verified.", "gauge",
+			func(location string, result *targetResult) []string {
+				if result.err != nil {
+					return nil
+				}
+				return []string{metricLine("certmin_chain_verified", boolValue(result.verified), "location", location)}
+			}},
+		{"certmin_cert_not_before_timestamp_seconds", "Start of the validity period of the certificate.", "gauge",
+			func(location string, result *targetResult) []string {
+				return certMetricLines("certmin_cert_not_before_timestamp_seconds", location, result,
+					func(cert *x509.Certificate) string { return unixValue(cert.NotBefore) })
+			}},
+		{"certmin_cert_not_after_timestamp_seconds", "Expiry of the certificate.", "gauge",
+			func(location string, result *targetResult) []string {
+				return certMetricLines("certmin_cert_not_after_timestamp_seconds", location, result,
+					func(cert *x509.Certificate) string { return unixValue(cert.NotAfter) })
+			}},
+		{"certmin_cert_key_size_bits", "Size of the public key of the certificate.", "gauge",
+			func(location string, result *targetResult) []string {
+				return certMetricLines("certmin_cert_key_size_bits", location, result,
+					func(cert *x509.Certificate) string { return strconv.Itoa(keySize(cert)) })
+			}},
+		{"certmin_cert_info", "Information about the certificate.", "gauge",
+			func(location string, result *targetResult) []string {
+				var lines []string
+				for idx, cert := range result.certs {
+					lines = append(lines, metricLine("certmin_cert_info", "1",
+						append(certLabels(location, idx, cert),
+							"issuer", cert.Issuer.String(),
+							"public_key_algorithm", cert.PublicKeyAlgorithm.String(),
+							"signature_algorithm", cert.SignatureAlgorithm.String())...))
+				}
+				return lines
+			}},
+	}
+
+	for _, family := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", family.name, family.metricType)
+		for _, location := range locations {
+			for _, line := range family.values(location, collector.results[location]) {
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+}
+
+// retrieveTarget retrieves the certificates of a target without prompting,
+// using the timeout of the target for remote locations.
+func retrieveTarget(target metricsTarget) ([]*x509.Certificate, error) {
+	loc, protocol, remote, err := getLocation(target.Location)
+	if err != nil {
+		return nil, err
+	}
+	if remote {
+		certs, _, err := certmin.RetrieveCertsFromAddrStartTLS(loc, protocol, target.Timeout)
+		return certs, err
+	}
+	return certmin.DecodeCertFile(loc, target.Password)
+}
+
+// boolValue returns a metric value for a bool.
+func boolValue(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// certLabels returns the labels identifying a certificate of a target.
+func certLabels(location string, idx int, cert *x509.Certificate) []string {
+	return []string{
+		"location", location,
+		"index", strconv.Itoa(idx),
+		"subject", cert.Subject.String(),
+		"serial", cert.SerialNumber.String(),
+	}
+}
+
+// certMetricLines returns a metric line for every certificate of a target.
+func certMetricLines(name, location string, result *targetResult,
+	value func(cert *x509.Certificate) string) []string {
+	var lines []string
+	for idx, cert := range result.certs {
+		lines = append(lines, metricLine(name, value(cert), certLabels(location, idx, cert)...))
+	}
+	return lines
+}
+
+// keySize returns the size in bits of the public key of a certificate or 0 if
+// unknown.
+func keySize(cert *x509.Certificate) int {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
+
+// metricLine returns a metric line with labels given as name and value pairs.
+func metricLine(name, value string, labels ...string) string {
+	var pairs []string
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		pairs = append(pairs, labels[idx]+`="`+escaper.Replace(labels[idx+1])+`"`)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "} " + value
+}
+
+// unixValue returns a metric value for a time as seconds since the epoch.
+func unixValue(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
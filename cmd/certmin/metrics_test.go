@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nxadm/certmin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMetricsConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	err := ioutil.WriteFile(configFile, []byte(`
+interval: 10m
+targets:
+  - location: example.com
+  - location: t/myserver.crt
+    interval: 1h
+    timeout: 2s
+`), 0644)
+	assert.NoError(t, err)
+
+	config, err := loadMetricsConfig(configFile)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config) && assert.Equal(t, 2, len(config.Targets)) {
+		assert.Equal(t, defaultMetricsListen, config.Listen)
+		assert.Equal(t, defaultMetricsConcurrency, config.Concurrency)
+		assert.Equal(t, 10*time.Minute, config.Targets[0].Interval)
+		assert.Equal(t, timeOut, config.Targets[0].Timeout)
+		assert.Equal(t, time.Hour, config.Targets[1].Interval)
+		assert.Equal(t, 2*time.Second, config.Targets[1].Timeout)
+	}
+
+	for _, invalid := range []string{
+		"targets: []",
+		"targets:\n  - interval: 1h",
+		"targets:\n  - location: foo\n  - location: foo",
+		"targets: foo",
+	} {
+		assert.NoError(t, ioutil.WriteFile(configFile, []byte(invalid), 0644))
+		_, err = loadMetricsConfig(configFile)
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = loadMetricsConfig(filepath.Join(dir, "doesnotexist.yaml"))
+	assert.Error(t, err)
+}
+
+func TestMetricsCollector(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	rootFile := filepath.Join(t.TempDir(), "root.crt")
+	pemBytes, err := certmin.EncodeCertAsPKCS1PEM(server.Certificate())
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(rootFile, pemBytes, 0644))
+
+	// A listener that never answers the TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	remote := strings.TrimPrefix(server.URL, "https://")
+	config := &metricsConfig{
+		Concurrency: 2,
+		Targets: []metricsTarget{
+			{Location: remote, Timeout: 2 * time.Second, Roots: []string{rootFile}},
+			{Location: "t/myserver.crt"},
+			{Location: listener.Addr().String(), Timeout: 200 * time.Millisecond},
+		},
+	}
+	collector := newMetricsCollector(config)
+
+	start := time.Now()
+	for _, target := range config.Targets {
+		collector.scrape(target)
+	}
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := recorder.Body.String()
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, metrics, "# TYPE certmin_cert_not_after_timestamp_seconds gauge\n")
+	assert.Contains(t, metrics, `certmin_target_up{location="`+remote+`"} 1`)
+	assert.Contains(t, metrics, `certmin_target_up{location="t/myserver.crt"} 1`)
+	assert.Contains(t, metrics, `certmin_target_up{location="`+listener.Addr().String()+`"} 0`)
+	assert.Contains(t, metrics, `certmin_chain_verified{location="`+remote+`"} 1`)
+	assert.Contains(t, metrics, `certmin_chain_verified{location="t/myserver.crt"} 0`)
+	assert.Contains(t, metrics, `certmin_cert_not_after_timestamp_seconds{location="t/myserver.crt",`+
+		`index="0",subject="CN=myserver",serial="227697143328132615982354696728616722110"} 1681519020`)
+	assert.Contains(t, metrics, `certmin_cert_key_size_bits{location="t/myserver.crt",`+
+		`index="0",subject="CN=myserver",serial="227697143328132615982354696728616722110"} 2048`)
+	assert.Contains(t, metrics, `signature_algorithm="SHA256-RSA"} 1`)
+}
+
+func TestMetricsCollectorRun(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	location := strings.TrimPrefix(server.URL, "https://")
+	config := &metricsConfig{
+		Concurrency: 1,
+		Targets:     []metricsTarget{{Location: location, Interval: 50 * time.Millisecond, Timeout: time.Second}},
+	}
+	collector := newMetricsCollector(config)
+	stop := make(chan struct{})
+	collector.run(stop)
+	defer close(stop)
+
+	var first time.Time
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+		collector.mutex.RLock()
+		result := collector.results[location]
+		collector.mutex.RUnlock()
+		if result == nil {
+			continue
+		}
+		if first.IsZero() {
+			first = result.timestamp
+		} else if result.timestamp.After(first) {
+			return
+		}
+	}
+	t.Error("the target was not retrieved periodically")
+}
+
+func TestMetricLine(t *testing.T) {
+	assert.Equal(t, `foo{a="b\"c\\d\ne"} 1`, metricLine("foo", "1", "a", "b\"c\\d\ne"))
+	assert.Equal(t, "foo{} 1", metricLine("foo", "1"))
+}
+
+func TestKeySize(t *testing.T) {
+	for file, size := range map[string]int{
+		"../../t/myserver.crt":         2048,
+		"../../t/ecdsa_secp384r1.crt":  384,
+		"../../t/ecdsa_prime256v1.crt": 256,
+		"../../t/ed25519.crt":          256,
+	} {
+		certs, err := certmin.DecodeCertFile(file, "")
+		assert.NoError(t, err)
+		assert.Equal(t, size, keySize(certs[0]), file)
+	}
+}
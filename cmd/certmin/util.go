@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,7 +25,9 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/nxadm/certmin"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/yaml.v3"
 )
 
 // colourKeeper keeps track of certain output that must have the same color.
@@ -46,6 +56,28 @@ func (colourKeeper *colourKeeper) colourise(msg string) string {
 	return msg
 }
 
+// startTLSScheme is the StartTLS protocol and default port of an URL scheme.
+type startTLSScheme struct {
+	protocol string
+	port     int
+}
+
+// startTLSSchemes lists the URL schemes for which the connection
+// must be upgraded to TLS.
+var startTLSSchemes = map[string]startTLSScheme{
+	"smtp":        {certmin.StartTLSSMTP, 25},
+	"submission":  {certmin.StartTLSSMTP, 587},
+	"imap":        {certmin.StartTLSIMAP, 143},
+	"pop3":        {certmin.StartTLSPOP3, 110},
+	"ftp":         {certmin.StartTLSFTP, 21},
+	"ldap":        {certmin.StartTLSLDAP, 389},
+	"xmpp":        {certmin.StartTLSXMPP, 5222},
+	"xmpp-client": {certmin.StartTLSXMPP, 5222},
+	"postgres":    {certmin.StartTLSPostgres, 5432},
+	"postgresql":  {certmin.StartTLSPostgres, 5432},
+	"mysql":       {certmin.StartTLSMySQL, 3306},
+}
+
 // appendToCertTree adds roots and intermediates from file to a CertTree
 func appendToCertTree(inTree []*x509.Certificate, toAdd []string) ([]*x509.Certificate, error) {
 	if toAdd != nil {
@@ -62,99 +94,615 @@ func appendToCertTree(inTree []*x509.Certificate, toAdd []string) ([]*x509.Certi
 	return certmin.SortCerts(inTree, false), nil
 }
 
+// certName returns the common name of a certificate or its full subject if
+// there is no common name.
+func certName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// completeCertTree adds the intermediates missing from a CertTree by following
+// the Issuer Certificate URLs from the last certificate of the chain of the
+// leaf. It returns the number of retrieved intermediates and an error.
+func completeCertTree(tree *certmin.CertTree) (int, error) {
+	all := append([]*x509.Certificate{tree.Certificate}, tree.Intermediates...)
+	chains, _ := certmin.SortCertsAsChains(all, false)
+	last := tree.Certificate
+	for _, chain := range chains {
+		if chain[0] == tree.Certificate {
+			last = chain[len(chain)-1]
+			break
+		}
+	}
+	if certmin.IsRootCA(last) {
+		return 0, nil
+	}
+
+	// Roots are only trusted from the OS trust store or --root
+	retrieved, err := certmin.RetrieveChainWithoutRootFromIssuerURLs(last, timeOut)
+	tree.Intermediates = append(tree.Intermediates, retrieved[1:]...)
+	return len(retrieved) - 1, err
+}
+
+// decodeCertFile decodes a local certificate file. If the password of a PKCS12
+// file is incorrect, the user is prompted for it. It returns the certificates,
+// the password used and an error.
+func decodeCertFile(certFile, password string) ([]*x509.Certificate, string, error) {
+	certs, err := certmin.DecodeCertFile(certFile, password)
+	if err != nil && strings.Contains(err.Error(), "pkcs12: decryption password incorrect") {
+		password, err = promptForKeyPassword()
+		if err != nil {
+			return nil, "", err
+		}
+		certs, err = certmin.DecodeCertFile(certFile, password)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return certs, password, nil
+}
+
+// decodeKeyFile decodes a key file with the given password. If that fails and
+// no password was given, the user is prompted for it. The user is prompted for
+// the entry password of a keystore when it differs from the store password.
+func decodeKeyFile(keyFile, password string) (*certmin.PrivateKey, error) {
+	key, err := certmin.DecodePrivateKeyFile(keyFile, password)
+	if err != nil && password == "" {
+		password, err = promptForKeyPassword()
+		if err != nil {
+			return nil, err
+		}
+		key, err = certmin.DecodePrivateKeyFile(keyFile, password)
+	}
+	if errors.Is(err, certmin.ErrKeyStoreEntryPassword) {
+		keyPassword, err := promptForPassword("Enter the password of the keystore entry: ")
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return certmin.DecodePrivateKeyKeyStore(data, password, keyPassword)
+	}
+	return key, err
+}
+
+// encodeNewKey encodes a new key as PKCS8 PEM, optionally encrypted with a
+// password, to be written next to a certificate or CSR file. It returns the name
+// of the key file, the encoded key and an error if the key file already exists.
+func encodeNewKey(key *certmin.PrivateKey, file, password string) (string, []byte, error) {
+	keyFile := strings.TrimSuffix(file, filepath.Ext(file)) + ".key"
+	if keyFile == file {
+		return "", nil, fmt.Errorf("the key can not be written to the same file (%s)", file)
+	}
+	if _, err := os.Stat(keyFile); err == nil {
+		return "", nil, fmt.Errorf("the key file already exists (%s)", keyFile)
+	}
+	block, err := key.PEMBlock()
+	if err != nil {
+		return "", nil, err
+	}
+	keyBytes, err := certmin.EncodeKeyAsPKCS8PEM(block, password)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyFile, keyBytes, nil
+}
+
+// expiryDescription returns a short description of the time left before the
+// expiry of a certificate.
+func expiryDescription(left time.Duration) string {
+	days := int(left.Hours() / 24)
+	switch {
+	case left < 0:
+		return fmt.Sprintf("expired %d days ago", -days)
+	case days == 0:
+		return fmt.Sprintf("expires in %s", left.Round(time.Minute))
+	default:
+		return fmt.Sprintf("expires in %d days", days)
+	}
+}
+
+// fileBaseName returns the base name of the files written for a certificate or
+// a CSR, based on its Common Name and the current time.
+func fileBaseName(commonName string) string {
+	rx := regexp.MustCompile("[^a-zA-Z0-9_-]")
+	return "certmin_" + rx.ReplaceAllString(commonName, "_") + "_" +
+		time.Now().Format("20060102150405")
+}
+
+// getCertTree splits certificates as a CertTree and adds the roots and
+// intermediates given as files.
+func getCertTree(certs []*x509.Certificate, params Params) (*certmin.CertTree, error) {
+	tree := certmin.SplitCertsAsTree(certs)
+	if tree == nil {
+		return nil, errors.New("no certificate found")
+	}
+
+	result, err := appendToCertTree(tree.Roots, params.roots)
+	if err != nil {
+		return nil, err
+	}
+	tree.Roots = result
+	result, err = appendToCertTree(tree.Intermediates, params.inters)
+	if err != nil {
+		return nil, err
+	}
+	tree.Intermediates = result
+
+	return tree, nil
+}
+
+// findChainPaths returns the chain paths of the certificates of a location,
+// without the roots if requested. Only the roots of the OS trust store and of
+// the --root files are trusted: a root served by the location is part of the
+// paths, but they do not end in a trusted root.
+func findChainPaths(certs []*x509.Certificate, params Params) ([]*certmin.ChainPath, error) {
+	tree := certmin.SplitCertsAsTree(certs)
+	if tree == nil {
+		return nil, errors.New("no certificate found")
+	}
+
+	served := append(tree.Intermediates, tree.Roots...)
+	result, err := appendToCertTree(served, params.inters)
+	if err != nil {
+		return nil, err
+	}
+	tree.Intermediates = result
+	result, err = appendToCertTree(nil, params.roots)
+	if err != nil {
+		return nil, err
+	}
+	tree.Roots = result
+
+	paths := certmin.FindChainPaths(tree, params.at)
+	if params.noRoots {
+		stripPathRoots(paths)
+	}
+	return paths, nil
+}
+
 // getCerts does the optional downloading and parsing of certificates
 func getCerts(input string, sb *strings.Builder) ([]*x509.Certificate, error) {
+	certs, _, warn, err := getCertsAndConnection(input)
+	if warn != nil {
+		sb.WriteString(color.YellowString(warn.Error()) + "\n")
+	}
+	return certs, err
+}
+
+// getCertsAndConnection does the optional downloading and parsing of
+// certificates. For remote locations the information of the connection
+// is returned as well, together with an error with a warning.
+func getCertsAndConnection(
+	input string) ([]*x509.Certificate, *certmin.ConnectionInfo, error, error) {
 	var certs []*x509.Certificate
+	var info *certmin.ConnectionInfo
 	var err, warn error
 
-	loc, remote, err := getLocation(input)
+	loc, protocol, remote, err := getLocation(input)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	if remote {
-		certs, warn, err = certmin.RetrieveCertsFromAddr(loc, timeOut)
-		if warn != nil {
-			sb.WriteString(color.YellowString(warn.Error()))
-		}
+		info, warn, err = certmin.RetrieveConnectionInfoFromAddr(loc, protocol, timeOut)
 		if err != nil {
-			return nil, err
+			return nil, nil, warn, err
 		}
+		certs = info.Certificates
 	} else {
-		certs, err = certmin.DecodeCertFile(loc, "")
+		certs, _, err = decodeCertFile(loc, "")
 		if err != nil {
-			if strings.Contains(err.Error(), "pkcs12: decryption password incorrect") {
-				passwordBytes, err := promptForKeyPassword()
-				if err != nil {
-					return nil, err
-				}
+			return nil, nil, nil, err
+		}
+	}
+	return certs, info, warn, nil
+}
 
-				certs, err = certmin.DecodeCertFile(loc, string(passwordBytes))
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				return nil, err
-			}
+// getKeyStore returns the keystore of a local JKS or JCEKS file or nil
+// if the location is not a keystore. The keys are not decrypted.
+func getKeyStore(input string) *certmin.KeyStore {
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		return nil
+	}
+	keyStore, err := certmin.DecodeKeyStore(data, "", "")
+	if err != nil {
+		return nil
+	}
+	return keyStore
+}
+
+// getSCTs returns the SCTs of a certificate: the embedded ones and, if the
+// certificate is the one offered by the server of the connection, the ones
+// delivered in the TLS extension and the stapled OCSP response.
+func getSCTs(cert *x509.Certificate, info *certmin.ConnectionInfo) ([]*certmin.SCT, error) {
+	scts, err := certmin.EmbeddedSCTs(cert)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || len(info.Certificates) == 0 || !info.Certificates[0].Equal(cert) {
+		return scts, nil
+	}
+
+	for _, sctBytes := range info.SCTs {
+		sct, err := certmin.ParseSCT(sctBytes, certmin.SCTSourceTLS)
+		if err != nil {
+			return nil, err
 		}
+		scts = append(scts, sct)
+	}
+	if info.OCSPResult != nil {
+		scts = append(scts, info.OCSPResult.SCTs...)
 	}
-	return certs, nil
+	return scts, nil
 }
 
 // getLocation parses an input string and it return a string with a file
-// name or a rewritten hostname:port location, a boolean stating if the
-// location is remote and an error.
-func getLocation(input string) (string, bool, error) {
+// name or a rewritten hostname:port location, the StartTLS protocol
+// needed for the remote location, a boolean stating if the location
+// is remote and an error.
+func getLocation(input string) (string, string, bool, error) {
 	// Local file
 	_, err := os.Stat(input)
 	if err == nil {
-		return input, false, nil
+		return input, certmin.StartTLSNone, false, nil
 	}
 
 	// Remote
-	location, err := parseURL(input)
+	location, protocol, err := parseURL(input)
 	if err == nil {
-		return location, true, nil
+		return location, protocol, true, nil
 	}
-	location, err = parseURL("certmin://" + input) // Add a scheme
+	location, protocol, err = parseURL("certmin://" + input) // Add a scheme
 	if err == nil {
-		return location, true, nil
+		return location, protocol, true, nil
+	}
+
+	return "", certmin.StartTLSNone, false, fmt.Errorf("%s is not a file or a remote location", input)
+}
+
+// loadCA loads the CA certificate (with its chain) and key given with --ca and
+// --ca-key. When --ca-key is not given, the key file written next to the --ca
+// file by the ca action is used.
+func loadCA(params Params) (*certmin.CA, error) {
+	certs, _, err := decodeCertFile(params.caCert, params.inPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := params.caKey
+	if keyFile == "" {
+		keyFile = strings.TrimSuffix(params.caCert, filepath.Ext(params.caCert)) + ".key"
+	}
+	key, err := decodeKeyFile(keyFile, params.inPassword)
+	if err != nil {
+		return nil, err
+	}
+	return certmin.NewCA(certs, key)
+}
+
+// loadCSRTemplate reads a YAML or JSON file with the subject and the Subject
+// Alternative Names of a CSR.
+func loadCSRTemplate(templateFile string) (*certmin.CSRTemplate, error) {
+	templateBytes, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var template certmin.CSRTemplate
+	decoder := yaml.NewDecoder(bytes.NewReader(templateBytes))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&template); err != nil {
+		return nil, fmt.Errorf("invalid CSR template (%s)", err)
+	}
+	return &template, nil
+}
+
+// newCertProfile returns the profile of a certificate issued by the ca action
+// from the subject, Subject Alternative Names, usages, constraints and URLs given
+// on the command line.
+func newCertProfile(params Params) (*certmin.CertProfile, error) {
+	template := &certmin.CSRTemplate{}
+	var err error
+	if params.template != "" {
+		if template, err = loadCSRTemplate(params.template); err != nil {
+			return nil, err
+		}
+	}
+	if err = parseSubject(params.subject, template); err != nil {
+		return nil, err
+	}
+	for _, san := range params.sans {
+		if err = parseSAN(san, template); err != nil {
+			return nil, err
+		}
+	}
+
+	profile := certmin.CertProfile{
+		Subject:                *template,
+		Validity:               params.validity,
+		KeyUsage:               params.keyUsage,
+		ExtKeyUsages:           params.usages,
+		PermittedDNSDomains:    params.permittedDNS,
+		ExcludedDNSDomains:     params.excludedDNS,
+		IssuingCertificateURLs: params.aiaURLs,
+		OCSPServers:            params.ocspURLs,
+		CRLDistributionPoints:  params.crlURLs,
+	}
+	switch {
+	case params.maxPathLen == 0:
+		profile.MaxPathLenZero = true
+	case params.maxPathLen > 0:
+		profile.MaxPathLen = params.maxPathLen
+	}
+	return &profile, nil
+}
+
+// newKey returns the key given with --key or a new key of the type given with
+// --key-type. The returned bool is true for a new key.
+func newKey(params Params) (*certmin.PrivateKey, bool, error) {
+	if params.key != "" {
+		key, err := decodeKeyFile(params.key, params.inPassword)
+		return key, false, err
+	}
+	algorithm, size, curve, err := parseKeyType(params.keyType)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := certmin.GeneratePrivateKey(algorithm, size, curve)
+	return key, true, err
+}
+
+// parseExtKeyUsages converts the names of extended key usages given on
+// the command line to a []x509.ExtKeyUsage.
+func parseExtKeyUsages(names []string) ([]x509.ExtKeyUsage, error) {
+	usageByName := map[string]x509.ExtKeyUsage{
+		"server":        x509.ExtKeyUsageServerAuth,
+		"client":        x509.ExtKeyUsageClientAuth,
+		"code-signing":  x509.ExtKeyUsageCodeSigning,
+		"email":         x509.ExtKeyUsageEmailProtection,
+		"time-stamping": x509.ExtKeyUsageTimeStamping,
+		"ocsp-signing":  x509.ExtKeyUsageOCSPSigning,
+		"any":           x509.ExtKeyUsageAny,
 	}
 
-	return "", false, fmt.Errorf("%s is not a file or a remote location", input)
+	var usages []x509.ExtKeyUsage
+	for _, name := range names {
+		usage, ok := usageByName[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key usage (%s)", name)
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// parseKeyType parses the type of a key to generate: rsa, ecdsa or ed25519,
+// optionally followed by the size of a RSA key or the curve of an ECDSA key
+// (e.g. rsa:4096 or ecdsa:P-384). It returns the algorithm, size and curve.
+func parseKeyType(input string) (string, int, string, error) {
+	parts := strings.SplitN(input, ":", 2)
+	switch strings.ToLower(parts[0]) {
+	case "", "rsa":
+		if len(parts) == 1 {
+			return certmin.KeyAlgorithmRSA, 0, "", nil
+		}
+		size, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return "", 0, "", fmt.Errorf("invalid RSA key size (%s)", parts[1])
+		}
+		return certmin.KeyAlgorithmRSA, size, "", nil
+	case "ecdsa":
+		if len(parts) == 1 {
+			return certmin.KeyAlgorithmECDSA, 0, "", nil
+		}
+		return certmin.KeyAlgorithmECDSA, 0, strings.ToUpper(parts[1]), nil
+	case "ed25519":
+		if len(parts) == 2 {
+			return "", 0, "", errors.New("ed25519 keys have no size or curve")
+		}
+		return certmin.KeyAlgorithmEd25519, 0, "", nil
+	default:
+		return "", 0, "", fmt.Errorf("unsupported key type (%s)", input)
+	}
+}
+
+// parseKeyUsages converts the names of key usages given on the command line to
+// a x509.KeyUsage.
+func parseKeyUsages(names []string) (x509.KeyUsage, error) {
+	usageByName := map[string]x509.KeyUsage{
+		"digital-signature":  x509.KeyUsageDigitalSignature,
+		"content-commitment": x509.KeyUsageContentCommitment,
+		"key-encipherment":   x509.KeyUsageKeyEncipherment,
+		"data-encipherment":  x509.KeyUsageDataEncipherment,
+		"key-agreement":      x509.KeyUsageKeyAgreement,
+		"cert-sign":          x509.KeyUsageCertSign,
+		"crl-sign":           x509.KeyUsageCRLSign,
+	}
+
+	var usages x509.KeyUsage
+	for _, name := range names {
+		usage, ok := usageByName[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown key usage (%s)", name)
+		}
+		usages |= usage
+	}
+	return usages, nil
+}
+
+// parseSAN adds a Subject Alternative Name to a CSR template. The type can be
+// given as a prefix (dns:, ip:, email: or uri:) or is detected otherwise.
+func parseSAN(input string, template *certmin.CSRTemplate) error {
+	kind, value := "", input
+	if idx := strings.Index(input, ":"); idx > 0 {
+		switch prefix := strings.ToLower(input[:idx]); prefix {
+		case "dns", "ip", "email", "uri":
+			kind, value = prefix, input[idx+1:]
+		}
+	}
+	if kind == "" {
+		switch {
+		case net.ParseIP(value) != nil:
+			kind = "ip"
+		case strings.Contains(value, "://"):
+			kind = "uri"
+		case strings.Contains(value, "@"):
+			kind = "email"
+		default:
+			kind = "dns"
+		}
+	}
+	if value == "" {
+		return fmt.Errorf("invalid Subject Alternative Name (%s)", input)
+	}
+
+	switch kind {
+	case "dns":
+		template.DNSNames = append(template.DNSNames, value)
+	case "ip":
+		template.IPAddresses = append(template.IPAddresses, value)
+	case "email":
+		template.EmailAddresses = append(template.EmailAddresses, value)
+	case "uri":
+		template.URIs = append(template.URIs, value)
+	}
+	return nil
+}
+
+// parseSubject sets the fields of a subject given as "CN=name,O=org,C=BE" or,
+// as with OpenSSL, "/C=BE/O=org/CN=name" in a CSR template. The supported fields
+// are CN, C, ST, L, O and OU.
+func parseSubject(input string, template *certmin.CSRTemplate) error {
+	if input == "" {
+		return nil
+	}
+	separator := ","
+	if strings.HasPrefix(input, "/") {
+		separator = "/"
+		input = input[1:]
+	}
+
+	for _, field := range strings.Split(input, separator) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid subject field (%s)", field)
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+		case "CN":
+			template.CommonName = value
+		case "C":
+			template.Country = value
+		case "ST":
+			template.Province = value
+		case "L":
+			template.Locality = value
+		case "O":
+			template.Organization = value
+		case "OU":
+			template.OrganizationalUnit = value
+		default:
+			return fmt.Errorf("unsupported subject field (%s)", parts[0])
+		}
+	}
+	return nil
+}
+
+// parseThreshold parses an expiry threshold given as a number of days (with
+// an optional "d" suffix) or as a duration.
+func parseThreshold(input string) (time.Duration, error) {
+	days, err := strconv.Atoi(strings.TrimSuffix(input, "d"))
+	if err == nil && days >= 0 {
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(input)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid threshold (%s)", input)
+	}
+	return duration, nil
+}
+
+// parseThresholds parses the warning and critical expiry thresholds. When the
+// warning threshold is the default one, it is raised to a higher critical
+// threshold.
+func parseThresholds(warning, critical string, warningGiven bool) (time.Duration, time.Duration, error) {
+	warningDuration, err := parseThreshold(warning)
+	if err != nil {
+		return 0, 0, err
+	}
+	criticalDuration, err := parseThreshold(critical)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !warningGiven && criticalDuration > warningDuration {
+		warningDuration = criticalDuration
+	}
+	return warningDuration, criticalDuration, nil
+}
+
+// parseTime parses a date (YYYY-MM-DD) or a RFC3339 timestamp.
+func parseTime(input string) (time.Time, error) {
+	if parsed, err := time.Parse("2006-01-02", input); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, input)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date (%s)", input)
+	}
+	return parsed, nil
 }
 
 // parseURL parses a given URL and return a string in the form of
-// hostname:port or an error if the parsing fails.
-func parseURL(remote string) (string, error) {
+// hostname:port, the StartTLS protocol associated with the scheme
+// or an error if the parsing fails.
+func parseURL(remote string) (string, string, error) {
 	parsedURL, err := url.Parse(remote)
 	if err != nil {
-		return "", err
+		return "", certmin.StartTLSNone, err
 	}
 
 	host := parsedURL.Host
 	if host == "" {
-		return "", errors.New("no hostname found")
+		return "", certmin.StartTLSNone, errors.New("no hostname found")
 	}
 
 	scheme := parsedURL.Scheme
 	portStr := parsedURL.Port()
+	startTLS, isStartTLS := startTLSSchemes[scheme]
 	var port int
-	if portStr == "" {
+	switch {
+	case portStr != "":
+		port, err = strconv.Atoi(portStr) // prefer explicit port
+		if err != nil {
+			return "", certmin.StartTLSNone, fmt.Errorf("invalid port (%s)", portStr)
+		}
+	case isStartTLS:
+		port = startTLS.port
+	default:
 		foundPort, err := net.LookupPort("tcp", scheme)
 		if err == nil {
 			port = foundPort
 		} else {
 			port = 443
 		}
-	} else {
-		port, err = strconv.Atoi(portStr) // prefer explicit port
-		if err != nil {
-			return "", fmt.Errorf("invalid port (%s)", portStr)
-		}
 	}
 
-	return parsedURL.Hostname() + ":" + strconv.Itoa(port), nil
+	return parsedURL.Hostname() + ":" + strconv.Itoa(port), startTLS.protocol, nil
+}
+
+// perfDataLabel returns a label for the performance data of a certificate,
+// without the characters not allowed by monitoring plugins.
+func perfDataLabel(location string, cert *x509.Certificate) string {
+	label := location + " " + cert.Subject.CommonName
+	if cert.Subject.CommonName == "" {
+		label = location + " " + cert.SerialNumber.String()
+	}
+	return strings.NewReplacer("'", "_", "=", "_").Replace(label)
 }
 
 // printCert prints the relevant information of certificate
@@ -243,11 +791,331 @@ func printCert(cert *x509.Certificate, w *tabwriter.Writer, colourKeeper colourK
 	fmt.Fprintf(w, "Not after:\t%s\n", cert.NotAfter)
 }
 
+// printChainPaths prints the possible paths of a certificate to a root, green
+// when ending in a root, yellow when also containing expired certificates and
+// red without a root.
+func printChainPaths(paths []*certmin.ChainPath, sb *strings.Builder) {
+	sb.WriteString("Chain paths:\n")
+	for idx, path := range paths {
+		var msg string
+		switch path.Root {
+		case certmin.SourceSystem:
+			msg = fmt.Sprintf("Path %d: ends in a root of the OS trust store", idx+1)
+		case certmin.SourceCertTree:
+			msg = fmt.Sprintf("Path %d: ends in a root of the --root files", idx+1)
+		default:
+			msg = fmt.Sprintf("Path %d: does not end in a trusted root", idx+1)
+		}
+		switch {
+		case path.Root == "":
+			sb.WriteString(color.RedString(msg) + "\n")
+		case len(path.Expired) > 0:
+			sb.WriteString(color.YellowString(msg+", with expired certificates") + "\n")
+		default:
+			sb.WriteString(color.GreenString(msg) + "\n")
+		}
+
+		expired := make(map[*x509.Certificate]bool)
+		for _, cert := range path.Expired {
+			expired[cert] = true
+		}
+		for certIdx, cert := range path.Certificates {
+			source := "from location or files"
+			if path.Sources[certIdx] == certmin.SourceSystem {
+				source = "from OS trust store"
+			}
+			if expired[cert] {
+				source += ", " + color.RedString("expired")
+			}
+			sb.WriteString(fmt.Sprintf("  %s (%s)\n", cert.Subject.String(), source))
+		}
+	}
+}
+
+// printConnection prints the relevant information of a connection
+func printConnection(info *certmin.ConnectionInfo, w *tabwriter.Writer) {
+	fmt.Fprintln(w, "Connection:")
+	fmt.Fprintf(w, "TLS version:\t%s\n", certmin.TLSVersionName(info.Version))
+	fmt.Fprintf(w, "Cipher suite:\t%s\n", tls.CipherSuiteName(info.CipherSuite))
+	if info.NegotiatedProtocol != "" {
+		fmt.Fprintf(w, "ALPN protocol:\t%s\n", info.NegotiatedProtocol)
+	}
+	fmt.Fprintf(w, "Verified for server name:\t%t\n", info.Verified)
+	switch {
+	case len(info.OCSPResponse) == 0:
+		fmt.Fprintf(w, "Stapled OCSP response:\tnone\n")
+	case info.OCSPError != nil:
+		fmt.Fprintf(w, "Stapled OCSP response:\tinvalid (%s)\n", info.OCSPError)
+	case info.OCSPResult.Status == certmin.OCSPRevoked:
+		fmt.Fprintf(w, "Stapled OCSP response:\t%s at %s (%s)\n", info.OCSPResult.Status,
+			info.OCSPResult.RevokedAt, certmin.RevocationReason(info.OCSPResult.RevocationReason))
+	default:
+		fmt.Fprintf(w, "Stapled OCSP response:\t%s (next update %s)\n",
+			info.OCSPResult.Status, info.OCSPResult.NextUpdate)
+	}
+	fmt.Fprintf(w, "SCTs in TLS extension:\t%d\n", len(info.SCTs))
+}
+
+// printCRL prints the relevant information of a CRL
+func printCRL(crl *pkix.CertificateList, w *tabwriter.Writer) {
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	fmt.Fprintf(w, "Issuer:\t%s\n", issuer.String())
+	fmt.Fprintf(w, "This update:\t%s\n", crl.TBSCertList.ThisUpdate)
+	if !crl.TBSCertList.NextUpdate.IsZero() {
+		fmt.Fprintf(w, "Next update:\t%s\n", crl.TBSCertList.NextUpdate)
+	}
+	if number := certmin.CRLNumber(crl); number != nil {
+		fmt.Fprintf(w, "CRL number:\t%s\n", number)
+	}
+	if base := certmin.DeltaCRLIndicator(crl); base != nil {
+		fmt.Fprintf(w, "Delta CRL:\tyes (base CRL number %s)\n", base)
+	} else {
+		fmt.Fprintf(w, "Delta CRL:\tno\n")
+	}
+	fmt.Fprintf(w, "Number of entries:\t%d\n", len(crl.TBSCertList.RevokedCertificates))
+}
+
+// printCRLResult prints the revocation status of a certificate according
+// to its CRL or the error encountered while retrieving it.
+func printCRLResult(result *certmin.CRLResult, err error, sb *strings.Builder) {
+	if err != nil {
+		sb.WriteString(color.YellowString("CRL status: could not be retrieved (" + err.Error() + ")\n"))
+		return
+	}
+
+	if result.Revoked {
+		sb.WriteString(color.RedString(fmt.Sprintf("CRL status: revoked at %s (%s)\n",
+			result.RevokedAt, certmin.RevocationReason(result.RevocationReason))))
+	} else {
+		sb.WriteString(color.GreenString("CRL status: not revoked\n"))
+	}
+	sb.WriteString("CRL location: " + result.Location + "\n")
+	sb.WriteString(fmt.Sprintf("CRL this update: %s\n", result.ThisUpdate))
+	if !result.NextUpdate.IsZero() {
+		sb.WriteString(fmt.Sprintf("CRL next update: %s\n", result.NextUpdate))
+	}
+}
+
+// printDiagnosis prints the served certificates, the chain that should be
+// served and the findings of a Diagnosis, coloured by severity.
+func printDiagnosis(diagnosis *certmin.Diagnosis, sb *strings.Builder) {
+	sb.WriteString("Served:\n")
+	for idx, cert := range diagnosis.Served {
+		sb.WriteString(fmt.Sprintf("  %d: %s\n", idx+1, cert.Subject.String()))
+	}
+	sb.WriteString("Chain to serve:\n")
+	for idx, cert := range diagnosis.Chain {
+		sb.WriteString(fmt.Sprintf("  %d: %s\n", idx+1, cert.Subject.String()))
+	}
+
+	if len(diagnosis.Findings) == 0 {
+		sb.WriteString(color.GreenString("the served chain is correct") + "\n")
+		return
+	}
+	sb.WriteString("Findings:\n")
+	for _, finding := range diagnosis.Findings {
+		msg := "[" + finding.Severity + "] " + finding.Kind + ": " + finding.Message
+		if finding.Certificate != nil {
+			msg += " (" + finding.Certificate.Subject.String() + ")"
+		}
+		switch finding.Severity {
+		case certmin.SeverityError:
+			msg = color.RedString(msg)
+		case certmin.SeverityWarning:
+			msg = color.YellowString(msg)
+		}
+		sb.WriteString("  " + msg + "\n")
+	}
+}
+
+// printKeyMatch prints and reports the result of matching a key against a
+// CSR or an OpenSSH public key or certificate.
+func printKeyMatch(keyFile, name string, err error, report *certmin.LocationReport, sb *strings.Builder) {
+	report.KeyMatch = &certmin.KeyMatchReport{
+		Key:         keyFile,
+		Certificate: name,
+		Match:       err == nil,
+	}
+	if err == nil {
+		sb.WriteString(color.GreenString(name + " and its key match\n"))
+		return
+	}
+	report.KeyMatch.Reason = err.Error()
+	sb.WriteString(color.RedString(name + " and its key do not match: " + err.Error() + "\n"))
+}
+
+// printKeyStore prints the entries of a keystore with the subjects of their
+// certificates.
+func printKeyStore(keyStore *certmin.KeyStore, w *tabwriter.Writer) {
+	fmt.Fprintf(w, "Keystore type:\t%s\n", keyStore.Type)
+	for _, entry := range keyStore.Entries {
+		fmt.Fprintf(w, "Alias %s:\t%s (created %s)\n", entry.Alias, entry.Type, entry.Created)
+		for _, cert := range entry.Certificates {
+			fmt.Fprintf(w, "\t%s\n", cert.Subject)
+		}
+	}
+}
+
+// printOCSPResult prints the revocation status of a certificate or
+// the error encountered while retrieving it.
+func printOCSPResult(result *certmin.OCSPResult, err error, sb *strings.Builder) {
+	if err != nil {
+		sb.WriteString(color.YellowString("OCSP status: could not be retrieved (" + err.Error() + ")\n"))
+		return
+	}
+
+	switch result.Status {
+	case certmin.OCSPGood:
+		sb.WriteString(color.GreenString("OCSP status: " + result.Status + "\n"))
+	case certmin.OCSPRevoked:
+		sb.WriteString(color.RedString(fmt.Sprintf("OCSP status: %s at %s (%s)\n",
+			result.Status, result.RevokedAt, certmin.RevocationReason(result.RevocationReason))))
+	default:
+		sb.WriteString(color.YellowString("OCSP status: " + result.Status + "\n"))
+	}
+	sb.WriteString("OCSP responder: " + result.Responder + "\n")
+	if result.ResponderCert != nil {
+		sb.WriteString("OCSP responder certificate: " + result.ResponderCert.Subject.String() + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("OCSP this update: %s\n", result.ThisUpdate))
+	if !result.NextUpdate.IsZero() {
+		sb.WriteString(fmt.Sprintf("OCSP next update: %s\n", result.NextUpdate))
+	}
+}
+
+// printCTPolicyResult prints the verified SCTs of a certificate and the result
+// of the CT policy check.
+func printCTPolicyResult(results []*certmin.SCTResult, operators int, sb *strings.Builder) {
+	for _, result := range results {
+		sb.WriteString("SCT: " + sctDescription(result) + "\n")
+	}
+	if operators == 0 {
+		return
+	}
+	if err := certmin.CheckCTPolicy(results, operators); err != nil {
+		sb.WriteString(color.RedString("CT policy: not met (" + err.Error() + ")\n"))
+		return
+	}
+	sb.WriteString(color.GreenString(fmt.Sprintf(
+		"CT policy: met (valid SCTs from at least %d distinct operators)\n", operators)))
+}
+
+// printSCTs prints the verified SCTs of a certificate.
+func printSCTs(results []*certmin.SCTResult, w *tabwriter.Writer) {
+	for _, result := range results {
+		fmt.Fprintf(w, "SCT:\t%s\n", sctDescription(result))
+	}
+}
+
+// printSSHKey prints the relevant information of an OpenSSH public key or
+// certificate.
+func printSSHKey(key *certmin.SSHKey, w *tabwriter.Writer) {
+	report := certmin.NewSSHKeyReport(key)
+	fmt.Fprintf(w, "Type:\t%s\n", report.Type)
+	if report.Comment != "" {
+		fmt.Fprintf(w, "Comment:\t%s\n", report.Comment)
+	}
+	if len(report.Options) > 0 {
+		fmt.Fprintf(w, "Options:\t%s\n", strings.Join(report.Options, ", "))
+	}
+	fmt.Fprintf(w, "Fingerprint:\t%s\n", report.Fingerprint)
+	if report.Certificate == nil {
+		return
+	}
+
+	cert := report.Certificate
+	fmt.Fprintf(w, "Certificate type:\t%s\n", cert.CertType)
+	fmt.Fprintf(w, "Key ID:\t%s\n", cert.KeyID)
+	fmt.Fprintf(w, "Serial number:\t%d\n", cert.Serial)
+	principals := "none (valid for all)"
+	if len(cert.Principals) > 0 {
+		principals = strings.Join(cert.Principals, ", ")
+	}
+	fmt.Fprintf(w, "Principals:\t%s\n", principals)
+	validAfter, validBefore := "always", "forever"
+	if cert.ValidAfter != nil {
+		validAfter = cert.ValidAfter.String()
+	}
+	if cert.ValidBefore != nil {
+		validBefore = cert.ValidBefore.String()
+	}
+	fmt.Fprintf(w, "Valid after:\t%s\n", validAfter)
+	fmt.Fprintf(w, "Valid before:\t%s\n", validBefore)
+	fmt.Fprintf(w, "Critical options:\t%s\n", sshOptions(cert.CriticalOptions))
+	fmt.Fprintf(w, "Extensions:\t%s\n", sshOptions(cert.Extensions))
+	fmt.Fprintf(w, "Signing CA:\t%s %s\n", cert.SigningCAType, cert.SigningCA)
+}
+
+// printCSR prints the relevant information of a certificate signing request.
+func printCSR(csr *x509.CertificateRequest, w *tabwriter.Writer) {
+	report := certmin.NewCSRReport(csr)
+	fmt.Fprintf(w, "Subject:\t%s\n", report.Subject)
+	if len(report.DNSNames) > 0 {
+		fmt.Fprintf(w, "DNS names:\t%s\n", strings.Join(report.DNSNames, ", "))
+	}
+	if len(report.EmailAddresses) > 0 {
+		fmt.Fprintf(w, "Email addresses:\t%s\n", strings.Join(report.EmailAddresses, ", "))
+	}
+	if len(report.IPAddresses) > 0 {
+		fmt.Fprintf(w, "IP addresses:\t%s\n", strings.Join(report.IPAddresses, ", "))
+	}
+	if len(report.URIs) > 0 {
+		fmt.Fprintf(w, "URIs:\t%s\n", strings.Join(report.URIs, ", "))
+	}
+	if len(report.Extensions) > 0 {
+		fmt.Fprintf(w, "Requested extensions:\t%s\n", strings.Join(report.Extensions, ", "))
+	}
+	fmt.Fprintf(w, "Public key algorithm:\t%s\n", report.PublicKeyAlgorithm)
+	if report.PublicKey != "" {
+		fmt.Fprintf(w, "Public key:\t%s\n", report.PublicKey)
+	}
+	fmt.Fprintf(w, "Signature algorithm:\t%s\n", report.SignatureAlgorithm)
+	if report.SignatureValid {
+		fmt.Fprintf(w, "Signature:\t%s\n", color.GreenString("valid"))
+	} else {
+		fmt.Fprintf(w, "Signature:\t%s\n", color.RedString(report.SignatureError))
+	}
+}
+
+// printVerificationResult prints the reason of a failed chain verification
+// or the paths of a successful one.
+func printVerificationResult(result *certmin.VerificationResult, sb *strings.Builder) {
+	if !result.Verified {
+		sb.WriteString("Reason: " + result.Failure)
+		if result.Err != nil {
+			sb.WriteString(" (" + result.Err.Error() + ")")
+		}
+		sb.WriteString("\n")
+		if result.FailedCert != nil {
+			sb.WriteString("Offending certificate: " + result.FailedCert.Subject.String() + "\n")
+		}
+		return
+	}
+
+	for idx, path := range result.Paths {
+		sb.WriteString(fmt.Sprintf("Path %d:\n", idx+1))
+		for certIdx, cert := range path.Certificates {
+			source := "from location or files"
+			if path.Sources[certIdx] == certmin.SourceSystem {
+				source = "from OS trust store"
+			}
+			sb.WriteString(fmt.Sprintf("  %s (%s)\n", cert.Subject.String(), source))
+		}
+	}
+}
+
 // promptForKeyPassword prompts the user for the password to
 // decrypt a private key. It returns the password string and
 // an error.
 func promptForKeyPassword() (string, error) {
-	fmt.Print("Enter the decryption password: ")
+	return promptForPassword("Enter the decryption password: ")
+}
+
+// promptForPassword prompts the user for a password. It returns
+// the password string and an error.
+func promptForPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
 	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
 	fmt.Println()
 	if err != nil {
@@ -256,16 +1124,166 @@ func promptForKeyPassword() (string, error) {
 	return string(bytePassword), nil
 }
 
-// writeCertFiles writes certificates to disk
-func writeCertFiles(certs []*x509.Certificate, cleanup bool) (string, error) {
+// renderOutput returns the text output or the reports encoded in the requested
+// format, together with the error of the action. On error, the error is added
+// to the last report.
+func renderOutput(
+	sb *strings.Builder, reports []*certmin.LocationReport, format string, err error) (string, error) {
+	if format == "" || format == outputText {
+		return sb.String(), err
+	}
+
+	if err != nil && len(reports) > 0 && reports[len(reports)-1].Error == "" {
+		reports[len(reports)-1].Error = err.Error()
+	}
+	if reports == nil {
+		reports = []*certmin.LocationReport{}
+	}
+
+	var output []byte
+	var marshalErr error
+	switch format {
+	case outputJSON:
+		output, marshalErr = json.MarshalIndent(reports, "", "  ")
+	case outputYAML:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		marshalErr = encoder.Encode(reports)
+		output = buf.Bytes()
+	default:
+		marshalErr = fmt.Errorf("unknown output format (%s)", format)
+	}
+	if marshalErr != nil {
+		return "", marshalErr
+	}
+
+	return strings.TrimSuffix(string(output), "\n"), err
+}
+
+// retrieveChain follows the Issuer Certificate URLs of a certificate, without
+// retrieving the root if --no-roots is given.
+func retrieveChain(cert *x509.Certificate, params Params) ([]*x509.Certificate, error) {
+	if params.noRoots {
+		return certmin.RetrieveChainWithoutRootFromIssuerURLs(cert, timeOut)
+	}
+	return certmin.RetrieveChainFromIssuerURLs(cert, timeOut)
+}
+
+// sctDescription returns a one line description of a verified SCT.
+func sctDescription(result *certmin.SCTResult) string {
+	log := "log " + base64.StdEncoding.EncodeToString(result.SCT.LogID)
+	if result.Log != nil {
+		log = result.Log.Description + " (" + result.Log.Operator + ")"
+	}
+
+	var status string
+	switch {
+	case result.Valid:
+		status = "valid"
+	case result.Log != nil:
+		status = "invalid (" + result.Err.Error() + ")"
+	default:
+		status = "not verified (" + result.Err.Error() + ")"
+	}
+
+	return fmt.Sprintf("%s, %s, %s: %s", log, result.SCT.Timestamp, result.SCT.Source, status)
+}
+
+// sshOptions returns the sorted critical options or extensions of an OpenSSH
+// certificate as a single line.
+func sshOptions(options map[string]string) string {
+	if len(options) == 0 {
+		return "none"
+	}
+	var names []string
+	for name, value := range options {
+		if value != "" {
+			name += "=" + value
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// stripRoots removes the self-signed roots from certificates, unless only
+// roots are found (e.g. when a root is skimmed).
+func stripRoots(certs []*x509.Certificate) []*x509.Certificate {
+	var stripped []*x509.Certificate
+	for _, cert := range certs {
+		if !certmin.IsRootCA(cert) {
+			stripped = append(stripped, cert)
+		}
+	}
+	if len(stripped) == 0 {
+		return certs
+	}
+	return stripped
+}
+
+// stripVerifiedRoots removes the roots at the end of the paths of a
+// VerificationResult.
+func stripVerifiedRoots(result *certmin.VerificationResult) {
+	for idx := range result.Paths {
+		path := &result.Paths[idx]
+		last := len(path.Certificates) - 1
+		if last > 0 && certmin.IsRootCA(path.Certificates[last]) {
+			path.Certificates = path.Certificates[:last]
+			path.Sources = path.Sources[:last]
+		}
+	}
+}
+
+// stripPathRoots removes the roots at the end of chain paths.
+func stripPathRoots(paths []*certmin.ChainPath) {
+	for _, path := range paths {
+		last := len(path.Certificates) - 1
+		if last > 0 && certmin.IsRootCA(path.Certificates[last]) {
+			root := path.Certificates[last]
+			path.Certificates = path.Certificates[:last]
+			path.Sources = path.Sources[:last]
+			var expired []*x509.Certificate
+			for _, cert := range path.Expired {
+				if cert != root {
+					expired = append(expired, cert)
+				}
+			}
+			path.Expired = expired
+		}
+	}
+}
+
+// verifySCTs verifies the SCTs of a certificate with the log list if given.
+func verifySCTs(scts []*certmin.SCT, tree *certmin.CertTree, logs *certmin.CTLogList) []*certmin.SCTResult {
+	if logs != nil {
+		return certmin.VerifySCTs(scts, tree, logs)
+	}
+
+	var results []*certmin.SCTResult
+	for _, sct := range scts {
+		results = append(results, &certmin.SCTResult{SCT: sct, Err: errors.New("no CT log list given")})
+	}
+	return results
+}
+
+// sshKeyName returns a short name of an OpenSSH public key or certificate.
+func sshKeyName(sshKey *certmin.SSHKey) string {
+	if cert := sshKey.Certificate(); cert != nil {
+		return "OpenSSH certificate " + cert.KeyId
+	}
+	return "OpenSSH public key " + ssh.FingerprintSHA256(sshKey.PublicKey)
+}
+
+// writeCertFiles writes certificates to disk and returns a message and the
+// names of the written files.
+func writeCertFiles(certs []*x509.Certificate, cleanup bool) (string, []string, error) {
 	tree := certmin.SplitCertsAsTree(certs)
 	if tree.Certificate == nil {
-		return "", errors.New("no certificate found")
+		return "", nil, errors.New("no certificate found")
 	}
 
-	rx := regexp.MustCompile("[^a-zA-Z0-9_-]")
-	baseName := "certmin_" + rx.ReplaceAllString(tree.Certificate.Subject.CommonName, "_") + "_" +
-		time.Now().Format("20060102150405")
+	baseName := fileBaseName(tree.Certificate.Subject.CommonName)
 
 	ext := make(map[int]string)
 	ext[0] = ".crt"
@@ -273,6 +1291,7 @@ func writeCertFiles(certs []*x509.Certificate, cleanup bool) (string, error) {
 	ext[2] = "_roots.crt"
 
 	var sb strings.Builder
+	var files []string
 	sb.WriteString("The following files were written:\n")
 
 	for idx, certArray := range [][]*x509.Certificate{{tree.Certificate}, tree.Intermediates, tree.Roots} {
@@ -283,30 +1302,97 @@ func writeCertFiles(certs []*x509.Certificate, cleanup bool) (string, error) {
 			if err != nil {
 				file, err = os.Create(path.Join(os.TempDir(), baseName+ext[idx]))
 				if err != nil {
-					return "", err
+					return "", nil, err
 				}
 			}
 		}
 		for _, cert := range certArray {
 			pemBytes, err := certmin.EncodeCertAsPKCS1PEM(cert)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			_, err = file.Write(pemBytes)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 
 		if file != nil {
 			file.Close()
 			sb.WriteString(file.Name() + "\n")
+			files = append(files, file.Name())
 		}
 		if cleanup {
 			defer os.Remove(file.Name())
 		}
 	}
 
-	return sb.String(), nil
+	return sb.String(), files, nil
+}
+
+// writeIssuedCert writes a certificate issued by the ca action followed by its
+// chain and, if not nil, its new key. It prints the certificate and the
+// verification of its chain.
+func writeIssuedCert(tree *certmin.CertTree, key *certmin.PrivateKey, params Params,
+	report *certmin.LocationReport, sb *strings.Builder) error {
+	cert := tree.Certificate
+	certFile := params.out
+	if certFile == "" {
+		name := cert.Subject.CommonName
+		if name == "" && len(cert.DNSNames) > 0 {
+			name = cert.DNSNames[0]
+		}
+		certFile = fileBaseName(name) + ".crt"
+	}
+	report.Location = certFile
+	report.Certificates = []*certmin.CertReport{certmin.NewCertReport(cert)}
+
+	certs := []*x509.Certificate{cert}
+	for _, issuer := range append(tree.Intermediates, tree.Roots...) {
+		if !issuer.Equal(cert) {
+			certs = append(certs, issuer)
+		}
+	}
+	certBytes, err := certmin.EncodeCertsAsPKCS1PEM(certs)
+	if err != nil {
+		return err
+	}
+
+	var keyFile string
+	var keyBytes []byte
+	if key != nil {
+		if keyFile, keyBytes, err = encodeNewKey(key, certFile, params.outPassword); err != nil {
+			return err
+		}
+	}
+
+	if err = ioutil.WriteFile(certFile, certBytes, 0644); err != nil {
+		return err
+	}
+	report.Files = append(report.Files, certFile)
+	if keyBytes != nil {
+		if err = ioutil.WriteFile(keyFile, keyBytes, 0600); err != nil {
+			return err
+		}
+		report.Files = append(report.Files, keyFile)
+	}
+
+	w := tabwriter.NewWriter(sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	printCert(cert, w, make(colourKeeper))
+	w.Flush()
+	verification := certmin.VerifyChainWithOptions(tree, certmin.VerifyOptions{
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if verification.Verified {
+		sb.WriteString(color.GreenString("certificate " + certName(cert) + " and its chain match\n"))
+	} else {
+		sb.WriteString(color.RedString("certificate " + certName(cert) + " and its chain do not match\n"))
+	}
+	printVerificationResult(verification, sb)
+	report.Verification = certmin.NewVerificationReport(verification)
+	sb.WriteString("---\n")
+	sb.WriteString("The following files were written:\n")
+	sb.WriteString(strings.Join(report.Files, "\n") + "\n")
+	return nil
 }
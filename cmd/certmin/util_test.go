@@ -1,10 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"text/tabwriter"
+	"time"
 
 	"github.com/nxadm/certmin"
 	"github.com/stretchr/testify/assert"
@@ -36,6 +44,56 @@ func TestAppendToCertTree(t *testing.T) {
 	assert.Equal(t, 5, len(certs2))
 }
 
+func TestDecodeCertFile(t *testing.T) {
+	certs, password, err := decodeCertFile("t/myserver.crt", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(certs))
+	assert.Equal(t, "1234", password)
+
+	_, _, err = decodeCertFile("t/doesnotexist.crt", "")
+	assert.Error(t, err)
+}
+
+func TestDecodeKeyFile(t *testing.T) {
+	key, err := decodeKeyFile("../../t/myserver_enc.key", "1234")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+
+	key, err = decodeKeyFile("../../t/ca.key", "1234")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+
+	_, err = decodeKeyFile("../../t/myserver_enc.key", "wrong")
+	assert.Error(t, err)
+}
+
+func TestExpiryDescription(t *testing.T) {
+	assert.Equal(t, "expires in 30 days", expiryDescription(30*24*time.Hour+time.Minute))
+	assert.Equal(t, "expires in 2h0m0s", expiryDescription(2*time.Hour))
+	assert.Equal(t, "expired 3 days ago", expiryDescription(-3*24*time.Hour))
+}
+
+func TestFileBaseName(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	assert.Regexp(t, "^certmin_myserver_\\d{14}$", fileBaseName(certs[0].Subject.CommonName))
+}
+
+func TestGetCertTree(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	tree, err := getCertTree(certs, Params{roots: []string{"../../t/ca.crt"}})
+	assert.NoError(t, err)
+	if assert.NotNil(t, tree) {
+		assert.Equal(t, certs[0], tree.Certificate)
+		assert.Equal(t, 1, len(tree.Roots))
+		assert.Empty(t, tree.Intermediates)
+	}
+
+	_, err = getCertTree(nil, Params{})
+	assert.Error(t, err)
+}
+
 func TestGetCerts(t *testing.T) {
 	var sb strings.Builder
 	certs, err := getCerts("", &sb)
@@ -57,56 +115,319 @@ func TestGetCerts(t *testing.T) {
 	}
 }
 
+func TestGetSCTs(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	sctBytes := append([]byte{0}, make([]byte, 32+8)...)
+	sctBytes = append(sctBytes, 0, 0, 4, 3, 0, 1, 0)
+
+	scts, err := getSCTs(certs[0], nil)
+	assert.NoError(t, err)
+	assert.Nil(t, scts)
+
+	info := &certmin.ConnectionInfo{
+		Certificates: certs,
+		SCTs:         [][]byte{sctBytes},
+		OCSPResult: &certmin.OCSPResult{
+			SCTs: []*certmin.SCT{{Source: certmin.SCTSourceOCSP}},
+		},
+	}
+	scts, err = getSCTs(certs[0], info)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(scts)) {
+		assert.Equal(t, certmin.SCTSourceTLS, scts[0].Source)
+		assert.Equal(t, certmin.SCTSourceOCSP, scts[1].Source)
+	}
+
+	info.SCTs = [][]byte{sctBytes[:10]}
+	_, err = getSCTs(certs[0], info)
+	assert.Error(t, err)
+}
+
 func TestGetLocation(t *testing.T) {
-	loc, remote, err := getLocation("util.go")
+	loc, protocol, remote, err := getLocation("util.go")
 	assert.NoError(t, err)
 	assert.Equal(t, "util.go", loc)
+	assert.Equal(t, certmin.StartTLSNone, protocol)
 	assert.False(t, remote)
 
-	loc, remote, err = getLocation("https://foo.fa/bar?baz")
+	loc, protocol, remote, err = getLocation("https://foo.fa/bar?baz")
 	assert.NoError(t, err)
 	assert.Equal(t, "foo.fa:443", loc)
+	assert.Equal(t, certmin.StartTLSNone, protocol)
 	assert.True(t, remote)
 
-	loc, remote, err = getLocation("foo/fa")
+	loc, protocol, remote, err = getLocation("foo/fa")
 	assert.NoError(t, err)
 	assert.Equal(t, "foo:443", loc)
 	assert.True(t, remote)
 
-	loc, remote, err = getLocation("foo:abc123")
+	loc, protocol, remote, err = getLocation("smtp://mail")
+	assert.NoError(t, err)
+	assert.Equal(t, "mail:25", loc)
+	assert.Equal(t, certmin.StartTLSSMTP, protocol)
+	assert.True(t, remote)
+
+	loc, protocol, remote, err = getLocation("foo:abc123")
+	assert.Error(t, err)
+}
+
+func TestLoadCSRTemplate(t *testing.T) {
+	template, err := loadCSRTemplate("../../t/csr_template.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "myserver", template.CommonName)
+	assert.Equal(t, "operations", template.OrganizationalUnit)
+	assert.Equal(t, []string{"myserver", "myserver.example.com"}, template.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1"}, template.IPAddresses)
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "template.json")
+	assert.NoError(t, ioutil.WriteFile(jsonFile,
+		[]byte(`{"common_name": "myserver", "uris": ["spiffe://example.com/myserver"]}`), 0644))
+	template, err = loadCSRTemplate(jsonFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spiffe://example.com/myserver"}, template.URIs)
+
+	invalidFile := filepath.Join(dir, "invalid.yaml")
+	assert.NoError(t, ioutil.WriteFile(invalidFile, []byte("commonname: myserver\n"), 0644))
+	_, err = loadCSRTemplate(invalidFile)
+	assert.Error(t, err)
+	_, err = loadCSRTemplate(filepath.Join(dir, "doesnotexist.yaml"))
+	assert.Error(t, err)
+}
+
+func TestNewCertProfile(t *testing.T) {
+	params := Params{
+		template:     "../../t/csr_template.yaml",
+		sans:         []string{"admin@example.com"},
+		validity:     24 * time.Hour,
+		keyUsage:     x509.KeyUsageDigitalSignature,
+		usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		maxPathLen:   -1,
+		permittedDNS: []string{"example.com"},
+		crlURLs:      []string{"http://ca.example.com/ca.crl"},
+	}
+	profile, err := newCertProfile(params)
+	assert.NoError(t, err)
+	assert.Equal(t, "myserver", profile.Subject.CommonName)
+	assert.Equal(t, []string{"admin@example.com"}, profile.Subject.EmailAddresses)
+	assert.Equal(t, 24*time.Hour, profile.Validity)
+	assert.Equal(t, x509.KeyUsageDigitalSignature, profile.KeyUsage)
+	assert.Equal(t, params.usages, profile.ExtKeyUsages)
+	assert.Equal(t, params.permittedDNS, profile.PermittedDNSDomains)
+	assert.Equal(t, params.crlURLs, profile.CRLDistributionPoints)
+	assert.False(t, profile.MaxPathLenZero)
+	assert.Equal(t, 0, profile.MaxPathLen)
+
+	profile, err = newCertProfile(Params{subject: "CN=inter", maxPathLen: 0})
+	assert.NoError(t, err)
+	assert.True(t, profile.MaxPathLenZero)
+	profile, err = newCertProfile(Params{subject: "CN=inter", maxPathLen: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, profile.MaxPathLen)
+
+	_, err = newCertProfile(Params{subject: "FOO=bar"})
+	assert.Error(t, err)
+	_, err = newCertProfile(Params{sans: []string{"dns:"}})
+	assert.Error(t, err)
+}
+
+func TestParseExtKeyUsages(t *testing.T) {
+	usages, err := parseExtKeyUsages([]string{"client", "Code-Signing"})
+	assert.NoError(t, err)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning}, usages)
+
+	usages, err = parseExtKeyUsages(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, usages)
+
+	_, err = parseExtKeyUsages([]string{"foo"})
+	assert.Error(t, err)
+}
+
+func TestParseKeyType(t *testing.T) {
+	tests := []struct {
+		input, algorithm, curve string
+		size                    int
+	}{
+		{"", certmin.KeyAlgorithmRSA, "", 0},
+		{"rsa:4096", certmin.KeyAlgorithmRSA, "", 4096},
+		{"ECDSA", certmin.KeyAlgorithmECDSA, "", 0},
+		{"ecdsa:p-384", certmin.KeyAlgorithmECDSA, "P-384", 0},
+		{"ed25519", certmin.KeyAlgorithmEd25519, "", 0},
+	}
+	for _, test := range tests {
+		algorithm, size, curve, err := parseKeyType(test.input)
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.algorithm, algorithm, test.input)
+		assert.Equal(t, test.size, size, test.input)
+		assert.Equal(t, test.curve, curve, test.input)
+	}
+
+	for _, input := range []string{"rsa:big", "ed25519:256", "dsa"} {
+		_, _, _, err := parseKeyType(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseKeyUsages(t *testing.T) {
+	usages, err := parseKeyUsages([]string{"digital-signature", "Key-Encipherment"})
+	assert.NoError(t, err)
+	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, usages)
+
+	usages, err = parseKeyUsages(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, x509.KeyUsage(0), usages)
+
+	_, err = parseKeyUsages([]string{"server"})
+	assert.Error(t, err)
+}
+
+func TestParseSAN(t *testing.T) {
+	var template certmin.CSRTemplate
+	for _, input := range []string{"myserver", "dns:10.0.0.1.example.com", "10.0.0.1", "::1",
+		"ip:fe80::1", "admin@example.com", "email:admin", "https://myserver", "URI:urn:myserver"} {
+		assert.NoError(t, parseSAN(input, &template), input)
+	}
+	assert.Equal(t, []string{"myserver", "10.0.0.1.example.com"}, template.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1", "::1", "fe80::1"}, template.IPAddresses)
+	assert.Equal(t, []string{"admin@example.com", "admin"}, template.EmailAddresses)
+	assert.Equal(t, []string{"https://myserver", "urn:myserver"}, template.URIs)
+
+	assert.Error(t, parseSAN("dns:", &template))
+}
+
+func TestParseSubject(t *testing.T) {
+	var template certmin.CSRTemplate
+	assert.NoError(t, parseSubject("CN=myserver, O=certmin,c=BE", &template))
+	assert.Equal(t, "myserver", template.CommonName)
+	assert.Equal(t, "certmin", template.Organization)
+	assert.Equal(t, "BE", template.Country)
+
+	template = certmin.CSRTemplate{}
+	assert.NoError(t, parseSubject("/C=BE/ST=Antwerp/L=Antwerp/O=certmin/OU=ops/CN=myserver", &template))
+	assert.Equal(t, certmin.CSRTemplate{CommonName: "myserver", Country: "BE", Province: "Antwerp",
+		Locality: "Antwerp", Organization: "certmin", OrganizationalUnit: "ops"}, template)
+
+	assert.NoError(t, parseSubject("", &template))
+	assert.Error(t, parseSubject("CN", &template))
+	assert.Error(t, parseSubject("E=admin@example.com", &template))
+}
+
+func TestParseThreshold(t *testing.T) {
+	for input, expected := range map[string]time.Duration{
+		"30":  30 * 24 * time.Hour,
+		"7d":  7 * 24 * time.Hour,
+		"36h": 36 * time.Hour,
+		"0":   0,
+	} {
+		duration, err := parseThreshold(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, duration, input)
+	}
+
+	for _, input := range []string{"", "foo", "-1d", "-5h"} {
+		_, err := parseThreshold(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	warning, critical, err := parseThresholds("30d", "7d", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, warning)
+	assert.Equal(t, 7*24*time.Hour, critical)
+
+	// The default warning threshold is raised to the critical one
+	warning, critical, err = parseThresholds("30d", "60d", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 60*24*time.Hour, warning)
+	assert.Equal(t, 60*24*time.Hour, critical)
+
+	warning, _, err = parseThresholds("30d", "60d", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, warning)
+
+	_, _, err = parseThresholds("foo", "7d", false)
+	assert.Error(t, err)
+	_, _, err = parseThresholds("30d", "foo", false)
+	assert.Error(t, err)
+}
+
+func TestParseTime(t *testing.T) {
+	parsed, err := parseTime("2022-01-02")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), parsed)
+
+	parsed, err = parseTime("2022-01-02T03:04:05Z")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), parsed)
+
+	_, err = parseTime("02/01/2022")
 	assert.Error(t, err)
 }
 
 func TestParseURL(t *testing.T) {
-	remote, err := parseURL("https://foo")
+	remote, protocol, err := parseURL("https://foo")
 	assert.Equal(t, "foo:443", remote)
+	assert.Equal(t, certmin.StartTLSNone, protocol)
 	assert.Nil(t, err)
 
-	remote, err = parseURL("ldaps://foo")
+	remote, _, err = parseURL("ldaps://foo")
 	assert.Equal(t, "foo:636", remote)
 	assert.Nil(t, err)
 
-	remote, err = parseURL("foo://foo")
+	remote, _, err = parseURL("foo://foo")
 	assert.Equal(t, "foo:443", remote)
 	assert.Nil(t, err)
 
-	remote, err = parseURL("https://foo:123")
+	remote, _, err = parseURL("https://foo:123")
 	assert.Equal(t, "foo:123", remote)
 	assert.Nil(t, err)
 
-	remote, err = parseURL("foo://foo:123")
+	remote, _, err = parseURL("foo://foo:123")
 	assert.Equal(t, "foo:123", remote)
 	assert.Nil(t, err)
 
-	_, err = parseURL("foo://foo:1AA23")
+	remote, protocol, err = parseURL("ldap://foo")
+	assert.Equal(t, "foo:389", remote)
+	assert.Equal(t, certmin.StartTLSLDAP, protocol)
+	assert.Nil(t, err)
+
+	remote, protocol, err = parseURL("imap://foo:1143")
+	assert.Equal(t, "foo:1143", remote)
+	assert.Equal(t, certmin.StartTLSIMAP, protocol)
+	assert.Nil(t, err)
+
+	remote, protocol, err = parseURL("xmpp://foo")
+	assert.Equal(t, "foo:5222", remote)
+	assert.Equal(t, certmin.StartTLSXMPP, protocol)
+	assert.Nil(t, err)
+
+	remote, protocol, err = parseURL("postgres://db")
+	assert.Equal(t, "db:5432", remote)
+	assert.Equal(t, certmin.StartTLSPostgres, protocol)
+	assert.Nil(t, err)
+
+	remote, protocol, err = parseURL("mysql://db:3307")
+	assert.Equal(t, "db:3307", remote)
+	assert.Equal(t, certmin.StartTLSMySQL, protocol)
+	assert.Nil(t, err)
+
+	_, _, err = parseURL("foo://foo:1AA23")
 	assert.NotNil(t, err)
-	_, err = parseURL("BLAH:123")
+	_, _, err = parseURL("BLAH:123")
 	assert.NotNil(t, err)
-	_, err = parseURL("BLAH.BOE")
+	_, _, err = parseURL("BLAH.BOE")
 	assert.NotNil(t, err)
 }
 
 //func printCert(cert *x509.Certificate, w *tabwriter.Writer, colourKeeper colourKeeper) {
+func TestPerfDataLabel(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "it's=me"}}
+	assert.Equal(t, "foo.crt it_s_me", perfDataLabel("foo.crt", cert))
+}
+
 func TestPrintCert(t *testing.T) {
 	certs, err := certmin.DecodeCertFile("t/myserver.crt", "")
 	assert.NoError(t, err)
@@ -119,15 +440,360 @@ func TestPrintCert(t *testing.T) {
 	assert.Contains(t, sb.String(), "CN=myserver")
 }
 
+func TestPrintChainPaths(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	roots, err := certmin.DecodeCertFile("../../t/ca.crt", "")
+	assert.NoError(t, err)
+	tree := &certmin.CertTree{Certificate: certs[0], Roots: roots}
+
+	var sb strings.Builder
+	printChainPaths(certmin.FindChainPaths(tree, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)), &sb)
+	assert.Contains(t, sb.String(), "Path 1: ends in a root of the --root files\n")
+	assert.Contains(t, sb.String(), "CN=Easy-RSA CA (from location or files)")
+
+	sb.Reset()
+	printChainPaths(certmin.FindChainPaths(tree, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)), &sb)
+	assert.Contains(t, sb.String(), "with expired certificates")
+	assert.Contains(t, sb.String(), "CN=myserver (from location or files, expired)")
+
+	sb.Reset()
+	printChainPaths(certmin.FindChainPaths(&certmin.CertTree{Certificate: certs[0]}, time.Time{}), &sb)
+	assert.Contains(t, sb.String(), "Path 1: does not end in a trusted root")
+}
+
+func TestPrintConnection(t *testing.T) {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	printConnection(&certmin.ConnectionInfo{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		NegotiatedProtocol: "h2",
+		OCSPResponse:       []byte{0x30},
+		OCSPResult:         &certmin.OCSPResult{Status: certmin.OCSPGood},
+		SCTs:               [][]byte{[]byte("sct")},
+	}, w)
+	w.Flush()
+	assert.Contains(t, sb.String(), "Connection:")
+	assert.Regexp(t, "TLS version:\\s+TLS 1.3", sb.String())
+	assert.Regexp(t, "Cipher suite:\\s+TLS_AES_128_GCM_SHA256", sb.String())
+	assert.Regexp(t, "ALPN protocol:\\s+h2", sb.String())
+	assert.Regexp(t, "Verified for server name:\\s+false", sb.String())
+	assert.Regexp(t, "Stapled OCSP response:\\s+good", sb.String())
+	assert.Regexp(t, "SCTs in TLS extension:\\s+1", sb.String())
+
+	sb.Reset()
+	printConnection(&certmin.ConnectionInfo{Version: tls.VersionTLS12}, w)
+	w.Flush()
+	assert.Regexp(t, "Stapled OCSP response:\\s+none", sb.String())
+	assert.NotContains(t, sb.String(), "ALPN")
+}
+
+func TestPrintCTPolicyResult(t *testing.T) {
+	results := []*certmin.SCTResult{
+		{
+			SCT:   &certmin.SCT{LogID: []byte{1}, Source: certmin.SCTSourceEmbedded},
+			Log:   &certmin.CTLog{Description: "Foo log", Operator: "Foo"},
+			Valid: true,
+		},
+		{
+			SCT: &certmin.SCT{LogID: []byte{2}, Source: certmin.SCTSourceTLS},
+			Log: &certmin.CTLog{Description: "Bar log", Operator: "Bar"},
+			Err: errors.New("invalid SCT signature"),
+		},
+	}
+
+	var sb strings.Builder
+	printCTPolicyResult(results, 1, &sb)
+	assert.Contains(t, sb.String(), "SCT: Foo log (Foo)")
+	assert.Contains(t, sb.String(), "invalid (invalid SCT signature)")
+	assert.Contains(t, sb.String(), "CT policy: met")
+
+	sb.Reset()
+	printCTPolicyResult(results, 2, &sb)
+	assert.Contains(t, sb.String(), "CT policy: not met")
+
+	sb.Reset()
+	printCTPolicyResult(results, 0, &sb)
+	assert.NotContains(t, sb.String(), "CT policy")
+}
+
+func TestPrintSCTs(t *testing.T) {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	results := verifySCTs([]*certmin.SCT{{LogID: []byte{0xff}, Source: certmin.SCTSourceEmbedded}}, nil, nil)
+	printSCTs(results, w)
+	w.Flush()
+	assert.Regexp(t, "SCT:\\s+log /w==, .*, embedded: not verified \\(no CT log list given\\)", sb.String())
+}
+
+func TestPrintSSHKey(t *testing.T) {
+	keys, err := certmin.DecodeSSHKeyFile("../../t/ssh_user-cert.pub")
+	assert.NoError(t, err)
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	printSSHKey(keys[0], w)
+	w.Flush()
+	assert.Regexp(t, "Key ID:\\s+alice", sb.String())
+	assert.Regexp(t, "Principals:\\s+alice, root", sb.String())
+	assert.Regexp(t, "Critical options:\\s+force-command=/usr/bin/uptime, source-address=10.0.0.0/8", sb.String())
+	assert.Regexp(t, "Signing CA:\\s+ssh-ed25519 SHA256:", sb.String())
+
+	keys, err = certmin.DecodeSSHKeyFile("../../t/ssh_host-cert.pub")
+	assert.NoError(t, err)
+	sb.Reset()
+	printSSHKey(keys[0], w)
+	w.Flush()
+	assert.Regexp(t, "Valid before:\\s+forever", sb.String())
+	assert.Regexp(t, "Extensions:\\s+none", sb.String())
+}
+
+func TestPrintCSR(t *testing.T) {
+	csr, err := certmin.DecodeCSRFile("../../t/myserver.csr")
+	assert.NoError(t, err)
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	printCSR(csr, w)
+	w.Flush()
+	assert.Regexp(t, "Subject:\\s+CN=myserver", sb.String())
+	assert.Regexp(t, "DNS names:\\s+myserver, myserver.example.com", sb.String())
+	assert.Regexp(t, "Requested extensions:\\s+.*Key Usage \\(critical\\)", sb.String())
+	assert.Regexp(t, "Public key:\\s+RSA 2048 bits", sb.String())
+	assert.Regexp(t, "Signature:\\s+.*valid", sb.String())
+
+	csr, err = certmin.DecodeCSRFile("../../t/myserver_bad_signature.csr")
+	assert.NoError(t, err)
+	sb.Reset()
+	printCSR(csr, w)
+	w.Flush()
+	assert.Regexp(t, "Signature:\\s+.*invalid CSR signature", sb.String())
+}
+
+func TestPrintDiagnosis(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("../../t/chain-out-of-order.crt", "")
+	assert.NoError(t, err)
+	diagnosis, err := certmin.DiagnoseChain(certs, 0)
+	assert.NoError(t, err)
+	var sb strings.Builder
+	printDiagnosis(diagnosis, &sb)
+	assert.Contains(t, sb.String(), "Served:\n  1: CN=DigiCert Global CA G2")
+	assert.Contains(t, sb.String(), "Chain to serve:\n  1: CN=exporl.med.kuleuven.be")
+	assert.Contains(t, sb.String(), "[error] leaf not first: the leaf is not the first certificate")
+	assert.Contains(t, sb.String(), "[info] root served: ")
+
+	sb.Reset()
+	certs, err = certmin.DecodeCertFile("../../t/myserver.crt", "")
+	assert.NoError(t, err)
+	diagnosis.Served, diagnosis.Chain, diagnosis.Findings = certs, certs, nil
+	printDiagnosis(diagnosis, &sb)
+	assert.Contains(t, sb.String(), "the served chain is correct")
+}
+
+func TestPrintKeyMatch(t *testing.T) {
+	var sb strings.Builder
+	report := &certmin.LocationReport{}
+	printKeyMatch("myserver.key", "CSR myserver", nil, report, &sb)
+	assert.Contains(t, sb.String(), "CSR myserver and its key match")
+	assert.True(t, report.KeyMatch.Match)
+
+	sb.Reset()
+	printKeyMatch("myserver.key", "CSR myserver", errors.New("public key mismatch"), report, &sb)
+	assert.Contains(t, sb.String(), "do not match: public key mismatch")
+	assert.False(t, report.KeyMatch.Match)
+	assert.Equal(t, "public key mismatch", report.KeyMatch.Reason)
+}
+
+func TestPrintCRL(t *testing.T) {
+	crl, err := certmin.DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 1, ' ', tabwriter.StripEscape)
+	printCRL(crl, w)
+	w.Flush()
+	assert.Contains(t, sb.String(), "CN=Easy-RSA CA")
+	assert.Regexp(t, "CRL number:\\s+4096", sb.String())
+	assert.Regexp(t, "Number of entries:\\s+1", sb.String())
+	assert.Regexp(t, "Delta CRL:\\s+no", sb.String())
+}
+
+func TestPrintCRLResult(t *testing.T) {
+	var sb strings.Builder
+	printCRLResult(&certmin.CRLResult{
+		Revoked:          true,
+		RevocationReason: 4,
+		Location:         "http://crl.example.com",
+	}, nil, &sb)
+	assert.Contains(t, sb.String(), "CRL status: revoked")
+	assert.Contains(t, sb.String(), "superseded")
+	assert.Contains(t, sb.String(), "CRL location: http://crl.example.com")
+
+	sb.Reset()
+	printCRLResult(&certmin.CRLResult{}, nil, &sb)
+	assert.Contains(t, sb.String(), "CRL status: not revoked")
+
+	sb.Reset()
+	printCRLResult(nil, errors.New("no CRL distribution points"), &sb)
+	assert.Contains(t, sb.String(), "could not be retrieved (no CRL distribution points)")
+}
+
+func TestPrintOCSPResult(t *testing.T) {
+	var sb strings.Builder
+	printOCSPResult(&certmin.OCSPResult{
+		Status:           certmin.OCSPRevoked,
+		RevocationReason: 1,
+		Responder:        "http://ocsp.example.com",
+	}, nil, &sb)
+	assert.Contains(t, sb.String(), "OCSP status: revoked")
+	assert.Contains(t, sb.String(), "key compromise")
+	assert.Contains(t, sb.String(), "OCSP responder: http://ocsp.example.com")
+
+	sb.Reset()
+	printOCSPResult(nil, errors.New("no OCSP servers"), &sb)
+	assert.Contains(t, sb.String(), "could not be retrieved (no OCSP servers)")
+}
+
+func TestPrintVerificationResult(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	var sb strings.Builder
+	printVerificationResult(certmin.VerifyChain(&certmin.CertTree{Certificate: certs[0], Roots: certs}), &sb)
+	assert.Contains(t, sb.String(), "Reason: ")
+	assert.Contains(t, sb.String(), "Offending certificate: CN=myserver")
+
+	sb.Reset()
+	result := &certmin.VerificationResult{
+		Verified: true,
+		Paths: []certmin.VerifiedPath{{
+			Certificates: certs,
+			Sources:      []string{certmin.SourceSystem},
+		}},
+	}
+	printVerificationResult(result, &sb)
+	assert.Contains(t, sb.String(), "Path 1:")
+	assert.Contains(t, sb.String(), "CN=myserver (from OS trust store)")
+}
+
+func TestRenderOutput(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("text output")
+	reports := []*certmin.LocationReport{{Location: "t/myserver.crt", Warning: "foo"}}
+
+	output, err := renderOutput(&sb, reports, outputText, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "text output", output)
+
+	output, err = renderOutput(&sb, reports, outputJSON, nil)
+	assert.NoError(t, err)
+	var decoded []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(output), &decoded))
+	if assert.Equal(t, 1, len(decoded)) {
+		assert.Equal(t, "t/myserver.crt", decoded[0]["location"])
+		assert.Equal(t, "foo", decoded[0]["warning"])
+	}
+
+	output, err = renderOutput(&sb, reports, outputYAML, errors.New("bar"))
+	assert.Error(t, err)
+	assert.Contains(t, output, "location: t/myserver.crt")
+	assert.Contains(t, output, "error: bar")
+
+	output, err = renderOutput(&sb, nil, outputJSON, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", output)
+
+	_, err = renderOutput(&sb, reports, "xml", nil)
+	assert.Error(t, err)
+}
+
 func TestPromptForKeyPassword(t *testing.T) {
 	t.SkipNow()
 }
 
+func TestSSHOptions(t *testing.T) {
+	assert.Equal(t, "none", sshOptions(nil))
+	assert.Equal(t, "force-command=/bin/true, permit-pty",
+		sshOptions(map[string]string{"permit-pty": "", "force-command": "/bin/true"}))
+}
+
+func TestSSHKeyName(t *testing.T) {
+	keys, err := certmin.DecodeSSHKeyFile("../../t/ssh_authorized_keys")
+	assert.NoError(t, err)
+	assert.Regexp(t, "^OpenSSH public key SHA256:", sshKeyName(keys[0]))
+	keys, err = certmin.DecodeSSHKeyFile("../../t/ssh_user-cert.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, "OpenSSH certificate alice", sshKeyName(keys[0]))
+}
+
+func TestStripRoots(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("../../t/chain-out-of-order.crt", "")
+	assert.NoError(t, err)
+	var roots int
+	for _, cert := range certs {
+		if certmin.IsRootCA(cert) {
+			roots++
+		}
+	}
+	assert.NotZero(t, roots)
+	stripped := stripRoots(certs)
+	assert.Equal(t, len(certs)-roots, len(stripped))
+	for _, cert := range stripped {
+		assert.False(t, certmin.IsRootCA(cert))
+	}
+
+	// Only roots are kept
+	caCerts, err := certmin.DecodeCertFile("../../t/ca.crt", "")
+	assert.NoError(t, err)
+	assert.Equal(t, caCerts, stripRoots(caCerts))
+}
+
+func TestStripVerifiedRoots(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("../../t/myserver.crt", "")
+	assert.NoError(t, err)
+	roots, err := certmin.DecodeCertFile("../../t/ca.crt", "")
+	assert.NoError(t, err)
+	result := certmin.VerifyChainWithOptions(&certmin.CertTree{Certificate: certs[0], Roots: roots},
+		certmin.VerifyOptions{CurrentTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.True(t, result.Verified)
+	stripVerifiedRoots(result)
+	if assert.Equal(t, 1, len(result.Paths)) {
+		assert.Equal(t, []*x509.Certificate{certs[0]}, result.Paths[0].Certificates)
+		assert.Equal(t, []string{certmin.SourceCertTree}, result.Paths[0].Sources)
+	}
+}
+
+func TestStripPathRoots(t *testing.T) {
+	certs, err := certmin.DecodeCertFile("../../t/myserver.crt", "")
+	assert.NoError(t, err)
+	roots, err := certmin.DecodeCertFile("../../t/ca.crt", "")
+	assert.NoError(t, err)
+	paths := []*certmin.ChainPath{
+		{
+			Certificates: []*x509.Certificate{certs[0], roots[0]},
+			Sources:      []string{certmin.SourceCertTree, certmin.SourceCertTree},
+			Root:         certmin.SourceCertTree,
+			Expired:      []*x509.Certificate{certs[0], roots[0]},
+		},
+		{
+			Certificates: []*x509.Certificate{certs[0]},
+			Sources:      []string{certmin.SourceCertTree},
+		},
+	}
+	stripPathRoots(paths)
+	assert.Equal(t, []*x509.Certificate{certs[0]}, paths[0].Certificates)
+	assert.Equal(t, []string{certmin.SourceCertTree}, paths[0].Sources)
+	assert.Equal(t, certmin.SourceCertTree, paths[0].Root)
+	assert.Equal(t, []*x509.Certificate{certs[0]}, paths[0].Expired)
+	assert.Equal(t, []*x509.Certificate{certs[0]}, paths[1].Certificates)
+}
+
 func TestWriteCertFiles(t *testing.T) {
 	certs, err := certmin.DecodeCertFile("t/cert-and-chain.crt", "")
 	assert.NoError(t, err)
 	assert.NotNil(t, certs)
-	output, err := writeCertFiles(certs, true)
+	output, files, err := writeCertFiles(certs, true)
 	assert.NoError(t, err)
 	assert.Contains(t, output, ".crt")
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		assert.Contains(t, output, file+"\n")
+	}
 }
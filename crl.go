@@ -0,0 +1,207 @@
+package certmin
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+var (
+	oidExtensionCRLNumber         = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReasonCode        = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidExtensionDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+)
+
+// CRLResult represents the revocation status of a certificate according to a CRL.
+// RevokedAt and RevocationReason are only set when Revoked is true. ThisUpdate and
+// NextUpdate are the ones of the CRL. Location is the URL the CRL was retrieved from
+// (if any).
+type CRLResult struct {
+	Revoked                bool
+	RevokedAt              time.Time
+	RevocationReason       int
+	ThisUpdate, NextUpdate time.Time
+	Location               string
+}
+
+// CheckCRL retrieves the revocation status of the Certificate of a CertTree by
+// downloading the CRLs found in the CRL distribution points of the certificate.
+// The issuer of the certificate must be present in the Intermediates or Roots of
+// the CertTree in order to verify the CRL. As parameters it takes a *CertTree and
+// a time-out duration for the HTTP connection with 0 disabling it. The return
+// values are a *CRLResult and an error in case of failure. The distribution points
+// are tried in order until a valid CRL is found. Delta CRLs are skipped, as they
+// don't list the certificates revoked in their base CRL.
+func CheckCRL(tree *CertTree, timeOut time.Duration) (*CRLResult, error) {
+	if tree == nil || tree.Certificate == nil {
+		return nil, errors.New("no certificate found")
+	}
+	if len(tree.Certificate.CRLDistributionPoints) == 0 {
+		return nil, errors.New("no CRL distribution points found in the certificate")
+	}
+	issuer, err := findIssuer(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: timeOut}
+	var lastErr error
+	for _, location := range tree.Certificate.CRLDistributionPoints {
+		resp, err := client.Get(location)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		crlBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("CRL distribution point %s returned %s", location, resp.Status)
+			continue
+		}
+
+		crl, err := DecodeCRLBytes(crlBytes)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if DeltaCRLIndicator(crl) != nil {
+			lastErr = fmt.Errorf("the CRL at %s is a delta CRL", location)
+			continue
+		}
+		if err = VerifyCRL(crl, &CertTree{Certificate: issuer}); err != nil {
+			lastErr = err
+			continue
+		}
+		if crl.HasExpired(time.Now()) {
+			lastErr = fmt.Errorf("the CRL at %s is outdated", location)
+			continue
+		}
+
+		result := LookupCRL(crl, tree.Certificate.SerialNumber)
+		result.Location = location
+		return result, nil
+	}
+
+	return nil, lastErr
+}
+
+// CRLNumber returns the CRL number of a CRL or nil if not present.
+func CRLNumber(crl *pkix.CertificateList) *big.Int {
+	return getCRLBigIntExtension(crl, oidExtensionCRLNumber)
+}
+
+// DecodeCRLBytes reads a []byte with a PEM or DER encoded CRL and returns
+// it as a *pkix.CertificateList and an error if encountered.
+func DecodeCRLBytes(crlBytes []byte) (*pkix.CertificateList, error) {
+	if block, _ := pem.Decode(crlBytes); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("not a PEM CRL (%s)", block.Type)
+		}
+		crlBytes = block.Bytes
+	}
+
+	// x509.ParseRevocationList only exists since Go 1.19 and certmin
+	// still builds with Go 1.15 (see go.mod), hence the deprecated API.
+	return x509.ParseDERCRL(crlBytes)
+}
+
+// DecodeCRLFile reads a file with a PEM or DER encoded CRL and returns it
+// as a *pkix.CertificateList and an error if encountered.
+func DecodeCRLFile(crlFile string) (*pkix.CertificateList, error) {
+	crlBytes, err := ioutil.ReadFile(crlFile)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCRLBytes(crlBytes)
+}
+
+// DeltaCRLIndicator returns the number of the base CRL when the CRL is a delta
+// CRL or nil otherwise.
+func DeltaCRLIndicator(crl *pkix.CertificateList) *big.Int {
+	return getCRLBigIntExtension(crl, oidExtensionDeltaCRLIndicator)
+}
+
+// LookupCRL looks for a serial number in the revoked certificates of a CRL and
+// returns the result as a *CRLResult.
+func LookupCRL(crl *pkix.CertificateList, serial *big.Int) *CRLResult {
+	result := CRLResult{
+		ThisUpdate: crl.TBSCertList.ThisUpdate,
+		NextUpdate: crl.TBSCertList.NextUpdate,
+	}
+
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(serial) != 0 {
+			continue
+		}
+		result.Revoked = true
+		result.RevokedAt = revoked.RevocationTime
+		for _, ext := range revoked.Extensions {
+			if !ext.Id.Equal(oidExtensionReasonCode) {
+				continue
+			}
+			var reason asn1.Enumerated
+			if _, err := asn1.Unmarshal(ext.Value, &reason); err == nil {
+				result.RevocationReason = int(reason)
+			}
+		}
+		break
+	}
+
+	return &result
+}
+
+// VerifyCRL verifies that a CRL was signed by one of the certificates of a CertTree
+// (the Certificate, Intermediates or Roots) and returns an error if not.
+func VerifyCRL(crl *pkix.CertificateList, tree *CertTree) error {
+	if tree == nil {
+		return errors.New("no certificate found")
+	}
+
+	var candidates []*x509.Certificate
+	if tree.Certificate != nil {
+		candidates = append(candidates, tree.Certificate)
+	}
+	candidates = append(candidates, tree.Intermediates...)
+	candidates = append(candidates, tree.Roots...)
+
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	for _, candidate := range candidates {
+		if candidate.Subject.String() != issuer.String() {
+			continue
+		}
+		// Deprecated, but CheckSignatureFrom on a *x509.RevocationList
+		// requires Go 1.19 (see DecodeCRLBytes).
+		if candidate.CheckCRLSignature(crl) == nil {
+			return nil
+		}
+	}
+
+	return errors.New("the CRL was not signed by any of the given certificates")
+}
+
+// getCRLBigIntExtension returns the value of a CRL extension with an INTEGER
+// value or nil if not present.
+func getCRLBigIntExtension(crl *pkix.CertificateList, oid asn1.ObjectIdentifier) *big.Int {
+	for _, ext := range crl.TBSCertList.Extensions {
+		if !ext.Id.Equal(oid) {
+			continue
+		}
+		var value *big.Int
+		if _, err := asn1.Unmarshal(ext.Value, &value); err == nil {
+			return value
+		}
+	}
+	return nil
+}
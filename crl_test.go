@@ -0,0 +1,203 @@
+package certmin
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCRL(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	other, otherKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+
+	var crlBytes []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write(crlBytes)
+	}))
+	t.Cleanup(server.Close)
+
+	leaf, _ := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "certmin test server"},
+		CRLDistributionPoints: []string{server.URL},
+	}, ca, caKey)
+	tree := &CertTree{Certificate: leaf, Roots: []*x509.Certificate{ca}}
+
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(2),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(1), RevocationTime: revokedAt},
+		},
+	}
+	var err error
+	crlBytes, err = x509.CreateRevocationList(rand.Reader, template, ca, caKey)
+	assert.NoError(t, err)
+	result, err := CheckCRL(tree, 2*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.False(t, result.Revoked)
+		assert.Equal(t, server.URL, result.Location)
+	}
+
+	reasonBytes, _ := asn1.Marshal(asn1.Enumerated(4))
+	template.RevokedCertificates = append(template.RevokedCertificates, pkix.RevokedCertificate{
+		SerialNumber:   leaf.SerialNumber,
+		RevocationTime: revokedAt,
+		Extensions:     []pkix.Extension{{Id: oidExtensionReasonCode, Value: reasonBytes}},
+	})
+	crlBytes, err = x509.CreateRevocationList(rand.Reader, template, ca, caKey)
+	assert.NoError(t, err)
+	result, err = CheckCRL(tree, 2*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.True(t, result.Revoked)
+		assert.Equal(t, revokedAt, result.RevokedAt.UTC())
+		assert.Equal(t, 4, result.RevocationReason)
+	}
+
+	// A delta CRL does not list the certificates revoked in the base CRL
+	deltaTemplate := *template
+	deltaTemplate.Number = big.NewInt(3)
+	deltaTemplate.RevokedCertificates = nil
+	baseBytes, _ := asn1.Marshal(big.NewInt(2))
+	deltaTemplate.ExtraExtensions = []pkix.Extension{
+		{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: baseBytes}}
+	crlBytes, err = x509.CreateRevocationList(rand.Reader, &deltaTemplate, ca, caKey)
+	assert.NoError(t, err)
+	result, err = CheckCRL(tree, 2*time.Second)
+	assert.EqualError(t, err, "the CRL at "+server.URL+" is a delta CRL")
+	assert.Nil(t, result)
+
+	// HTTP error
+	status = http.StatusNotFound
+	result, err = CheckCRL(tree, 2*time.Second)
+	assert.EqualError(t, err, "CRL distribution point "+server.URL+" returned 404 Not Found")
+	assert.Nil(t, result)
+	status = http.StatusOK
+
+	// Signed by another CA with the same name
+	crlBytes, err = x509.CreateRevocationList(rand.Reader, template, other, otherKey)
+	assert.NoError(t, err)
+	result, err = CheckCRL(tree, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+
+	// Outdated
+	template.NextUpdate = time.Now().Add(-time.Second)
+	crlBytes, err = x509.CreateRevocationList(rand.Reader, template, ca, caKey)
+	assert.NoError(t, err)
+	result, err = CheckCRL(tree, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+
+	// No distribution points
+	result, err = CheckCRL(&CertTree{Certificate: ca}, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestCRLNumber(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(4096), CRLNumber(crl))
+}
+
+func TestDecodeCRLBytes(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca_crl.der")
+	assert.NoError(t, err)
+	if assert.NotNil(t, crl) {
+		assert.Equal(t, 1, len(crl.TBSCertList.RevokedCertificates))
+	}
+
+	_, err = DecodeCRLBytes([]byte("foo"))
+	assert.Error(t, err)
+
+	certBytes, err := ioutil.ReadFile("t/myserver.crt")
+	assert.NoError(t, err)
+	_, err = DecodeCRLBytes(certBytes)
+	assert.Error(t, err)
+}
+
+func TestDecodeCRLFile(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	if assert.NotNil(t, crl) {
+		assert.Equal(t, 1, len(crl.TBSCertList.RevokedCertificates))
+	}
+
+	_, err = DecodeCRLFile("t/myserver.crt")
+	assert.Error(t, err)
+	_, err = DecodeCRLFile("t/does-not-exist.crl")
+	assert.Error(t, err)
+}
+
+func TestDeltaCRLIndicator(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	assert.Nil(t, DeltaCRLIndicator(crl))
+
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	baseBytes, _ := asn1.Marshal(big.NewInt(7))
+	crlBytes, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:          big.NewInt(8),
+		ThisUpdate:      time.Now(),
+		NextUpdate:      time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: baseBytes}},
+	}, ca, caKey)
+	assert.NoError(t, err)
+	crl, err = DecodeCRLBytes(crlBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(7), DeltaCRLIndicator(crl))
+}
+
+func TestLookupCRL(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+
+	result := LookupCRL(crl, certs[0].SerialNumber)
+	assert.True(t, result.Revoked)
+	assert.Equal(t, 1, result.RevocationReason)
+	assert.False(t, result.RevokedAt.IsZero())
+
+	result = LookupCRL(crl, big.NewInt(1))
+	assert.False(t, result.Revoked)
+	assert.False(t, result.ThisUpdate.IsZero())
+}
+
+func TestVerifyCRL(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	ca, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	ca2, err := DecodeCertFile("t/ca2.crt", "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, VerifyCRL(crl, &CertTree{Roots: ca}))
+	assert.NoError(t, VerifyCRL(crl, &CertTree{Certificate: ca[0]}))
+	assert.Error(t, VerifyCRL(crl, &CertTree{Roots: ca2}))
+	assert.Error(t, VerifyCRL(crl, nil))
+}
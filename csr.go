@@ -0,0 +1,176 @@
+package certmin
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+)
+
+// extensionNames are the names of the extensions commonly requested in a CSR.
+var extensionNames = map[string]string{
+	"2.5.29.14":          "Subject Key Identifier",
+	"2.5.29.15":          "Key Usage",
+	"2.5.29.17":          "Subject Alternative Name",
+	"2.5.29.19":          "Basic Constraints",
+	"2.5.29.32":          "Certificate Policies",
+	"2.5.29.37":          "Extended Key Usage",
+	"1.3.6.1.5.5.7.1.24": "TLS Feature",
+}
+
+// CSRTemplate holds the subject and the Subject Alternative Names of a CSR
+// generated by NewCSR. It can be read from a YAML or JSON file.
+type CSRTemplate struct {
+	CommonName         string   `json:"common_name" yaml:"common_name"`
+	Country            string   `json:"country,omitempty" yaml:"country,omitempty"`
+	Province           string   `json:"province,omitempty" yaml:"province,omitempty"`
+	Locality           string   `json:"locality,omitempty" yaml:"locality,omitempty"`
+	Organization       string   `json:"organization,omitempty" yaml:"organization,omitempty"`
+	OrganizationalUnit string   `json:"organizational_unit,omitempty" yaml:"organizational_unit,omitempty"`
+	DNSNames           []string `json:"dns_names,omitempty" yaml:"dns_names,omitempty"`
+	IPAddresses        []string `json:"ip_addresses,omitempty" yaml:"ip_addresses,omitempty"`
+	EmailAddresses     []string `json:"email_addresses,omitempty" yaml:"email_addresses,omitempty"`
+	URIs               []string `json:"uris,omitempty" yaml:"uris,omitempty"`
+}
+
+// DecodeCSRBytes reads a []byte with a PEM or DER encoded certificate signing
+// request (PKCS10) and returns it as a *x509.CertificateRequest and an error if
+// encountered. The signature of the CSR is not verified: use VerifyCSR.
+func DecodeCSRBytes(csrBytes []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(csrBytes); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("not a PEM CSR (%s)", block.Type)
+		}
+		csrBytes = block.Bytes
+	}
+
+	return x509.ParseCertificateRequest(csrBytes)
+}
+
+// DecodeCSRFile reads a file with a PEM or DER encoded certificate signing request
+// and returns it as a *x509.CertificateRequest and an error if encountered.
+func DecodeCSRFile(csrFile string) (*x509.CertificateRequest, error) {
+	csrBytes, err := ioutil.ReadFile(csrFile)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCSRBytes(csrBytes)
+}
+
+// EncodeCSRAsPEM converts *x509.CertificateRequest to a []byte with data encoded
+// as PEM and an error.
+func EncodeCSRAsPEM(csr *x509.CertificateRequest) ([]byte, error) {
+	if csr == nil {
+		return nil, errors.New("no CSR found")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}), nil
+}
+
+// ExtensionName returns the name of a certificate extension or its OID if the
+// extension is unknown.
+func ExtensionName(oid asn1.ObjectIdentifier) string {
+	if name, ok := extensionNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+// NewCSR generates a certificate signing request for the subject and the Subject
+// Alternative Names of a *CSRTemplate, signed with a key (*PrivateKey). It returns
+// a *x509.CertificateRequest and an error if encountered. A Common Name or at least
+// one Subject Alternative Name is required.
+func NewCSR(template *CSRTemplate, key *PrivateKey) (*x509.CertificateRequest, error) {
+	if template == nil {
+		return nil, errors.New("no CSR template found")
+	}
+	if key == nil || key.Signer == nil {
+		return nil, errors.New("no key found")
+	}
+	if template.CommonName == "" && len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 &&
+		len(template.EmailAddresses) == 0 && len(template.URIs) == 0 {
+		return nil, errors.New("a CSR requires a Common Name or a Subject Alternative Name")
+	}
+
+	subject, ips, uris, err := template.names()
+	if err != nil {
+		return nil, err
+	}
+	request := x509.CertificateRequest{
+		Subject:        subject,
+		DNSNames:       template.DNSNames,
+		EmailAddresses: template.EmailAddresses,
+		IPAddresses:    ips,
+		URIs:           uris,
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &request, key.Signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificateRequest(csrBytes)
+}
+
+// VerifyCSR verifies the signature of a certificate signing request with its own
+// public key, proving that the requester owns the private key. It returns nil
+// when the signature is valid and an error otherwise.
+func VerifyCSR(csr *x509.CertificateRequest) error {
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid CSR signature (%s)", err)
+	}
+	return nil
+}
+
+// VerifyCSRAndPrivateKey verifies that a certificate signing request
+// (*x509.CertificateRequest) was generated with a key (*PrivateKey): the signature
+// of the CSR must be valid and its public key must match the key. It returns nil
+// on a match, a *KeyMismatchError with the reason if the keys don't match and an
+// error if the signature is invalid.
+func VerifyCSRAndPrivateKey(csr *x509.CertificateRequest, key *PrivateKey) error {
+	if csr == nil {
+		return errors.New("no CSR found")
+	}
+	if err := VerifyCSR(csr); err != nil {
+		return err
+	}
+	return verifyPublicKeyAndPrivateKey(csr.PublicKey, key)
+}
+
+// names returns the subject and the parsed IP addresses and URIs of a
+// CSRTemplate.
+func (template *CSRTemplate) names() (pkix.Name, []net.IP, []*url.URL, error) {
+	subject := pkix.Name{CommonName: template.CommonName}
+	setName := func(field *[]string, value string) {
+		if value != "" {
+			*field = []string{value}
+		}
+	}
+	setName(&subject.Country, template.Country)
+	setName(&subject.Province, template.Province)
+	setName(&subject.Locality, template.Locality)
+	setName(&subject.Organization, template.Organization)
+	setName(&subject.OrganizationalUnit, template.OrganizationalUnit)
+
+	var ips []net.IP
+	for _, address := range template.IPAddresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return pkix.Name{}, nil, nil, fmt.Errorf("invalid IP address (%s)", address)
+		}
+		ips = append(ips, ip)
+	}
+	var uris []*url.URL
+	for _, location := range template.URIs {
+		uri, err := url.Parse(location)
+		if err != nil || uri.Scheme == "" {
+			return pkix.Name{}, nil, nil, fmt.Errorf("invalid URI (%s)", location)
+		}
+		uris = append(uris, uri)
+	}
+	return subject, ips, uris, nil
+}
@@ -0,0 +1,150 @@
+package certmin
+
+import (
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCSRBytes(t *testing.T) {
+	for _, file := range []string{"t/myserver.csr", "t/myserver_csr.der"} {
+		csrBytes, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		csr, err := DecodeCSRBytes(csrBytes)
+		if !assert.NoError(t, err, file) {
+			continue
+		}
+		assert.Equal(t, "myserver", csr.Subject.CommonName, file)
+		assert.Equal(t, []string{"myserver", "myserver.example.com"}, csr.DNSNames, file)
+		assert.Equal(t, []string{"admin@example.com"}, csr.EmailAddresses, file)
+		assert.Equal(t, 1, len(csr.IPAddresses), file)
+		assert.Equal(t, 1, len(csr.URIs), file)
+	}
+
+	certBytes, err := ioutil.ReadFile("t/myserver.crt")
+	assert.NoError(t, err)
+	_, err = DecodeCSRBytes(certBytes)
+	assert.Error(t, err)
+	_, err = DecodeCSRBytes([]byte("foo"))
+	assert.Error(t, err)
+}
+
+func TestDecodeCSRFile(t *testing.T) {
+	csr, err := DecodeCSRFile("t/ed25519.csr")
+	assert.NoError(t, err)
+	assert.NotNil(t, csr)
+
+	_, err = DecodeCSRFile("t/doesnotexist.csr")
+	assert.Error(t, err)
+}
+
+func TestEncodeCSRAsPEM(t *testing.T) {
+	csr, err := DecodeCSRFile("t/myserver_csr.der")
+	assert.NoError(t, err)
+	csrBytes, err := EncodeCSRAsPEM(csr)
+	assert.NoError(t, err)
+	pemBytes, err := ioutil.ReadFile("t/myserver.csr")
+	assert.NoError(t, err)
+	assert.Equal(t, pemBytes, csrBytes)
+
+	_, err = EncodeCSRAsPEM(nil)
+	assert.Error(t, err)
+}
+
+func TestExtensionName(t *testing.T) {
+	assert.Equal(t, "Subject Alternative Name", ExtensionName(asn1.ObjectIdentifier{2, 5, 29, 17}))
+	assert.Equal(t, "1.2.3.4", ExtensionName(asn1.ObjectIdentifier{1, 2, 3, 4}))
+}
+
+func TestNewCSR(t *testing.T) {
+	template := &CSRTemplate{
+		CommonName:     "myserver",
+		Country:        "BE",
+		Organization:   "certmin",
+		DNSNames:       []string{"myserver", "myserver.example.com"},
+		IPAddresses:    []string{"10.0.0.1", "::1"},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []string{"https://myserver.example.com"},
+	}
+	for _, keyFile := range []string{"t/myserver.key", "t/ecdsa_secp384r1.key", "t/ed25519.key"} {
+		key, err := DecodePrivateKeyFile(keyFile, "")
+		assert.NoError(t, err)
+		csr, err := NewCSR(template, key)
+		if !assert.NoError(t, err, keyFile) {
+			continue
+		}
+		assert.Equal(t, "CN=myserver,O=certmin,C=BE", csr.Subject.String(), keyFile)
+		assert.Equal(t, template.DNSNames, csr.DNSNames, keyFile)
+		assert.Equal(t, 2, len(csr.IPAddresses), keyFile)
+		assert.Equal(t, template.EmailAddresses, csr.EmailAddresses, keyFile)
+		assert.Equal(t, template.URIs[0], csr.URIs[0].String(), keyFile)
+		assert.NoError(t, VerifyCSRAndPrivateKey(csr, key), keyFile)
+	}
+
+	key, err := DecodePrivateKeyFile("t/myserver.key", "")
+	assert.NoError(t, err)
+	csr, err := NewCSR(&CSRTemplate{DNSNames: []string{"myserver"}}, key)
+	assert.NoError(t, err)
+	assert.Empty(t, csr.Subject.CommonName)
+
+	_, err = NewCSR(&CSRTemplate{}, key)
+	assert.Error(t, err)
+	_, err = NewCSR(&CSRTemplate{IPAddresses: []string{"foo"}}, key)
+	assert.Error(t, err)
+	_, err = NewCSR(&CSRTemplate{URIs: []string{"foo"}}, key)
+	assert.Error(t, err)
+	_, err = NewCSR(nil, key)
+	assert.Error(t, err)
+	_, err = NewCSR(template, nil)
+	assert.Error(t, err)
+}
+
+func TestVerifyCSR(t *testing.T) {
+	csr, err := DecodeCSRFile("t/myserver.csr")
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyCSR(csr))
+
+	csr, err = DecodeCSRFile("t/myserver_bad_signature.csr")
+	assert.NoError(t, err)
+	assert.Error(t, VerifyCSR(csr))
+}
+
+func TestVerifyCSRAndPrivateKey(t *testing.T) {
+	tests := []struct {
+		csrFile, keyFile, reason string
+	}{
+		{"t/myserver.csr", "t/myserver.key", ""},
+		{"t/myserver_csr.der", "t/myserver_key.der", ""},
+		{"t/ed25519.csr", "t/ed25519.key", ""},
+		{"t/myserver.csr", "t/myserver-fromca2.key", MismatchPublicKey},
+		{"t/ed25519.csr", "t/ecdsa_prime256v1.key", MismatchAlgorithm},
+	}
+	for _, test := range tests {
+		csr, err := DecodeCSRFile(test.csrFile)
+		assert.NoError(t, err)
+		key, err := DecodePrivateKeyFile(test.keyFile, "")
+		assert.NoError(t, err)
+		err = VerifyCSRAndPrivateKey(csr, key)
+		if test.reason == "" {
+			assert.NoError(t, err, test.csrFile)
+			continue
+		}
+		var mismatch *KeyMismatchError
+		if assert.True(t, errors.As(err, &mismatch), test.csrFile) {
+			assert.Equal(t, test.reason, mismatch.Reason, test.csrFile)
+		}
+	}
+
+	csr, err := DecodeCSRFile("t/myserver_bad_signature.csr")
+	assert.NoError(t, err)
+	key, err := DecodePrivateKeyFile("t/myserver.key", "")
+	assert.NoError(t, err)
+	err = VerifyCSRAndPrivateKey(csr, key)
+	var mismatch *KeyMismatchError
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &mismatch))
+	assert.Error(t, VerifyCSRAndPrivateKey(nil, key))
+}
@@ -0,0 +1,488 @@
+package certmin
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Sources of a Signed Certificate Timestamp.
+const (
+	SCTSourceEmbedded = "embedded"
+	SCTSourceTLS      = "TLS extension"
+	SCTSourceOCSP     = "OCSP response"
+)
+
+var (
+	oidExtensionSCTList     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidExtensionOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// Values used in the TLS encoding of a SCT (RFC 6962).
+const (
+	sctVersionV1             = 0
+	sctSignatureTypeCert     = 0
+	sctEntryTypeX509         = 0
+	sctEntryTypePrecert      = 1
+	sctHashAlgorithmSHA256   = 4
+	sctSignatureAlgorithmRSA = 1
+	sctSignatureAlgorithmEC  = 3
+)
+
+// SCT represents a Signed Certificate Timestamp (RFC 6962), the promise of a
+// Certificate Transparency log to include a certificate. Source is one of the
+// SCTSource constants.
+type SCT struct {
+	Version            uint8
+	LogID              []byte
+	Timestamp          time.Time
+	Extensions         []byte
+	HashAlgorithm      uint8
+	SignatureAlgorithm uint8
+	Signature          []byte
+	Source             string
+}
+
+// SCTResult represents the verification of a SCT. Log is the log that issued
+// the SCT or nil if not found in the log list. Valid is only true when the
+// signature of the log could be verified, with Err the reason otherwise.
+type SCTResult struct {
+	SCT   *SCT
+	Log   *CTLog
+	Valid bool
+	Err   error
+}
+
+// CTLog represents a Certificate Transparency log from a log list.
+type CTLog struct {
+	Description string
+	Operator    string
+	URL         string
+	LogID       []byte
+	Key         crypto.PublicKey
+}
+
+// CTLogList represents a list of Certificate Transparency logs as published
+// by Google and Apple (version 3 of the format).
+type CTLogList struct {
+	Logs []*CTLog
+}
+
+// ctLogListJSON is the JSON layout of a version 3 log list.
+type ctLogListJSON struct {
+	Operators []struct {
+		Name      string      `json:"name"`
+		Logs      []ctLogJSON `json:"logs"`
+		TiledLogs []ctLogJSON `json:"tiled_logs"`
+	} `json:"operators"`
+}
+
+// ctLogJSON is the JSON layout of a log in a version 3 log list.
+type ctLogJSON struct {
+	Description   string `json:"description"`
+	LogID         string `json:"log_id"`
+	Key           string `json:"key"`
+	URL           string `json:"url"`
+	SubmissionURL string `json:"submission_url"`
+}
+
+// CheckCTPolicy checks that at least the given number of valid SCTs were issued
+// by logs of distinct operators and returns an error if not.
+func CheckCTPolicy(results []*SCTResult, operators int) error {
+	found := make(map[string]bool)
+	for _, result := range results {
+		if result.Valid {
+			found[result.Log.Operator] = true
+		}
+	}
+	if len(found) < operators {
+		return fmt.Errorf("%d valid SCTs from distinct operators found, %d required",
+			len(found), operators)
+	}
+	return nil
+}
+
+// DecodeCTLogListBytes reads a []byte with a version 3 JSON log list and returns
+// it as a *CTLogList and an error if encountered.
+func DecodeCTLogListBytes(listBytes []byte) (*CTLogList, error) {
+	var listJSON ctLogListJSON
+	if err := json.Unmarshal(listBytes, &listJSON); err != nil {
+		return nil, err
+	}
+
+	var list CTLogList
+	for _, operator := range listJSON.Operators {
+		logs := append([]ctLogJSON{}, operator.Logs...)
+		logs = append(logs, operator.TiledLogs...)
+		for _, logJSON := range logs {
+			logID, err := base64.StdEncoding.DecodeString(logJSON.LogID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid log ID (%s)", logJSON.Description)
+			}
+			keyBytes, err := base64.StdEncoding.DecodeString(logJSON.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key (%s)", logJSON.Description)
+			}
+			key, err := x509.ParsePKIXPublicKey(keyBytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key (%s)", logJSON.Description)
+			}
+			url := logJSON.URL
+			if url == "" {
+				url = logJSON.SubmissionURL
+			}
+			list.Logs = append(list.Logs, &CTLog{
+				Description: logJSON.Description,
+				Operator:    operator.Name,
+				URL:         url,
+				LogID:       logID,
+				Key:         key,
+			})
+		}
+	}
+	if len(list.Logs) == 0 {
+		return nil, errors.New("no logs found in the log list")
+	}
+
+	return &list, nil
+}
+
+// DecodeCTLogListFile reads a file with a version 3 JSON log list and returns
+// it as a *CTLogList and an error if encountered.
+func DecodeCTLogListFile(listFile string) (*CTLogList, error) {
+	listBytes, err := ioutil.ReadFile(listFile)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeCTLogListBytes(listBytes)
+}
+
+// EmbeddedSCTs returns the SCTs embedded in a certificate and an error if the
+// extension could not be parsed. A certificate without SCTs returns nil.
+func EmbeddedSCTs(cert *x509.Certificate) ([]*SCT, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionSCTList) {
+			return parseSCTListExtension(ext.Value, SCTSourceEmbedded)
+		}
+	}
+	return nil, nil
+}
+
+// FindLog returns the log with the given log ID or nil if not found.
+func (list *CTLogList) FindLog(logID []byte) *CTLog {
+	for _, log := range list.Logs {
+		if bytes.Equal(log.LogID, logID) {
+			return log
+		}
+	}
+	return nil
+}
+
+// ParseSCT parses a single TLS encoded SCT (as sent in the TLS extension) and
+// returns it as a *SCT with the given source and an error if encountered.
+func ParseSCT(sctBytes []byte, source string) (*SCT, error) {
+	if len(sctBytes) < 1+32+8+2 {
+		return nil, errors.New("SCT too short")
+	}
+	if sctBytes[0] != sctVersionV1 {
+		return nil, fmt.Errorf("unsupported SCT version (%d)", sctBytes[0])
+	}
+
+	sct := SCT{
+		Version: sctBytes[0],
+		LogID:   append([]byte{}, sctBytes[1:33]...),
+		Source:  source,
+	}
+	timestamp := int64(binary.BigEndian.Uint64(sctBytes[33:41]))
+	sct.Timestamp = time.Unix(0, timestamp*int64(time.Millisecond)).UTC()
+
+	extensions, rest, err := readTLSVector(sctBytes[41:], 2)
+	if err != nil {
+		return nil, err
+	}
+	sct.Extensions = extensions
+
+	if len(rest) < 2 {
+		return nil, errors.New("SCT without signature")
+	}
+	sct.HashAlgorithm, sct.SignatureAlgorithm = rest[0], rest[1]
+	signature, rest, err := readTLSVector(rest[2:], 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after SCT")
+	}
+	sct.Signature = signature
+
+	return &sct, nil
+}
+
+// ParseSCTList parses a TLS encoded list of SCTs and returns them as a []*SCT
+// with the given source and an error if encountered.
+func ParseSCTList(listBytes []byte, source string) ([]*SCT, error) {
+	list, rest, err := readTLSVector(listBytes, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after SCT list")
+	}
+
+	var scts []*SCT
+	for len(list) > 0 {
+		var sctBytes []byte
+		sctBytes, list, err = readTLSVector(list, 2)
+		if err != nil {
+			return nil, err
+		}
+		sct, err := ParseSCT(sctBytes, source)
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// VerifySCT verifies the signature of a SCT issued for the Certificate of a
+// CertTree with the logs of a log list. Embedded SCTs are signed over the
+// precertificate, so the issuer must be present in the Intermediates or Roots
+// of the CertTree. It returns the log that issued the SCT (nil if unknown) and
+// an error if the SCT could not be verified.
+func VerifySCT(sct *SCT, tree *CertTree, logs *CTLogList) (*CTLog, error) {
+	if tree == nil || tree.Certificate == nil {
+		return nil, errors.New("no certificate found")
+	}
+	log := logs.FindLog(sct.LogID)
+	if log == nil {
+		return nil, errors.New("the log of the SCT is not in the log list")
+	}
+	if sct.HashAlgorithm != sctHashAlgorithmSHA256 {
+		return log, fmt.Errorf("unsupported SCT hash algorithm (%d)", sct.HashAlgorithm)
+	}
+
+	var entry []byte
+	if sct.Source == SCTSourceEmbedded {
+		issuer, err := findIssuer(tree)
+		if err != nil {
+			return log, err
+		}
+		tbs, err := removeSCTListExtension(tree.Certificate.RawTBSCertificate)
+		if err != nil {
+			return log, err
+		}
+		keyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		entry = appendUint16(nil, sctEntryTypePrecert)
+		entry = append(entry, keyHash[:]...)
+		entry = appendTLSVector(entry, tbs, 3)
+	} else {
+		entry = appendUint16(nil, sctEntryTypeX509)
+		entry = appendTLSVector(entry, tree.Certificate.Raw, 3)
+	}
+
+	signed := []byte{sct.Version, sctSignatureTypeCert}
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(sct.Timestamp.UnixNano()/int64(time.Millisecond)))
+	signed = append(signed, timestamp...)
+	signed = append(signed, entry...)
+	signed = appendTLSVector(signed, sct.Extensions, 2)
+	digest := sha256.Sum256(signed)
+
+	switch key := log.Key.(type) {
+	case *ecdsa.PublicKey:
+		if sct.SignatureAlgorithm != sctSignatureAlgorithmEC || !ecdsa.VerifyASN1(key, digest[:], sct.Signature) {
+			return log, errors.New("invalid SCT signature")
+		}
+	case *rsa.PublicKey:
+		if sct.SignatureAlgorithm != sctSignatureAlgorithmRSA ||
+			rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sct.Signature) != nil {
+			return log, errors.New("invalid SCT signature")
+		}
+	default:
+		return log, errors.New("unsupported log key type")
+	}
+
+	return log, nil
+}
+
+// VerifySCTs verifies a list of SCTs issued for the Certificate of a CertTree
+// with the logs of a log list (see VerifySCT) and returns a *SCTResult for each.
+func VerifySCTs(scts []*SCT, tree *CertTree, logs *CTLogList) []*SCTResult {
+	var results []*SCTResult
+	for _, sct := range scts {
+		log, err := VerifySCT(sct, tree, logs)
+		results = append(results, &SCTResult{SCT: sct, Log: log, Valid: err == nil, Err: err})
+	}
+	return results
+}
+
+// appendDERElement appends a DER element with the given tag and content.
+func appendDERElement(dst []byte, tag byte, content []byte) []byte {
+	dst = append(dst, tag)
+	length := len(content)
+	if length < 0x80 {
+		dst = append(dst, byte(length))
+	} else {
+		var lenBytes []byte
+		for ; length > 0; length >>= 8 {
+			lenBytes = append([]byte{byte(length)}, lenBytes...)
+		}
+		dst = append(dst, 0x80|byte(len(lenBytes)))
+		dst = append(dst, lenBytes...)
+	}
+	return append(dst, content...)
+}
+
+// appendTLSVector appends data prefixed by its length in lenBytes bytes.
+func appendTLSVector(dst, data []byte, lenBytes int) []byte {
+	for i := lenBytes - 1; i >= 0; i-- {
+		dst = append(dst, byte(len(data)>>(8*uint(i))))
+	}
+	return append(dst, data...)
+}
+
+// appendUint16 appends a big endian uint16.
+func appendUint16(dst []byte, value uint16) []byte {
+	return append(dst, byte(value>>8), byte(value))
+}
+
+// parseSCTListExtension parses the value of a certificate or OCSP extension with
+// a SCT list, which is a TLS encoded list wrapped in an OCTET STRING.
+func parseSCTListExtension(value []byte, source string) ([]*SCT, error) {
+	var listBytes []byte
+	rest, err := asn1.Unmarshal(value, &listBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after SCT list extension")
+	}
+	return ParseSCTList(listBytes, source)
+}
+
+// readDERElement reads a DER element with a single byte tag and returns the
+// tag, its content and the remaining bytes.
+func readDERElement(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("truncated ASN.1 element")
+	}
+	tag := data[0]
+	length := int(data[1])
+	data = data[2:]
+	if length&0x80 != 0 {
+		lenBytes := length & 0x7f
+		if lenBytes == 0 || lenBytes > 4 || len(data) < lenBytes {
+			return 0, nil, nil, errors.New("invalid ASN.1 length")
+		}
+		length = 0
+		for i := 0; i < lenBytes; i++ {
+			length = length<<8 | int(data[i])
+		}
+		data = data[lenBytes:]
+	}
+	if length < 0 || len(data) < length {
+		return 0, nil, nil, errors.New("truncated ASN.1 element")
+	}
+	return tag, data[:length], data[length:], nil
+}
+
+// readTLSVector reads data prefixed by its length in lenBytes bytes and returns
+// the data and the remaining bytes.
+func readTLSVector(data []byte, lenBytes int) ([]byte, []byte, error) {
+	if len(data) < lenBytes {
+		return nil, nil, errors.New("truncated TLS vector")
+	}
+	var length int
+	for i := 0; i < lenBytes; i++ {
+		length = length<<8 | int(data[i])
+	}
+	data = data[lenBytes:]
+	if len(data) < length {
+		return nil, nil, errors.New("truncated TLS vector")
+	}
+	return data[:length], data[length:], nil
+}
+
+// removeSCTListExtension removes the SCT list extension from a TBSCertificate,
+// resulting in the TBSCertificate of the precertificate. The other bytes are
+// kept verbatim, only the lengths of the enclosing elements are re-encoded.
+func removeSCTListExtension(rawTBS []byte) ([]byte, error) {
+	sctListOID, err := asn1.Marshal(oidExtensionSCTList)
+	if err != nil {
+		return nil, err
+	}
+	tag, tbs, rest, err := readDERElement(rawTBS)
+	if err != nil {
+		return nil, err
+	}
+	if tag != 0x30 || len(rest) > 0 {
+		return nil, errors.New("invalid TBSCertificate")
+	}
+
+	// The extensions ([3] EXPLICIT) are the last element of a TBSCertificate.
+	var offset int
+	for remaining := tbs; len(remaining) > 0; {
+		elemTag, content, next, err := readDERElement(remaining)
+		if err != nil {
+			return nil, err
+		}
+		if elemTag != 0xa3 {
+			offset += len(remaining) - len(next)
+			remaining = next
+			continue
+		}
+		if len(next) > 0 {
+			return nil, errors.New("trailing data after extensions")
+		}
+
+		seqTag, exts, seqRest, err := readDERElement(content)
+		if err != nil {
+			return nil, err
+		}
+		if seqTag != 0x30 || len(seqRest) > 0 {
+			return nil, errors.New("invalid extensions")
+		}
+		var kept []byte
+		found := false
+		for len(exts) > 0 {
+			_, ext, extRest, err := readDERElement(exts)
+			if err != nil {
+				return nil, err
+			}
+			raw := exts[:len(exts)-len(extRest)]
+			exts = extRest
+			_, _, oidRest, err := readDERElement(ext)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(ext[:len(ext)-len(oidRest)], sctListOID) {
+				found = true
+				continue
+			}
+			kept = append(kept, raw...)
+		}
+		if !found {
+			return rawTBS, nil
+		}
+
+		newTBS := append([]byte{}, tbs[:offset]...)
+		if len(kept) > 0 {
+			newTBS = appendDERElement(newTBS, 0xa3, appendDERElement(nil, 0x30, kept))
+		}
+		return appendDERElement(nil, 0x30, newTBS), nil
+	}
+
+	return rawTBS, nil
+}
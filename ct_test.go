@@ -0,0 +1,284 @@
+package certmin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCTLog is a Certificate Transparency log used for signing test SCTs.
+type testCTLog struct {
+	key   *ecdsa.PrivateKey
+	logID []byte
+}
+
+// newTestCTLogs creates a log per operator and returns them with the JSON log list.
+func newTestCTLogs(t *testing.T, operators ...string) ([]*testCTLog, []byte) {
+	var logs []*testCTLog
+	var operatorsJSON string
+	for idx, operator := range operators {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spki, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		logID := sha256.Sum256(spki)
+		logs = append(logs, &testCTLog{key: key, logID: logID[:]})
+		if idx > 0 {
+			operatorsJSON += ","
+		}
+		operatorsJSON += fmt.Sprintf(`{"name":%q,"logs":[{"description":"%s log",`+
+			`"log_id":%q,"key":%q,"url":"https://ct.example.com/%d/","mmd":86400}]}`,
+			operator, operator, base64.StdEncoding.EncodeToString(logID[:]),
+			base64.StdEncoding.EncodeToString(spki), idx)
+	}
+	return logs, []byte(`{"version":"3.0","operators":[` + operatorsJSON + `]}`)
+}
+
+// sign returns a TLS encoded SCT for the given log entry.
+func (log *testCTLog) sign(t *testing.T, timestamp time.Time, entry []byte) []byte {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(timestamp.UnixNano()/int64(time.Millisecond)))
+
+	signed := []byte{sctVersionV1, sctSignatureTypeCert}
+	signed = append(signed, ts...)
+	signed = append(signed, entry...)
+	signed = appendTLSVector(signed, nil, 2)
+	digest := sha256.Sum256(signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, log.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sct := []byte{sctVersionV1}
+	sct = append(sct, log.logID...)
+	sct = append(sct, ts...)
+	sct = appendTLSVector(sct, nil, 2)
+	sct = append(sct, sctHashAlgorithmSHA256, sctSignatureAlgorithmEC)
+	return appendTLSVector(sct, signature, 2)
+}
+
+// newTestCertWithSCTs creates a certificate issued by ca with SCTs of the given logs
+// embedded.
+func newTestCertWithSCTs(t *testing.T, ca *x509.Certificate, caKey crypto.Signer,
+	logs []*testCTLog, timestamp time.Time) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "certmin test server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	preDER, err := x509.CreateCertificate(rand.Reader, &template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre, err := x509.ParseCertificate(preDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+	entry := appendUint16(nil, sctEntryTypePrecert)
+	entry = append(entry, keyHash[:]...)
+	entry = appendTLSVector(entry, pre.RawTBSCertificate, 3)
+	var list []byte
+	for _, log := range logs {
+		list = appendTLSVector(list, log.sign(t, timestamp, entry), 2)
+	}
+	value, err := asn1.Marshal(appendTLSVector(nil, list, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.ExtraExtensions = []pkix.Extension{{Id: oidExtensionSCTList, Value: value}}
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestDecodeCTLogListBytes(t *testing.T) {
+	logs, listJSON := newTestCTLogs(t, "Foo", "Bar")
+	list, err := DecodeCTLogListBytes(listJSON)
+	assert.NoError(t, err)
+	if assert.NotNil(t, list) && assert.Equal(t, 2, len(list.Logs)) {
+		assert.Equal(t, "Foo log", list.Logs[0].Description)
+		assert.Equal(t, "Foo", list.Logs[0].Operator)
+		assert.Equal(t, "https://ct.example.com/0/", list.Logs[0].URL)
+		assert.Equal(t, list.Logs[1], list.FindLog(logs[1].logID))
+		assert.Nil(t, list.FindLog(make([]byte, 32)))
+	}
+
+	_, err = DecodeCTLogListBytes([]byte(`{"version":"3.0","operators":[]}`))
+	assert.Error(t, err)
+	_, err = DecodeCTLogListBytes([]byte(`{"operators":[{"name":"Foo","logs":[{"key":"Zm9v"}]}]}`))
+	assert.Error(t, err)
+	_, err = DecodeCTLogListBytes([]byte(`foo`))
+	assert.Error(t, err)
+}
+
+func TestDecodeCTLogListFile(t *testing.T) {
+	_, err := DecodeCTLogListFile("t/doesnotexist.json")
+	assert.Error(t, err)
+}
+
+func TestEmbeddedSCTs(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	logs, listJSON := newTestCTLogs(t, "Foo", "Bar", "Baz")
+	list, err := DecodeCTLogListBytes(listJSON)
+	assert.NoError(t, err)
+
+	timestamp := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	cert := newTestCertWithSCTs(t, ca, caKey, logs[:2], timestamp)
+	scts, err := EmbeddedSCTs(cert)
+	assert.NoError(t, err)
+	if !assert.Equal(t, 2, len(scts)) {
+		return
+	}
+	assert.Equal(t, SCTSourceEmbedded, scts[0].Source)
+	assert.Equal(t, timestamp, scts[0].Timestamp)
+	assert.Equal(t, logs[0].logID, scts[0].LogID)
+
+	tree := &CertTree{Certificate: cert, Roots: []*x509.Certificate{ca}}
+	results := VerifySCTs(scts, tree, list)
+	for _, result := range results {
+		assert.True(t, result.Valid)
+		assert.NoError(t, result.Err)
+	}
+	assert.Equal(t, "Foo", results[0].Log.Operator)
+	assert.Equal(t, "Bar", results[1].Log.Operator)
+	assert.NoError(t, CheckCTPolicy(results, 2))
+	assert.Error(t, CheckCTPolicy(results, 3))
+
+	// Without issuer
+	_, err = VerifySCT(scts[0], &CertTree{Certificate: cert}, list)
+	assert.Error(t, err)
+
+	// Tampered timestamp
+	scts[0].Timestamp = scts[0].Timestamp.Add(time.Second)
+	log, err := VerifySCT(scts[0], tree, list)
+	assert.Error(t, err)
+	assert.Equal(t, list.Logs[0], log)
+
+	// Unknown log
+	scts[1].LogID = make([]byte, 32)
+	log, err = VerifySCT(scts[1], tree, list)
+	assert.Error(t, err)
+	assert.Nil(t, log)
+
+	// No SCTs
+	scts, err = EmbeddedSCTs(ca)
+	assert.NoError(t, err)
+	assert.Nil(t, scts)
+}
+
+func TestParseSCT(t *testing.T) {
+	ca, _ := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	logs, listJSON := newTestCTLogs(t, "Foo")
+	list, err := DecodeCTLogListBytes(listJSON)
+	assert.NoError(t, err)
+
+	entry := appendUint16(nil, sctEntryTypeX509)
+	entry = appendTLSVector(entry, ca.Raw, 3)
+	sctBytes := logs[0].sign(t, time.Now(), entry)
+	sct, err := ParseSCT(sctBytes, SCTSourceTLS)
+	assert.NoError(t, err)
+	if assert.NotNil(t, sct) {
+		assert.Equal(t, SCTSourceTLS, sct.Source)
+		assert.Equal(t, uint8(sctHashAlgorithmSHA256), sct.HashAlgorithm)
+		log, err := VerifySCT(sct, &CertTree{Certificate: ca}, list)
+		assert.NoError(t, err)
+		assert.Equal(t, list.Logs[0], log)
+	}
+
+	scts, err := ParseSCTList(appendTLSVector(nil, appendTLSVector(nil, sctBytes, 2), 2), SCTSourceOCSP)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(scts))
+
+	_, err = ParseSCT(sctBytes[:len(sctBytes)-1], SCTSourceTLS)
+	assert.Error(t, err)
+	_, err = ParseSCT(append([]byte{1}, sctBytes[1:]...), SCTSourceTLS)
+	assert.Error(t, err)
+	_, err = ParseSCTList([]byte{0x00, 0x05, 0x00}, SCTSourceTLS)
+	assert.Error(t, err)
+}
+
+func TestRemoveSCTListExtension(t *testing.T) {
+	ca, _ := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	tbs, err := removeSCTListExtension(ca.RawTBSCertificate)
+	assert.NoError(t, err)
+	assert.Equal(t, ca.RawTBSCertificate, tbs)
+
+	// The other bytes are kept verbatim, e.g. an explicit non-critical flag
+	sctListOID, err := asn1.Marshal(oidExtensionSCTList)
+	assert.NoError(t, err)
+	other := appendDERElement(nil, 0x30, []byte{0x06, 0x02, 0x2a, 0x03, 0x01, 0x01, 0x00, 0x04, 0x00})
+	sctList := appendDERElement(nil, 0x30, append(sctListOID, 0x04, 0x00))
+	serial := []byte{0x02, 0x01, 0x01}
+	raw := appendDERElement(nil, 0x30, appendDERElement(serial, 0xa3,
+		appendDERElement(nil, 0x30, append(append([]byte{}, other...), sctList...))))
+	expected := appendDERElement(nil, 0x30, appendDERElement(serial, 0xa3,
+		appendDERElement(nil, 0x30, other)))
+	tbs, err = removeSCTListExtension(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, tbs)
+
+	_, err = removeSCTListExtension(raw[:len(raw)-1])
+	assert.Error(t, err)
+}
+
+func TestVerifySCTs(t *testing.T) {
+	certs, err := DecodeCertFile("t/kuleuven-be.pem", "")
+	assert.NoError(t, err)
+	issuers, err := DecodeCertFile("t/GEANTOVRSACA4.crt", "")
+	assert.NoError(t, err)
+	list, err := DecodeCTLogListFile("t/ct-log-list.json")
+	assert.NoError(t, err)
+
+	scts, err := EmbeddedSCTs(certs[0])
+	assert.NoError(t, err)
+	if !assert.Equal(t, 2, len(scts)) {
+		return
+	}
+	tree := &CertTree{Certificate: certs[0], Intermediates: issuers}
+	results := VerifySCTs(scts, tree, list)
+	for _, result := range results {
+		assert.True(t, result.Valid)
+		assert.NoError(t, result.Err)
+	}
+	assert.NoError(t, CheckCTPolicy(results, 2))
+}
@@ -0,0 +1,159 @@
+package certmin
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// Severities of a Finding.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Kinds of a Finding.
+const (
+	FindingLeafNotFirst        = "leaf not first"
+	FindingOutOfOrder          = "out of order"
+	FindingDuplicate           = "duplicate"
+	FindingUnrelated           = "unrelated"
+	FindingRootServed          = "root served"
+	FindingMissingIntermediate = "missing intermediate"
+	FindingIncompleteChain     = "incomplete chain"
+)
+
+// Diagnosis is the result of the comparison of the certificates served by a
+// host with the ideal chain. Chain is the chain the host should serve (from
+// the leaf up to, but not including, the root). Findings lists what is wrong
+// with the served certificates, no Findings meaning that the served chain is
+// fine.
+type Diagnosis struct {
+	Served   []*x509.Certificate
+	Chain    []*x509.Certificate
+	Findings []*Finding
+}
+
+// Finding is a problem found in served certificates. Severity is one of the
+// Severity constants and Kind one of the Finding constants. Certificate is the
+// certificate concerned (if any).
+type Finding struct {
+	Severity    string
+	Kind        string
+	Message     string
+	Certificate *x509.Certificate
+}
+
+// DiagnoseChain compares the certificates served by a host (e.g. as retrieved
+// by RetrieveCertsFromAddr) with the ideal chain, built from the served
+// certificates and completed by following the Issuing Certificate URLs when an
+// issuer is missing. As parameters it takes the served []*x509.Certificate in
+// the order they were served and a time-out duration for the HTTP connections
+// with 0 disabling it. Nil certificates are skipped. The return values are a
+// *Diagnosis and an error if no certificates were given or if no leaf was found
+// (e.g. CAs cross-signing each other).
+func DiagnoseChain(served []*x509.Certificate, timeOut time.Duration) (*Diagnosis, error) {
+	var certs []*x509.Certificate
+	for _, cert := range served {
+		if cert != nil {
+			certs = append(certs, cert)
+		}
+	}
+	served = certs
+	graph := NewCertGraph(served)
+	if len(graph.Certificates) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	leaves := graph.Leaves()
+	if len(leaves) == 0 {
+		return nil, errors.New("no leaf found, every certificate issued another served certificate")
+	}
+	leaf := leaves[0]
+	diagnosis := Diagnosis{Served: served}
+
+	if string(served[0].Raw) != string(leaf.Raw) {
+		diagnosis.add(SeverityError, FindingLeafNotFirst, "the leaf is not the first certificate", leaf)
+	}
+
+	seen := make(map[string]bool)
+	related := make(map[string]bool)
+	for _, path := range graph.Paths(leaf) {
+		for _, cert := range path {
+			related[string(cert.Raw)] = true
+		}
+	}
+	order := []*x509.Certificate{leaf}
+	for _, cert := range served {
+		switch {
+		case seen[string(cert.Raw)]:
+			diagnosis.add(SeverityWarning, FindingDuplicate, "the certificate is served more than once", cert)
+			continue
+		case !related[string(cert.Raw)]:
+			diagnosis.add(SeverityWarning, FindingUnrelated, "the certificate is not part of the chain of the leaf", cert)
+		case isSelfSigned(cert) && cert != leaf:
+			diagnosis.add(SeverityInfo, FindingRootServed,
+				"the root is served, while clients need their own trusted copy", cert)
+		}
+		seen[string(cert.Raw)] = true
+		if related[string(cert.Raw)] && cert != leaf {
+			order = append(order, cert)
+		}
+	}
+	for idx := 1; idx < len(order); idx++ {
+		if !containsCert(graph.Issuers(order[idx-1]), order[idx]) {
+			diagnosis.add(SeverityWarning, FindingOutOfOrder,
+				"the certificate does not follow its issued certificate", order[idx])
+			break
+		}
+	}
+
+	chains, _ := SortCertsAsChains(served, false)
+	for _, chain := range chains {
+		if chain[0] == leaf {
+			diagnosis.Chain = chain
+			break
+		}
+	}
+	last := diagnosis.Chain[len(diagnosis.Chain)-1]
+	if !isSelfSigned(last) && len(systemIssuers(last)) == 0 {
+		fetched, err := RetrieveChainFromIssuerURLs(last, timeOut)
+		for _, cert := range fetched[1:] {
+			if isSelfSigned(cert) {
+				break
+			}
+			diagnosis.add(SeverityError, FindingMissingIntermediate,
+				"the intermediate is not served, but can be retrieved from the Issuing Certificate URLs", cert)
+			diagnosis.Chain = append(diagnosis.Chain, cert)
+			last = cert
+		}
+		if !isSelfSigned(fetched[len(fetched)-1]) && len(systemIssuers(last)) == 0 {
+			msg := "the issuer is not served and could not be retrieved"
+			if err != nil {
+				msg += " (" + err.Error() + ")"
+			}
+			diagnosis.add(SeverityError, FindingIncompleteChain, msg, last)
+		}
+	}
+	if isSelfSigned(last) && len(diagnosis.Chain) > 1 {
+		diagnosis.Chain = diagnosis.Chain[:len(diagnosis.Chain)-1]
+	}
+
+	return &diagnosis, nil
+}
+
+// add adds a Finding to the Diagnosis.
+func (diagnosis *Diagnosis) add(severity, kind, msg string, cert *x509.Certificate) {
+	diagnosis.Findings = append(diagnosis.Findings,
+		&Finding{Severity: severity, Kind: kind, Message: msg, Certificate: cert})
+}
+
+// containsCert returns true if a certificate is part of a []*x509.Certificate.
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, candidate := range certs {
+		if candidate == cert {
+			return true
+		}
+	}
+	return false
+}
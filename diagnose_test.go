@@ -0,0 +1,106 @@
+package certmin
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnoseChain(t *testing.T) {
+	var root, inter *CA
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/root.crt":
+			w.Write(root.Certificate.Raw)
+		case "/inter.crt":
+			w.Write(inter.Certificate.Raw)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	root = newTestRootCA(t, "root")
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	inter, err = root.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "intermediate"},
+		IssuingCertificateURLs: []string{server.URL + "/root.crt"}}, key)
+	assert.NoError(t, err)
+	key, err = GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	tree, err := inter.IssueCert(&CertProfile{Subject: CSRTemplate{CommonName: "leaf"},
+		IssuingCertificateURLs: []string{server.URL + "/inter.crt"}}, key.Signer.Public())
+	assert.NoError(t, err)
+	leaf := tree.Certificate
+	other := newTestLeaf(t, newTestRootCA(t, "other root"), "other leaf")
+
+	kinds := func(diagnosis *Diagnosis) []string {
+		var kinds []string
+		for _, finding := range diagnosis.Findings {
+			kinds = append(kinds, finding.Kind)
+		}
+		return kinds
+	}
+
+	// A well served chain
+	diagnosis, err := DiagnoseChain([]*x509.Certificate{leaf, inter.Certificate}, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, diagnosis.Findings)
+	assert.Equal(t, []*x509.Certificate{leaf, inter.Certificate}, diagnosis.Chain)
+
+	// The intermediate is retrieved from the Issuing Certificate URLs
+	diagnosis, err = DiagnoseChain([]*x509.Certificate{leaf}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FindingMissingIntermediate}, kinds(diagnosis))
+	assert.Equal(t, SeverityError, diagnosis.Findings[0].Severity)
+	assert.Equal(t, inter.Certificate.Raw, diagnosis.Findings[0].Certificate.Raw)
+	if assert.Equal(t, 2, len(diagnosis.Chain)) {
+		assert.Equal(t, inter.Certificate.Raw, diagnosis.Chain[1].Raw)
+	}
+
+	// Everything wrong at once
+	diagnosis, err = DiagnoseChain([]*x509.Certificate{
+		inter.Certificate, leaf, leaf, root.Certificate, other}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FindingLeafNotFirst, FindingDuplicate, FindingRootServed, FindingUnrelated},
+		kinds(diagnosis))
+	assert.Equal(t, []*x509.Certificate{leaf, inter.Certificate}, diagnosis.Chain)
+
+	diagnosis, err = DiagnoseChain([]*x509.Certificate{leaf, root.Certificate, inter.Certificate}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FindingRootServed, FindingOutOfOrder}, kinds(diagnosis))
+	assert.Equal(t, SeverityInfo, diagnosis.Findings[0].Severity)
+	assert.Equal(t, root.Certificate, diagnosis.Findings[1].Certificate)
+
+	// Without Issuing Certificate URLs, the chain can not be completed
+	diagnosis, err = DiagnoseChain([]*x509.Certificate{other}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FindingIncompleteChain}, kinds(diagnosis))
+	assert.Equal(t, []*x509.Certificate{other}, diagnosis.Chain)
+
+	// Nil certificates are skipped
+	diagnosis, err = DiagnoseChain([]*x509.Certificate{nil, leaf, inter.Certificate}, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, diagnosis.Findings)
+	assert.Equal(t, []*x509.Certificate{leaf, inter.Certificate}, diagnosis.Served)
+
+	// CAs cross-signing each other have no leaf
+	caA := newTestRootCA(t, "ca A")
+	caB := newTestRootCA(t, "ca B")
+	crossA, err := caB.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "ca A"}}, caA.Key)
+	assert.NoError(t, err)
+	crossB, err := caA.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "ca B"}}, caB.Key)
+	assert.NoError(t, err)
+	_, err = DiagnoseChain([]*x509.Certificate{crossA.Certificate, crossB.Certificate}, 0)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no leaf found")
+	}
+
+	_, err = DiagnoseChain(nil, 0)
+	assert.Error(t, err)
+	_, err = DiagnoseChain([]*x509.Certificate{nil}, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,355 @@
+package certmin
+
+import (
+	"bytes"
+	"crypto/x509"
+	"time"
+)
+
+// CertGraph is a directed graph linking certificates to the certificates that
+// issued them. Certificates are unique by their DER encoding, so distinct
+// certificates sharing a subject (e.g. re-keyed or cross-signed CAs) are kept
+// apart. An issuer is a CA with the issuer name of the certificate as subject
+// and, when both are set, its Authority Key Identifier as Subject Key
+// Identifier. When several issuers qualify, the ones with a valid signature on
+// the certificate are preferred. Self-signed certificates have no issuers.
+type CertGraph struct {
+	Certificates    []*x509.Certificate
+	issuers, issued map[string][]*x509.Certificate
+}
+
+// ChainPath is a possible path from a certificate to a root found by
+// FindChainPaths. Sources has the same length as Certificates and states if a
+// certificate was part of the CertTree (SourceCertTree) or was found in the OS
+// trust store (SourceSystem). Root is SourceSystem when the path terminates in a
+// root of the OS trust store, SourceCertTree when it terminates in one of the
+// Roots of the CertTree and empty otherwise: a self-signed certificate among the
+// Intermediates (e.g. a root served by a host) is not a trusted root. Expired
+// holds the certificates of the path that are expired (or not yet valid).
+type ChainPath struct {
+	Certificates []*x509.Certificate
+	Sources      []string
+	Root         string
+	Expired      []*x509.Certificate
+}
+
+// FindChainPaths enumerates every path from the certificate of a CertTree to a
+// root, following all the issuers found in the CertTree and in the OS trust
+// store (e.g. both the self-signed and the cross-signed version of a CA). The
+// expiry of the certificates is checked at the given time.Time (now when zero).
+// Unlike VerifyChain, paths with expired certificates or without a root are
+// returned as well.
+func FindChainPaths(tree *CertTree, at time.Time) []*ChainPath {
+	if tree == nil || tree.Certificate == nil {
+		return nil
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	certs := append([]*x509.Certificate{tree.Certificate}, tree.Intermediates...)
+	certs = append(certs, tree.Roots...)
+	graph := NewCertGraph(certs)
+	inTree := make(map[string]bool)
+	for _, cert := range graph.Certificates {
+		inTree[string(cert.Raw)] = true
+	}
+	inRoots := make(map[string]bool)
+	for _, cert := range tree.Roots {
+		inRoots[string(cert.Raw)] = true
+	}
+
+	var paths [][]*x509.Certificate
+	seen := make(map[string]bool)
+	addPath := func(path []*x509.Certificate) {
+		var key []byte
+		for _, cert := range path {
+			key = append(key, cert.Raw...)
+		}
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			paths = append(paths, path)
+		}
+	}
+	systemRoots := make(map[string][]*x509.Certificate)
+	for _, path := range graph.Paths(tree.Certificate) {
+		addPath(path)
+		for idx, cert := range path {
+			roots, ok := systemRoots[string(cert.Raw)]
+			if !ok {
+				roots = systemIssuers(cert)
+				systemRoots[string(cert.Raw)] = roots
+			}
+			for _, root := range roots {
+				systemPath := append([]*x509.Certificate{}, path[:idx+1]...)
+				addPath(append(systemPath, root))
+			}
+		}
+	}
+
+	var chainPaths []*ChainPath
+	for _, path := range paths {
+		chainPath := ChainPath{Certificates: path}
+		for _, cert := range path {
+			if inTree[string(cert.Raw)] {
+				chainPath.Sources = append(chainPath.Sources, SourceCertTree)
+			} else {
+				chainPath.Sources = append(chainPath.Sources, SourceSystem)
+			}
+			if at.Before(cert.NotBefore) || at.After(cert.NotAfter) {
+				chainPath.Expired = append(chainPath.Expired, cert)
+			}
+		}
+		last := path[len(path)-1]
+		switch {
+		case !isSelfSigned(last):
+		case !inTree[string(last.Raw)] || isSystemRoot(last):
+			chainPath.Root = SourceSystem
+		case inRoots[string(last.Raw)]:
+			chainPath.Root = SourceCertTree
+		}
+		chainPaths = append(chainPaths, &chainPath)
+	}
+
+	return chainPaths
+}
+
+// NewCertGraph returns a *CertGraph for a []*x509.Certificate. Duplicate
+// certificates are removed.
+func NewCertGraph(certs []*x509.Certificate) *CertGraph {
+	graph := CertGraph{
+		issuers: make(map[string][]*x509.Certificate),
+		issued:  make(map[string][]*x509.Certificate),
+	}
+	seen := make(map[string]bool)
+	for _, cert := range certs {
+		if cert == nil || seen[string(cert.Raw)] {
+			continue
+		}
+		seen[string(cert.Raw)] = true
+		graph.Certificates = append(graph.Certificates, cert)
+	}
+
+	for _, cert := range graph.Certificates {
+		if isSelfSigned(cert) {
+			continue
+		}
+		var candidates []*x509.Certificate
+		for _, issuer := range graph.Certificates {
+			if issuer != cert && isIssuerCandidate(cert, issuer) {
+				candidates = append(candidates, issuer)
+			}
+		}
+		if len(candidates) > 1 {
+			var verified []*x509.Certificate
+			for _, issuer := range candidates {
+				err := issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+				if err == nil {
+					verified = append(verified, issuer)
+				}
+			}
+			if len(verified) > 0 {
+				candidates = verified
+			}
+		}
+		graph.issuers[string(cert.Raw)] = candidates
+		for _, issuer := range candidates {
+			graph.issued[string(issuer.Raw)] = append(graph.issued[string(issuer.Raw)], cert)
+		}
+	}
+
+	return &graph
+}
+
+// Chains returns the chains of the graph from the certificates returned by
+// Leaves up to their root, following the first issuer of each certificate.
+// Certificates left out (e.g. the other issuers of a cross-signed CA) start a
+// chain of their own, so every certificate is part of at least one chain.
+func (graph *CertGraph) Chains() [][]*x509.Certificate {
+	var chains [][]*x509.Certificate
+	covered := make(map[string]bool)
+	addChain := func(cert *x509.Certificate) {
+		chain := graph.chain(cert)
+		for _, chainCert := range chain {
+			covered[string(chainCert.Raw)] = true
+		}
+		chains = append(chains, chain)
+	}
+
+	for _, leaf := range graph.Leaves() {
+		addChain(leaf)
+	}
+	for {
+		var start *x509.Certificate
+		for _, cert := range graph.Certificates {
+			if covered[string(cert.Raw)] {
+				continue
+			}
+			if start == nil {
+				start = cert // in case of a loop
+			}
+			if graph.issuesUncovered(cert, covered) {
+				continue
+			}
+			start = cert
+			break
+		}
+		if start == nil {
+			break
+		}
+		addChain(start)
+	}
+
+	return chains
+}
+
+// Issued returns the certificates of the graph issued by a certificate.
+func (graph *CertGraph) Issued(cert *x509.Certificate) []*x509.Certificate {
+	return graph.issued[string(cert.Raw)]
+}
+
+// Issuers returns the certificates of the graph that issued a certificate.
+func (graph *CertGraph) Issuers(cert *x509.Certificate) []*x509.Certificate {
+	return graph.issuers[string(cert.Raw)]
+}
+
+// Leaves returns the certificates that did not issue any other certificate of
+// the graph, these being the start of the chains. Certificates that are not a
+// CA are returned first.
+func (graph *CertGraph) Leaves() []*x509.Certificate {
+	var leaves, caLeaves []*x509.Certificate
+	for _, cert := range graph.Certificates {
+		if len(graph.Issued(cert)) > 0 {
+			continue
+		}
+		if cert.IsCA {
+			caLeaves = append(caLeaves, cert)
+		} else {
+			leaves = append(leaves, cert)
+		}
+	}
+	return append(leaves, caLeaves...)
+}
+
+// Paths returns every path from a certificate up to a root or to the last
+// issuer found in the graph, following all the issuers of each certificate.
+func (graph *CertGraph) Paths(cert *x509.Certificate) [][]*x509.Certificate {
+	return graph.paths([]*x509.Certificate{cert}, map[string]bool{string(cert.Raw): true})
+}
+
+// chain follows the first issuer of a certificate up to the root, stopping
+// when a loop is detected.
+func (graph *CertGraph) chain(cert *x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{cert}
+	visited := map[string]bool{string(cert.Raw): true}
+	for {
+		var next *x509.Certificate
+		for _, issuer := range graph.Issuers(cert) {
+			if !visited[string(issuer.Raw)] {
+				next = issuer
+				break
+			}
+		}
+		if next == nil {
+			return chain
+		}
+		chain = append(chain, next)
+		visited[string(next.Raw)] = true
+		cert = next
+	}
+}
+
+// issuesUncovered returns true if a certificate issued a certificate that is
+// not part of a chain yet.
+func (graph *CertGraph) issuesUncovered(cert *x509.Certificate, covered map[string]bool) bool {
+	for _, issued := range graph.Issued(cert) {
+		if !covered[string(issued.Raw)] {
+			return true
+		}
+	}
+	return false
+}
+
+// paths returns the paths extending a path with the issuers of its last
+// certificate, skipping the certificates already part of the path.
+func (graph *CertGraph) paths(path []*x509.Certificate, visited map[string]bool) [][]*x509.Certificate {
+	var paths [][]*x509.Certificate
+	for _, issuer := range graph.Issuers(path[len(path)-1]) {
+		if visited[string(issuer.Raw)] {
+			continue
+		}
+		visited[string(issuer.Raw)] = true
+		next := append(append([]*x509.Certificate{}, path...), issuer)
+		paths = append(paths, graph.paths(next, visited)...)
+		delete(visited, string(issuer.Raw))
+	}
+	if len(paths) == 0 {
+		paths = [][]*x509.Certificate{path}
+	}
+	return paths
+}
+
+// isIssuerCandidate returns true if the subject and the Subject Key Identifier
+// of a CA match the issuer and the Authority Key Identifier of a certificate.
+func isIssuerCandidate(cert, issuer *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		return false
+	}
+	if issuer.BasicConstraintsValid && !issuer.IsCA {
+		return false
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 {
+		return bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId)
+	}
+	return true
+}
+
+// isSelfSigned returns true if the subject and the issuer of a certificate are
+// the same and its key identifiers (if set) don't point to another key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		return false
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(cert.SubjectKeyId) > 0 {
+		return bytes.Equal(cert.AuthorityKeyId, cert.SubjectKeyId)
+	}
+	return true
+}
+
+// isSystemRoot returns true if a self-signed certificate is a root of the OS
+// trust store.
+func isSystemRoot(cert *x509.Certificate) bool {
+	_, err := cert.Verify(x509.VerifyOptions{
+		CurrentTime: validityMiddle(cert),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// systemIssuers returns the roots of the OS trust store that issued a
+// certificate. The certificate is checked within its validity, so expired
+// certificates are linked to their roots as well.
+func systemIssuers(cert *x509.Certificate) []*x509.Certificate {
+	if isSelfSigned(cert) {
+		return nil
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		CurrentTime: validityMiddle(cert),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil
+	}
+	var roots []*x509.Certificate
+	for _, chain := range chains {
+		if len(chain) == 2 {
+			roots = append(roots, chain[1])
+		}
+	}
+	return roots
+}
+
+// validityMiddle returns the middle of the validity period of a certificate.
+func validityMiddle(cert *x509.Certificate) time.Time {
+	return cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) / 2)
+}
@@ -0,0 +1,298 @@
+package certmin
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCertGraph(t *testing.T) {
+	certs, err := DecodeCertFile("t/chain-out-of-order.crt", "")
+	assert.NoError(t, err)
+	graph := NewCertGraph(append(certs, certs[0]))
+	assert.Equal(t, len(certs), len(graph.Certificates))
+
+	leaf, err := FindLeaf(certs)
+	assert.NoError(t, err)
+	issuers := graph.Issuers(leaf)
+	if assert.Equal(t, 1, len(issuers)) {
+		assert.Equal(t, "GEANT OV RSA CA 4", issuers[0].Subject.CommonName)
+		assert.Equal(t, []*x509.Certificate{leaf}, graph.Issued(issuers[0]))
+	}
+
+	// Self-signed and issuers not in the graph
+	for _, cert := range certs {
+		switch cert.Subject.CommonName {
+		case "AAA Certificate Services", "DigiCert Global Root G2":
+			assert.Empty(t, graph.Issuers(cert), cert.Subject.CommonName)
+		}
+	}
+}
+
+func TestCertGraphReKeyed(t *testing.T) {
+	root := newTestRootCA(t, "root")
+	inter1 := newTestIntermediateCA(t, root, "intermediate")
+	inter2 := newTestIntermediateCA(t, root, "intermediate")
+	leaf1 := newTestLeaf(t, inter1, "leaf1")
+	leaf2 := newTestLeaf(t, inter2, "leaf2")
+
+	certs := []*x509.Certificate{leaf1, leaf2, inter1.Certificate, inter2.Certificate, root.Certificate}
+	graph := NewCertGraph(certs)
+	assert.Equal(t, 5, len(graph.Certificates))
+	assert.Equal(t, []*x509.Certificate{inter1.Certificate}, graph.Issuers(leaf1))
+	assert.Equal(t, []*x509.Certificate{inter2.Certificate}, graph.Issuers(leaf2))
+	assert.Equal(t, []*x509.Certificate{inter1.Certificate, inter2.Certificate}, graph.Issued(root.Certificate))
+	assert.Equal(t, []*x509.Certificate{leaf1, leaf2}, graph.Leaves())
+	assert.Equal(t, [][]*x509.Certificate{
+		{leaf1, inter1.Certificate, root.Certificate},
+		{leaf2, inter2.Certificate, root.Certificate},
+	}, graph.Chains())
+
+	assert.Equal(t, 5, len(SortCerts(certs, false)))
+	_, err := FindLeaf(certs)
+	assert.Error(t, err)
+
+	tree := SplitCertsAsTree([]*x509.Certificate{root.Certificate, inter2.Certificate, inter1.Certificate, leaf1})
+	assert.Equal(t, leaf1, tree.Certificate)
+	assert.Equal(t, []*x509.Certificate{inter1.Certificate, inter2.Certificate}, tree.Intermediates)
+	assert.Equal(t, []*x509.Certificate{root.Certificate}, tree.Roots)
+	assert.True(t, VerifyChain(tree).Verified)
+}
+
+func TestCertGraphSameNameRoots(t *testing.T) {
+	root1 := newTestRootCA(t, "root")
+	root2 := newTestRootCA(t, "root")
+	inter1 := newTestIntermediateCA(t, root1, "intermediate1")
+	inter2 := newTestIntermediateCA(t, root2, "intermediate2")
+
+	graph := NewCertGraph([]*x509.Certificate{
+		root2.Certificate, inter1.Certificate, root1.Certificate, inter2.Certificate})
+	assert.Equal(t, []*x509.Certificate{root1.Certificate}, graph.Issuers(inter1.Certificate))
+	assert.Equal(t, []*x509.Certificate{root2.Certificate}, graph.Issuers(inter2.Certificate))
+
+	tree := SplitCertsAsTree(graph.Certificates)
+	assert.Equal(t, inter1.Certificate, tree.Certificate)
+	assert.Equal(t, 2, len(tree.Roots))
+	assert.Equal(t, []*x509.Certificate{inter2.Certificate}, tree.Intermediates)
+}
+
+func TestCertGraphCrossSigned(t *testing.T) {
+	rootA := newTestRootCA(t, "root A")
+	rootB := newTestRootCA(t, "root B")
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	profile := CertProfile{Subject: CSRTemplate{CommonName: "intermediate"}}
+	interA, err := rootA.IssueIntermediateCA(&profile, key)
+	assert.NoError(t, err)
+	interB, err := rootB.IssueIntermediateCA(&profile, key)
+	assert.NoError(t, err)
+	leaf := newTestLeaf(t, interA, "leaf")
+
+	// A new root key cross-signed by the old root key has the same subject
+	// and issuer, but it is not self-signed
+	reKeyed, err := rootA.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "root A"}}, key)
+	assert.NoError(t, err)
+	assert.False(t, IsRootCA(reKeyed.Certificate))
+
+	graph := NewCertGraph([]*x509.Certificate{
+		leaf, interB.Certificate, interA.Certificate, rootA.Certificate, rootB.Certificate, reKeyed.Certificate})
+	assert.Equal(t, []*x509.Certificate{interB.Certificate, interA.Certificate}, graph.Issuers(leaf))
+	assert.Equal(t, []*x509.Certificate{rootA.Certificate}, graph.Issuers(reKeyed.Certificate))
+	assert.Equal(t, [][]*x509.Certificate{
+		{leaf, interB.Certificate, rootB.Certificate},
+		{reKeyed.Certificate, rootA.Certificate},
+		{interA.Certificate, rootA.Certificate},
+	}, graph.Chains())
+
+	// Every certificate is sorted once
+	assert.Equal(t, []*x509.Certificate{leaf, interB.Certificate, rootB.Certificate, reKeyed.Certificate,
+		rootA.Certificate, interA.Certificate}, SortCerts(graph.Certificates, false))
+	found, err := FindLeaf(graph.Certificates)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf, found)
+}
+
+func TestCertGraphSignatureTieBreak(t *testing.T) {
+	// Without key identifiers, issuers with the same subject are told apart by
+	// their signature
+	key1, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	key2, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	root1 := newTestCertWithoutKeyIDs(t, "root", nil, key1, key1)
+	root2 := newTestCertWithoutKeyIDs(t, "root", nil, key2, key2)
+	leaf := newTestCertWithoutKeyIDs(t, "leaf", root2, key2, key1)
+	assert.Empty(t, leaf.AuthorityKeyId)
+
+	graph := NewCertGraph([]*x509.Certificate{root1, root2, leaf})
+	assert.Equal(t, []*x509.Certificate{root2}, graph.Issuers(leaf))
+	// Without basic constraints, the roots are not marked as CA
+	assert.Equal(t, [][]*x509.Certificate{{root1}, {leaf, root2}}, graph.Chains())
+}
+
+func TestCertGraphPaths(t *testing.T) {
+	rootA := newTestRootCA(t, "root A")
+	rootB := newTestRootCA(t, "root B")
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	profile := CertProfile{Subject: CSRTemplate{CommonName: "intermediate"}}
+	interA, err := rootA.IssueIntermediateCA(&profile, key)
+	assert.NoError(t, err)
+	interB, err := rootB.IssueIntermediateCA(&profile, key)
+	assert.NoError(t, err)
+	leaf := newTestLeaf(t, interA, "leaf")
+
+	graph := NewCertGraph([]*x509.Certificate{
+		leaf, interA.Certificate, interB.Certificate, rootA.Certificate, rootB.Certificate})
+	assert.Equal(t, [][]*x509.Certificate{
+		{leaf, interA.Certificate, rootA.Certificate},
+		{leaf, interB.Certificate, rootB.Certificate},
+	}, graph.Paths(leaf))
+	assert.Equal(t, [][]*x509.Certificate{{rootA.Certificate}}, graph.Paths(rootA.Certificate))
+
+	// Without roots, the paths stop at the last issuer
+	graph = NewCertGraph([]*x509.Certificate{leaf, interA.Certificate})
+	assert.Equal(t, [][]*x509.Certificate{{leaf, interA.Certificate}}, graph.Paths(leaf))
+}
+
+func TestFindChainPaths(t *testing.T) {
+	// A hierarchy like the one of Let's Encrypt: the root is self-signed and
+	// cross-signed by an older root that expired
+	now := time.Now()
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	oldRoot, err := NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "old root"},
+		NotBefore: now.Add(-10 * DefaultLeafValidity), Validity: 9 * DefaultLeafValidity}, key)
+	assert.NoError(t, err)
+	key, err = GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	root, err := NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: "root"}}, key)
+	assert.NoError(t, err)
+	cross, err := oldRoot.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "root"},
+		NotBefore: now.Add(-5 * DefaultLeafValidity), Validity: 3 * DefaultLeafValidity}, key)
+	assert.NoError(t, err)
+	inter := newTestIntermediateCA(t, root, "intermediate")
+	leaf := newTestLeaf(t, inter, "leaf")
+
+	tree := CertTree{
+		Certificate:   leaf,
+		Intermediates: []*x509.Certificate{inter.Certificate, cross.Certificate},
+		Roots:         []*x509.Certificate{root.Certificate, oldRoot.Certificate},
+	}
+	paths := FindChainPaths(&tree, time.Time{})
+	assert.Equal(t, []*ChainPath{
+		{
+			Certificates: []*x509.Certificate{
+				leaf, inter.Certificate, cross.Certificate, oldRoot.Certificate},
+			Sources: []string{SourceCertTree, SourceCertTree, SourceCertTree, SourceCertTree},
+			Root:    SourceCertTree,
+			Expired: []*x509.Certificate{cross.Certificate, oldRoot.Certificate},
+		},
+		{
+			Certificates: []*x509.Certificate{leaf, inter.Certificate, root.Certificate},
+			Sources:      []string{SourceCertTree, SourceCertTree, SourceCertTree},
+			Root:         SourceCertTree,
+		},
+	}, paths)
+
+	// Before the expiry of the old root, its links are valid (but the newer
+	// certificates are not yet valid)
+	paths = FindChainPaths(&tree, now.Add(-3*DefaultLeafValidity))
+	assert.Len(t, paths, 2)
+	assert.Equal(t, []*x509.Certificate{leaf, inter.Certificate}, paths[0].Expired)
+
+	// Without the old root, the cross-signed path has no root
+	tree.Roots = []*x509.Certificate{root.Certificate}
+	paths = FindChainPaths(&tree, time.Time{})
+	assert.Len(t, paths, 2)
+	assert.Equal(t, "", paths[0].Root)
+	assert.Equal(t, SourceCertTree, paths[1].Root)
+
+	// A self-signed certificate that is not one of the Roots is not trusted
+	tree.Intermediates = append(tree.Intermediates, oldRoot.Certificate)
+	paths = FindChainPaths(&tree, time.Time{})
+	assert.Len(t, paths, 2)
+	assert.Equal(t, oldRoot.Certificate, paths[0].Certificates[3])
+	assert.Equal(t, "", paths[0].Root)
+
+	// The intermediate of Let's Encrypt cross-signed by DST Root CA X3
+	certs, err := DecodeCertFile("t/retrieve-pkcs7.crt", "")
+	assert.NoError(t, err)
+	roots, err := DecodeCertFile("t/dstrootcax3.p7c", "")
+	assert.NoError(t, err)
+	tree = CertTree{Certificate: certs[0], Roots: roots}
+	paths = FindChainPaths(&tree, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if assert.NotEmpty(t, paths) {
+		assert.Equal(t, []*x509.Certificate{certs[0], roots[0]}, paths[0].Certificates)
+		assert.Equal(t, SourceCertTree, paths[0].Root)
+		assert.Empty(t, paths[0].Expired)
+	}
+	paths = FindChainPaths(&tree, time.Time{})
+	if assert.NotEmpty(t, paths) {
+		assert.Equal(t, []*x509.Certificate{certs[0], roots[0]}, paths[0].Expired)
+	}
+
+	// A certificate issued by a root of the OS trust store
+	certs, err = DecodeCertFile("t/chain-out-of-order.crt", "")
+	assert.NoError(t, err)
+	tree = *SplitCertsAsTree(certs)
+	tree.Roots = nil
+	paths = FindChainPaths(&tree, time.Time{})
+	assert.NotEmpty(t, paths)
+	for _, path := range paths {
+		assert.Equal(t, tree.Certificate, path.Certificates[0])
+		assert.Equal(t, len(path.Certificates), len(path.Sources))
+	}
+
+	assert.Nil(t, FindChainPaths(nil, time.Time{}))
+	assert.Nil(t, FindChainPaths(&CertTree{}, time.Time{}))
+}
+
+func newTestRootCA(t *testing.T, commonName string) *CA {
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	ca, err := NewRootCA(&CertProfile{Subject: CSRTemplate{CommonName: commonName}}, key)
+	assert.NoError(t, err)
+	return ca
+}
+
+func newTestIntermediateCA(t *testing.T, ca *CA, commonName string) *CA {
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	inter, err := ca.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: commonName}}, key)
+	assert.NoError(t, err)
+	return inter
+}
+
+func newTestLeaf(t *testing.T, ca *CA, commonName string) *x509.Certificate {
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	tree, err := ca.IssueCert(&CertProfile{Subject: CSRTemplate{CommonName: commonName}}, key.Signer.Public())
+	assert.NoError(t, err)
+	return tree.Certificate
+}
+
+// newTestCertWithoutKeyIDs creates a certificate without Subject and Authority
+// Key Identifiers, signed by the parent (self-signed when nil) with its key.
+func newTestCertWithoutKeyIDs(t *testing.T, commonName string, parent *x509.Certificate,
+	parentKey, key *PrivateKey) *x509.Certificate {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		parent = &template
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, parent, key.Signer.Public(), parentKey.Signer)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(certBytes)
+	assert.NoError(t, err)
+	return cert
+}
@@ -0,0 +1,467 @@
+package certmin
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Types of a KeyStore.
+const (
+	KeyStoreTypeJKS   = "JKS"
+	KeyStoreTypeJCEKS = "JCEKS"
+)
+
+// Types of a KeyStoreEntry, as shown by keytool.
+const (
+	KeyStoreEntryPrivateKey  = "PrivateKeyEntry"
+	KeyStoreEntryTrustedCert = "trustedCertEntry"
+)
+
+const (
+	jksMagic             = 0xFEEDFEED
+	jceksMagic           = 0xCECECECE
+	jksVersion           = 2
+	jksTagPrivateKey     = 1
+	jksTagTrustedCert    = 2
+	jksTagSecretKey      = 3
+	jksCertType          = "X.509"
+	jksIntegritySuffix   = "Mighty Aphrodite"
+	jksKeyProtectorBlock = sha1.Size
+)
+
+var (
+	oidJKSKeyProtector         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+	oidPBEWithMD5AndTripleDES  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 19, 1}
+	rxKeyStoreAliasReplacement = regexp.MustCompile("[^a-z0-9._-]+")
+)
+
+// Errors of wrong keystore passwords. ErrKeyStorePassword is returned when the
+// integrity of a keystore does not match the store password and
+// ErrKeyStoreEntryPassword when the key of an entry can not be decrypted with
+// the entry password.
+var (
+	ErrKeyStorePassword      = errors.New("keystore password incorrect")
+	ErrKeyStoreEntryPassword = errors.New("keystore entry password incorrect")
+)
+
+// KeyStore is a decoded Java KeyStore (JKS or JCEKS).
+type KeyStore struct {
+	Type    string
+	Entries []*KeyStoreEntry
+}
+
+// KeyStoreEntry is an entry of a KeyStore. Certificates holds the chain of a
+// private key entry or the certificate of a trusted certificate entry. Key is
+// only set for private key entries when the entry password is given.
+type KeyStoreEntry struct {
+	Alias        string
+	Type         string
+	Created      time.Time
+	Certificates []*x509.Certificate
+	Key          *PrivateKey
+}
+
+// pbeParameter is the ASN.1 structure of the parameters of PBEWithMD5AndTripleDES.
+type pbeParameter struct {
+	Salt           []byte
+	IterationCount int
+}
+
+// keyStoreReader reads the big-endian encoded values of a KeyStore. The first
+// error stops the reading.
+type keyStoreReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// DecodeCertBytesJKS reads a []byte with a JKS or JCEKS keystore and returns the
+// certificates of its entries as a []*x509.Certificate and an error if encountered.
+// The integrity of the keystore is only checked when a password is given. If you
+// don't know in what format the data is encoded, use DecodeCertBytes.
+func DecodeCertBytesJKS(certBytes []byte, password string) ([]*x509.Certificate, error) {
+	keyStore, err := DecodeKeyStore(certBytes, password, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for _, entry := range keyStore.Entries {
+		certs = append(certs, entry.Certificates...)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// DecodeKeyBytesJKS reads a []byte with a JKS or JCEKS keystore and returns the
+// key of the first private key entry as a *pem.Block and an error if encountered.
+// The password is used for the keystore and the entry. If you don't know in what
+// format the data is encoded, use DecodeKeyBytes.
+func DecodeKeyBytesJKS(keyBytes []byte, password string) (*pem.Block, error) {
+	key, err := decodePrivateKeyJKS(keyBytes, password)
+	if err != nil {
+		return nil, err
+	}
+	return key.PEMBlock()
+}
+
+// DecodeKeyStore reads a []byte with a JKS or JCEKS keystore and returns a
+// *KeyStore and an error if encountered. The integrity of the keystore is only
+// checked when storePassword is given and the keys of the private key entries
+// are only decrypted when keyPassword is given. Secret key entries of JCEKS
+// keystores are not supported.
+func DecodeKeyStore(data []byte, storePassword, keyPassword string) (*KeyStore, error) {
+	reader := &keyStoreReader{data: data}
+	var keyStore KeyStore
+	switch reader.uint32() {
+	case jksMagic:
+		keyStore.Type = KeyStoreTypeJKS
+	case jceksMagic:
+		keyStore.Type = KeyStoreTypeJCEKS
+	default:
+		return nil, errors.New("not a Java keystore")
+	}
+	version := reader.uint32()
+	if reader.err == nil && version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported keystore version (%d)", version)
+	}
+
+	count := reader.uint32()
+	for idx := uint32(0); idx < count && reader.err == nil; idx++ {
+		tag := reader.uint32()
+		entry := KeyStoreEntry{Alias: reader.utf()}
+		entry.Created = time.Unix(0, int64(reader.uint64())*int64(time.Millisecond))
+
+		var encryptedKey []byte
+		var chainLength uint32
+		switch tag {
+		case jksTagPrivateKey:
+			entry.Type = KeyStoreEntryPrivateKey
+			encryptedKey = reader.bytes(int(reader.uint32()))
+			chainLength = reader.uint32()
+		case jksTagTrustedCert:
+			entry.Type = KeyStoreEntryTrustedCert
+			chainLength = 1
+		case jksTagSecretKey:
+			return nil, fmt.Errorf("secret key entries are not supported (%s)", entry.Alias)
+		default:
+			return nil, fmt.Errorf("unknown keystore entry (%d)", tag)
+		}
+
+		for certIdx := uint32(0); certIdx < chainLength && reader.err == nil; certIdx++ {
+			if version == 2 {
+				if certType := reader.utf(); reader.err == nil && certType != jksCertType {
+					return nil, fmt.Errorf("unsupported certificate type (%s)", certType)
+				}
+			}
+			certBytes := reader.bytes(int(reader.uint32()))
+			if reader.err != nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(certBytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in entry %s (%s)", entry.Alias, err)
+			}
+			entry.Certificates = append(entry.Certificates, cert)
+		}
+
+		if reader.err == nil && encryptedKey != nil && keyPassword != "" {
+			key, err := decryptKeyStoreKey(encryptedKey, keyPassword)
+			if err != nil {
+				return nil, fmt.Errorf("can not decrypt the key of entry %s (%w)", entry.Alias, err)
+			}
+			entry.Key = key
+		}
+		keyStore.Entries = append(keyStore.Entries, &entry)
+	}
+
+	digest := reader.bytes(sha1.Size)
+	if reader.err != nil {
+		return nil, fmt.Errorf("invalid keystore (%s)", reader.err)
+	}
+	if storePassword != "" &&
+		!bytes.Equal(digest, keyStoreDigest(data[:reader.pos-sha1.Size], storePassword)) {
+		return nil, ErrKeyStorePassword
+	}
+
+	return &keyStore, nil
+}
+
+// EncodeCertsAsJKS converts []*x509.Certificate to a []byte with a JKS truststore,
+// protected by a password, and an error. Every certificate becomes a trusted
+// certificate entry with an alias derived from its Common Name.
+func EncodeCertsAsJKS(certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	if password == "" {
+		return nil, errors.New("a JKS truststore requires a password")
+	}
+
+	var buf bytes.Buffer
+	write := func(value interface{}) { _ = binary.Write(&buf, binary.BigEndian, value) }
+	writeUTF := func(value string) {
+		write(uint16(len(value)))
+		buf.WriteString(value)
+	}
+
+	write(uint32(jksMagic))
+	write(uint32(jksVersion))
+	write(uint32(len(certs)))
+	created := time.Now().UnixNano() / int64(time.Millisecond)
+	seen := make(map[string]bool)
+	for idx, cert := range certs {
+		alias := rxKeyStoreAliasReplacement.ReplaceAllString(strings.ToLower(cert.Subject.CommonName), "_")
+		if alias == "" {
+			alias = "cert"
+		}
+		if seen[alias] {
+			alias += "-" + strconv.Itoa(idx+1)
+		}
+		seen[alias] = true
+
+		write(uint32(jksTagTrustedCert))
+		writeUTF(alias)
+		write(created)
+		writeUTF(jksCertType)
+		write(uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+	buf.Write(keyStoreDigest(buf.Bytes(), password))
+
+	return buf.Bytes(), nil
+}
+
+// DecodePrivateKeyKeyStore reads a []byte with a JKS or JCEKS keystore and
+// returns the key of the first private key entry as a *PrivateKey and an error if
+// encountered. The storePassword is used for the integrity of the keystore and
+// the keyPassword for the entry, as the entry may have its own password (see
+// ErrKeyStoreEntryPassword).
+func DecodePrivateKeyKeyStore(data []byte, storePassword, keyPassword string) (*PrivateKey, error) {
+	if keyPassword == "" {
+		return nil, errors.New("a password is needed for the keys of a keystore")
+	}
+	keyStore, err := DecodeKeyStore(data, storePassword, keyPassword)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range keyStore.Entries {
+		if entry.Key != nil {
+			if keyStore.Type == KeyStoreTypeJCEKS {
+				entry.Key.Format = KeyFormatJCEKS
+			}
+			return entry.Key, nil
+		}
+	}
+	return nil, errors.New("no private key entry found")
+}
+
+// decodePrivateKeyJKS returns the key of the first private key entry of a
+// keystore, using the password for the keystore and the entry.
+func decodePrivateKeyJKS(keyBytes []byte, password string) (*PrivateKey, error) {
+	return DecodePrivateKeyKeyStore(keyBytes, password, password)
+}
+
+// decryptKeyStoreKey decrypts the EncryptedPrivateKeyInfo of a private key entry,
+// protected with the proprietary JKS algorithm or with PBEWithMD5AndTripleDES
+// (JCEKS).
+func decryptKeyStoreKey(encryptedKey []byte, password string) (*PrivateKey, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(encryptedKey, &info); err != nil {
+		return nil, err
+	}
+
+	var keyBytes []byte
+	var err error
+	switch {
+	case info.EncryptionAlgorithm.Algorithm.Equal(oidJKSKeyProtector):
+		keyBytes, err = decryptJKSKeyProtector(info.EncryptedData, password)
+	case info.EncryptionAlgorithm.Algorithm.Equal(oidPBEWithMD5AndTripleDES):
+		keyBytes, err = decryptPBEWithMD5AndTripleDES(info.EncryptionAlgorithm, info.EncryptedData, password)
+	default:
+		err = fmt.Errorf("unsupported key protection (%s)", info.EncryptionAlgorithm.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(parsedKey, KeyFormatJKS)
+}
+
+// decryptJKSKeyProtector decrypts a key protected with the proprietary JKS
+// algorithm: the key is XORed with a SHA-1 based keystream and followed by a
+// SHA-1 check of the password and the key.
+func decryptJKSKeyProtector(data []byte, password string) ([]byte, error) {
+	if len(data) < 2*jksKeyProtectorBlock {
+		return nil, errors.New("invalid protected key")
+	}
+	passwordBytes := javaPasswordBytes(password)
+	salt := data[:jksKeyProtectorBlock]
+	encrypted := data[jksKeyProtectorBlock : len(data)-jksKeyProtectorBlock]
+	check := data[len(data)-jksKeyProtectorBlock:]
+
+	keyBytes := make([]byte, len(encrypted))
+	digest := salt
+	for offset := 0; offset < len(encrypted); offset += jksKeyProtectorBlock {
+		hash := sha1.Sum(append(append([]byte{}, passwordBytes...), digest...))
+		digest = hash[:]
+		for idx := 0; idx < jksKeyProtectorBlock && offset+idx < len(encrypted); idx++ {
+			keyBytes[offset+idx] = encrypted[offset+idx] ^ digest[idx]
+		}
+	}
+
+	hash := sha1.Sum(append(append([]byte{}, passwordBytes...), keyBytes...))
+	if !bytes.Equal(hash[:], check) {
+		return nil, ErrKeyStoreEntryPassword
+	}
+	return keyBytes, nil
+}
+
+// decryptPBEWithMD5AndTripleDES decrypts data with the proprietary
+// PBEWithMD5AndTripleDES algorithm of the SunJCE provider.
+func decryptPBEWithMD5AndTripleDES(algorithm pkix.AlgorithmIdentifier, data []byte,
+	password string) ([]byte, error) {
+	var params pbeParameter
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if len(params.Salt) != 8 {
+		return nil, errors.New("invalid PBEWithMD5AndTripleDES salt")
+	}
+	if len(data) == 0 || len(data)%des.BlockSize != 0 {
+		return nil, errors.New("invalid PBEWithMD5AndTripleDES data")
+	}
+
+	// If the salt halves are equal, the first half is inverted
+	salt := append([]byte{}, params.Salt...)
+	if bytes.Equal(salt[:4], salt[4:]) {
+		salt[0], salt[3] = salt[3], salt[0]
+		salt[1], salt[2] = salt[2], salt[1]
+	}
+
+	// PBE keys only use the lower 7 bits of the password characters
+	var passwordBytes []byte
+	for _, r := range password {
+		passwordBytes = append(passwordBytes, byte(r&0x7F))
+	}
+
+	var derived []byte
+	for half := 0; half < 2; half++ {
+		digest := salt[half*4 : (half+1)*4]
+		for idx := 0; idx < params.IterationCount; idx++ {
+			hash := md5.Sum(append(append([]byte{}, digest...), passwordBytes...))
+			digest = hash[:]
+		}
+		derived = append(derived, digest...)
+	}
+
+	block, err := des.NewTripleDESCipher(derived[:24])
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, derived[24:]).CryptBlocks(decrypted, data)
+
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > des.BlockSize {
+		return nil, ErrKeyStoreEntryPassword
+	}
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			return nil, ErrKeyStoreEntryPassword
+		}
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
+
+// isKeyStore returns if the data starts with the magic number of a JKS or
+// JCEKS keystore.
+func isKeyStore(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	magic := binary.BigEndian.Uint32(data)
+	return magic == jksMagic || magic == jceksMagic
+}
+
+// javaPasswordBytes returns a password as the big-endian UTF-16 encoded bytes
+// used by the JKS algorithms.
+func javaPasswordBytes(password string) []byte {
+	var passwordBytes []byte
+	for _, unit := range utf16.Encode([]rune(password)) {
+		passwordBytes = append(passwordBytes, byte(unit>>8), byte(unit))
+	}
+	return passwordBytes
+}
+
+// keyStoreDigest returns the SHA-1 integrity digest of a keystore.
+func keyStoreDigest(data []byte, password string) []byte {
+	hash := sha1.New()
+	hash.Write(javaPasswordBytes(password))
+	hash.Write([]byte(jksIntegritySuffix))
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+// bytes reads n bytes.
+func (reader *keyStoreReader) bytes(n int) []byte {
+	if reader.err != nil {
+		return nil
+	}
+	if n < 0 || reader.pos+n > len(reader.data) {
+		reader.err = errors.New("unexpected end of data")
+		return nil
+	}
+	value := reader.data[reader.pos : reader.pos+n]
+	reader.pos += n
+	return value
+}
+
+// uint32 reads a big-endian uint32.
+func (reader *keyStoreReader) uint32() uint32 {
+	value := reader.bytes(4)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(value)
+}
+
+// uint64 reads a big-endian uint64.
+func (reader *keyStoreReader) uint64() uint64 {
+	value := reader.bytes(8)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+// utf reads a string written by Java's DataOutput.writeUTF. The modified
+// UTF-8 encoding is the same as UTF-8 for the characters used in aliases.
+func (reader *keyStoreReader) utf() string {
+	value := reader.bytes(2)
+	if value == nil {
+		return ""
+	}
+	return string(reader.bytes(int(binary.BigEndian.Uint16(value))))
+}
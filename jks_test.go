@@ -0,0 +1,172 @@
+package certmin
+
+import (
+	"crypto/sha1"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCertBytesJKS(t *testing.T) {
+	for _, file := range []string{"t/myserver.jks", "t/ecdsa_prime256v1.jceks"} {
+		certBytes, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		certs, err := DecodeCertBytesJKS(certBytes, testPassword)
+		assert.NoError(t, err, file)
+		assert.NotEmpty(t, certs, file)
+
+		// Without a password, the integrity of the keystore is not checked
+		certs, err = DecodeCertBytesJKS(certBytes, "")
+		assert.NoError(t, err, file)
+		assert.NotEmpty(t, certs, file)
+
+		_, err = DecodeCertBytesJKS(certBytes, "wrong")
+		assert.Error(t, err, file)
+	}
+
+	certBytes, err := ioutil.ReadFile("t/myserver.jks")
+	assert.NoError(t, err)
+	certs, err := DecodeCertBytesJKS(certBytes, testPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(certs))
+	assert.Equal(t, "myserver", certs[0].Subject.CommonName)
+
+	certBytes, err = ioutil.ReadFile("t/myserver.crt")
+	assert.NoError(t, err)
+	_, err = DecodeCertBytesJKS(certBytes, testPassword)
+	assert.Error(t, err)
+}
+
+func TestDecodeKeyBytesJKS(t *testing.T) {
+	keyBytes, err := ioutil.ReadFile("t/myserver.jks")
+	assert.NoError(t, err)
+	block, err := DecodeKeyBytesJKS(keyBytes, testPassword)
+	assert.NoError(t, err)
+	if assert.NotNil(t, block) {
+		assert.Equal(t, "PRIVATE KEY", block.Type)
+		certs, err := DecodeCertFile("t/myserver.crt", "")
+		assert.NoError(t, err)
+		assert.True(t, VerifyCertAndKey(certs[0], block))
+	}
+
+	_, err = DecodeKeyBytesJKS(keyBytes, "")
+	assert.Error(t, err)
+	_, err = DecodeKeyBytesJKS(keyBytes, "wrong")
+	assert.Error(t, err)
+
+	// A truststore has no keys
+	certs, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	trustStore, err := EncodeCertsAsJKS(certs, testPassword)
+	assert.NoError(t, err)
+	_, err = DecodeKeyBytesJKS(trustStore, testPassword)
+	assert.Error(t, err)
+}
+
+func TestDecodePrivateKeyKeyStore(t *testing.T) {
+	// A keystore with another store password than the one of the key entry
+	data, err := ioutil.ReadFile("t/myserver.jks")
+	assert.NoError(t, err)
+	data = data[:len(data)-sha1.Size]
+	data = append(data, keyStoreDigest(data, "store")...)
+
+	key, err := DecodePrivateKeyKeyStore(data, "store", testPassword)
+	assert.NoError(t, err)
+	if assert.NotNil(t, key) {
+		assert.Equal(t, KeyFormatJKS, key.Format)
+	}
+
+	_, err = DecodePrivateKeyKeyStore(data, "store", "store")
+	assert.True(t, errors.Is(err, ErrKeyStoreEntryPassword))
+	_, err = DecodePrivateKeyKeyStore(data, testPassword, testPassword)
+	assert.Equal(t, ErrKeyStorePassword, err)
+	_, err = DecodePrivateKeyKeyStore(data, "store", "")
+	assert.Error(t, err)
+}
+
+func TestDecodeKeyStore(t *testing.T) {
+	created := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		file, keyStoreType, keyAlias, keyFormat string
+		entries, chain                          int
+	}{
+		{"t/myserver.jks", KeyStoreTypeJKS, "myserver", KeyFormatJKS, 2, 2},
+		{"t/ecdsa_prime256v1.jceks", KeyStoreTypeJCEKS, "ecdsa", KeyFormatJKS, 2, 1},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.file)
+		assert.NoError(t, err)
+		keyStore, err := DecodeKeyStore(data, testPassword, testPassword)
+		if !assert.NoError(t, err, test.file) {
+			continue
+		}
+		assert.Equal(t, test.keyStoreType, keyStore.Type, test.file)
+		if !assert.Equal(t, test.entries, len(keyStore.Entries), test.file) {
+			continue
+		}
+
+		entry := keyStore.Entries[0]
+		assert.Equal(t, test.keyAlias, entry.Alias, test.file)
+		assert.Equal(t, KeyStoreEntryPrivateKey, entry.Type, test.file)
+		assert.True(t, created.Equal(entry.Created), test.file)
+		assert.Equal(t, test.chain, len(entry.Certificates), test.file)
+		if assert.NotNil(t, entry.Key, test.file) {
+			assert.Equal(t, test.keyFormat, entry.Key.Format, test.file)
+			assert.NoError(t, VerifyCertAndPrivateKey(entry.Certificates[0], entry.Key), test.file)
+		}
+
+		entry = keyStore.Entries[1]
+		assert.Equal(t, "ca", entry.Alias, test.file)
+		assert.Equal(t, KeyStoreEntryTrustedCert, entry.Type, test.file)
+		assert.Equal(t, 1, len(entry.Certificates), test.file)
+		assert.Nil(t, entry.Key, test.file)
+
+		// The keys are only decrypted with a key password
+		keyStore, err = DecodeKeyStore(data, "", "")
+		assert.NoError(t, err, test.file)
+		assert.Nil(t, keyStore.Entries[0].Key, test.file)
+
+		_, err = DecodeKeyStore(data, "wrong", "")
+		assert.Equal(t, ErrKeyStorePassword, err, test.file)
+		_, err = DecodeKeyStore(data, testPassword, "wrong")
+		assert.Error(t, err, test.file)
+	}
+
+	_, err := DecodeKeyStore([]byte("foo"), "", "")
+	assert.Error(t, err)
+	data, err := ioutil.ReadFile("t/myserver.jks")
+	assert.NoError(t, err)
+	_, err = DecodeKeyStore(data[:len(data)/2], "", "")
+	assert.Error(t, err)
+}
+
+func TestEncodeCertsAsJKS(t *testing.T) {
+	certs, err := DecodeCertFile("t/chain.crt", "")
+	assert.NoError(t, err)
+	certs = append(certs, certs[0])
+	trustStore, err := EncodeCertsAsJKS(certs, testPassword)
+	assert.NoError(t, err)
+
+	keyStore, err := DecodeKeyStore(trustStore, testPassword, "")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyStoreTypeJKS, keyStore.Type)
+	if assert.Equal(t, len(certs), len(keyStore.Entries)) {
+		aliases := make(map[string]bool)
+		for idx, entry := range keyStore.Entries {
+			assert.Equal(t, KeyStoreEntryTrustedCert, entry.Type)
+			assert.Equal(t, certs[idx].Raw, entry.Certificates[0].Raw)
+			assert.False(t, aliases[entry.Alias], entry.Alias)
+			aliases[entry.Alias] = true
+		}
+	}
+
+	_, err = DecodeKeyStore(trustStore, "wrong", "")
+	assert.Error(t, err)
+	_, err = EncodeCertsAsJKS(certs, "")
+	assert.Error(t, err)
+	_, err = EncodeCertsAsJKS(nil, testPassword)
+	assert.Error(t, err)
+}
@@ -0,0 +1,333 @@
+package certmin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Algorithms of a PrivateKey.
+const (
+	KeyAlgorithmRSA     = "RSA"
+	KeyAlgorithmECDSA   = "ECDSA"
+	KeyAlgorithmEd25519 = "Ed25519"
+)
+
+// Container formats of a PrivateKey.
+const (
+	KeyFormatPKCS1            = "PKCS1"
+	KeyFormatEncryptedPKCS1   = "encrypted PKCS1"
+	KeyFormatSEC1             = "SEC1"
+	KeyFormatEncryptedSEC1    = "encrypted SEC1"
+	KeyFormatPKCS8            = "PKCS8"
+	KeyFormatEncryptedPKCS8   = "encrypted PKCS8"
+	KeyFormatPKCS12           = "PKCS12"
+	KeyFormatJKS              = "JKS"
+	KeyFormatJCEKS            = "JCEKS"
+	KeyFormatOpenSSH          = "OpenSSH"
+	KeyFormatEncryptedOpenSSH = "encrypted OpenSSH"
+)
+
+// errNotDERKey is returned by decodePrivateKeyDER for data that is not a DER key.
+var errNotDERKey = errors.New("not a DER key")
+
+// Reasons of a KeyMismatchError.
+const (
+	MismatchAlgorithm = "algorithm"
+	MismatchSize      = "key size"
+	MismatchCurve     = "curve"
+	MismatchPublicKey = "public key"
+)
+
+// PrivateKey is a parsed private key. Signer is a *rsa.PrivateKey, a
+// *ecdsa.PrivateKey or an ed25519.PrivateKey. Size is the size of the key in
+// bits, Curve the name of the curve of ECDSA keys (e.g. P-256) and Format the
+// container the key was read from (e.g. KeyFormatPKCS8, empty for generated keys).
+type PrivateKey struct {
+	Signer    crypto.Signer
+	Algorithm string
+	Size      int
+	Curve     string
+	Format    string
+}
+
+// KeyMismatchError is returned by VerifyCertAndPrivateKey when a certificate and
+// a key don't match. Reason is the first difference found and Certificate and
+// Key describe the public key of the certificate and the key.
+type KeyMismatchError struct {
+	Reason           string
+	Certificate, Key string
+}
+
+func (err *KeyMismatchError) Error() string {
+	return err.Reason + " mismatch (certificate: " + err.Certificate + ", key: " + err.Key + ")"
+}
+
+// DecodePrivateKey reads a []byte with a PEM (PKCS1, SEC1, PKCS8, encrypted PKCS8,
+// PKCS1 and SEC1 encrypted with Proc-Type and DEK-Info headers or OpenSSH), DER
+// (PKCS1, SEC1, PKCS8 or encrypted PKCS8), PKCS12 or JKS/JCEKS encoded private key
+// and returns a *PrivateKey and an error if encountered. A password is only
+// needed for encrypted keys. The first private key found in a PEM bundle is
+// returned.
+func DecodePrivateKey(keyBytes []byte, password string) (*PrivateKey, error) {
+	if strings.Contains(string(keyBytes), "-----BEGIN") {
+		rest := keyBytes
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if strings.Contains(block.Type, "PRIVATE KEY") {
+				return decodePrivateKeyPEMBlock(block, password)
+			}
+		}
+		return nil, errors.New("no private key found")
+	}
+
+	if isKeyStore(keyBytes) {
+		return decodePrivateKeyJKS(keyBytes, password)
+	}
+	if key, err := decodePrivateKeyDER(keyBytes, password); err != errNotDERKey {
+		return key, err
+	}
+
+	parsedKey, _, _, err := pkcs12.DecodeChain(keyBytes, password)
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(parsedKey, KeyFormatPKCS12)
+}
+
+// DecodePrivateKeyFile reads a file with a private key and returns a *PrivateKey
+// and an error if encountered. See DecodePrivateKey for the supported formats.
+func DecodePrivateKeyFile(keyFile, password string) (*PrivateKey, error) {
+	keyBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePrivateKey(keyBytes, password)
+}
+
+// GeneratePrivateKey generates a new private key and returns a *PrivateKey and an
+// error if encountered. The algorithm is KeyAlgorithmRSA, KeyAlgorithmECDSA or
+// KeyAlgorithmEd25519. Size is the size of RSA keys in bits (default 2048, at
+// least 2048) and curve the curve of ECDSA keys: P-256 (default), P-384 or P-521.
+func GeneratePrivateKey(algorithm string, size int, curve string) (*PrivateKey, error) {
+	var parsedKey interface{}
+	var err error
+	switch algorithm {
+	case KeyAlgorithmRSA:
+		if size == 0 {
+			size = 2048
+		}
+		if size < 2048 {
+			return nil, fmt.Errorf("RSA keys must have at least 2048 bits (%d)", size)
+		}
+		parsedKey, err = rsa.GenerateKey(rand.Reader, size)
+	case KeyAlgorithmECDSA:
+		var ecCurve elliptic.Curve
+		switch curve {
+		case "", "P-256":
+			ecCurve = elliptic.P256()
+		case "P-384":
+			ecCurve = elliptic.P384()
+		case "P-521":
+			ecCurve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve (%s)", curve)
+		}
+		parsedKey, err = ecdsa.GenerateKey(ecCurve, rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, parsedKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm (%s)", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(parsedKey, "")
+}
+
+// VerifyCertAndPrivateKey verifies that a certificate (*x509.Certificate) and a key
+// (*PrivateKey) match. It returns nil on a match and a *KeyMismatchError with the
+// reason otherwise.
+func VerifyCertAndPrivateKey(cert *x509.Certificate, key *PrivateKey) error {
+	if cert == nil {
+		return errors.New("no certificate found")
+	}
+	return verifyPublicKeyAndPrivateKey(cert.PublicKey, key)
+}
+
+// Description returns a short description of the key, e.g. "RSA 2048 bits" or
+// "ECDSA P-256".
+func (key *PrivateKey) Description() string {
+	switch key.Algorithm {
+	case KeyAlgorithmRSA:
+		return key.Algorithm + " " + strconv.Itoa(key.Size) + " bits"
+	case KeyAlgorithmECDSA:
+		return key.Algorithm + " " + key.Curve
+	default:
+		return key.Algorithm
+	}
+}
+
+// PEMBlock returns the key as an unencrypted PKCS8 *pem.Block, as used by
+// the *pem.Block based functions, and an error if encountered.
+func (key *PrivateKey) PEMBlock() (*pem.Block, error) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key.Signer)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}, nil
+}
+
+// decodePrivateKeyDER parses a DER encoded PKCS1, SEC1, PKCS8 or encrypted PKCS8
+// private key. It returns errNotDERKey if the data is none of those.
+func decodePrivateKeyDER(keyBytes []byte, password string) (*PrivateKey, error) {
+	if parsedKey, err := x509.ParsePKCS1PrivateKey(keyBytes); err == nil {
+		return newPrivateKey(parsedKey, KeyFormatPKCS1)
+	}
+	if parsedKey, err := x509.ParseECPrivateKey(keyBytes); err == nil {
+		return newPrivateKey(parsedKey, KeyFormatSEC1)
+	}
+	if parsedKey, err := x509.ParsePKCS8PrivateKey(keyBytes); err == nil {
+		return newPrivateKey(parsedKey, KeyFormatPKCS8)
+	}
+
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(keyBytes, &info); err != nil || len(rest) > 0 {
+		return nil, errNotDERKey
+	}
+	parsedKey, err := pkcs8.ParsePKCS8PrivateKey(keyBytes, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(parsedKey, KeyFormatEncryptedPKCS8)
+}
+
+// decodePrivateKeyPEMBlock parses the private key of a PEM block. Blocks with
+// a PKCS1 or SEC1 label may contain PKCS8 data (as returned by DecodeKeyBytes).
+func decodePrivateKeyPEMBlock(block *pem.Block, password string) (*PrivateKey, error) {
+	if x509.IsEncryptedPEMBlock(block) {
+		decrypted, err := decryptLegacyPEMBlock(block, password)
+		if err != nil {
+			return nil, err
+		}
+		if decrypted.Type == "RSA PRIVATE KEY" {
+			parsedKey, err := x509.ParsePKCS1PrivateKey(decrypted.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return newPrivateKey(parsedKey, KeyFormatEncryptedPKCS1)
+		}
+		parsedKey, err := x509.ParseECPrivateKey(decrypted.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newPrivateKey(parsedKey, KeyFormatEncryptedSEC1)
+	}
+
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		return decodePrivateKeyOpenSSH(block, password)
+	case "ENCRYPTED PRIVATE KEY":
+		parsedKey, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		return newPrivateKey(parsedKey, KeyFormatEncryptedPKCS8)
+	case "RSA PRIVATE KEY":
+		if parsedKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return newPrivateKey(parsedKey, KeyFormatPKCS1)
+		}
+	case "EC PRIVATE KEY":
+		if parsedKey, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+			return newPrivateKey(parsedKey, KeyFormatSEC1)
+		}
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key (%s)", block.Type)
+	}
+	return newPrivateKey(parsedKey, KeyFormatPKCS8)
+}
+
+// newPrivateKey returns a *PrivateKey for a parsed private key and its format.
+func newPrivateKey(parsedKey interface{}, format string) (*PrivateKey, error) {
+	signer, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unknown signature algorithm of private key")
+	}
+	key, err := newPrivateKeyInfo(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.Signer = signer
+	key.Format = format
+	return key, nil
+}
+
+// newPrivateKeyInfo returns a *PrivateKey with the algorithm, size and curve of
+// a public key, but without a Signer.
+func newPrivateKeyInfo(publicKey crypto.PublicKey) (*PrivateKey, error) {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &PrivateKey{Algorithm: KeyAlgorithmRSA, Size: pub.N.BitLen()}, nil
+	case *ecdsa.PublicKey:
+		params := pub.Curve.Params()
+		return &PrivateKey{Algorithm: KeyAlgorithmECDSA, Size: params.BitSize, Curve: params.Name}, nil
+	case ed25519.PublicKey:
+		return &PrivateKey{Algorithm: KeyAlgorithmEd25519, Size: 256}, nil
+	default:
+		return nil, errors.New("unknown public key algorithm")
+	}
+}
+
+// publicKeysEqual compares two public keys.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	pub, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b)
+}
+
+// verifyPublicKeyAndPrivateKey compares a public key with a private key and
+// returns a *KeyMismatchError with the first difference found.
+func verifyPublicKeyAndPrivateKey(publicKey crypto.PublicKey, key *PrivateKey) error {
+	if key == nil || key.Signer == nil {
+		return errors.New("no key found")
+	}
+
+	publicKeyInfo, err := newPrivateKeyInfo(publicKey)
+	if err != nil {
+		return err
+	}
+	mismatch := &KeyMismatchError{Certificate: publicKeyInfo.Description(), Key: key.Description()}
+	switch {
+	case publicKeyInfo.Algorithm != key.Algorithm:
+		mismatch.Reason = MismatchAlgorithm
+	case publicKeyInfo.Curve != key.Curve:
+		mismatch.Reason = MismatchCurve
+	case publicKeyInfo.Size != key.Size:
+		mismatch.Reason = MismatchSize
+	case !publicKeysEqual(publicKey, key.Signer.Public()):
+		mismatch.Reason = MismatchPublicKey
+	default:
+		return nil
+	}
+	return mismatch
+}
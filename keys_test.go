@@ -0,0 +1,187 @@
+package certmin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePrivateKey(t *testing.T) {
+	tests := []struct {
+		file, password, algorithm, curve, format string
+		size                                     int
+	}{
+		{"t/myserver.key", "", KeyAlgorithmRSA, "", KeyFormatPKCS1, 2048},
+		{"t/myserver_enc.key", testPassword, KeyAlgorithmRSA, "", KeyFormatEncryptedPKCS8, 2048},
+		{"t/myserver.pfx", testPassword, KeyAlgorithmRSA, "", KeyFormatPKCS12, 2048},
+		{"t/ca.key", testPassword, KeyAlgorithmRSA, "", KeyFormatEncryptedPKCS1, 2048},
+		{"t/ecdsa_prime256v1_legacy_enc.key", testPassword, KeyAlgorithmECDSA, "P-256", KeyFormatEncryptedSEC1, 256},
+		{"t/ecdsa_prime256v1.key", "", KeyAlgorithmECDSA, "P-256", KeyFormatPKCS8, 256},
+		{"t/ecdsa_secp384r1_2_enc.key", testPassword, KeyAlgorithmECDSA, "P-384", KeyFormatEncryptedPKCS8, 384},
+		{"t/ed25519.key", "", KeyAlgorithmEd25519, "", KeyFormatPKCS8, 256},
+		{"t/ed25519_2_enc.key", testPassword, KeyAlgorithmEd25519, "", KeyFormatEncryptedPKCS8, 256},
+		{"t/myserver_key.der", "", KeyAlgorithmRSA, "", KeyFormatPKCS1, 2048},
+		{"t/ecdsa_prime256v1_key.der", "", KeyAlgorithmECDSA, "P-256", KeyFormatSEC1, 256},
+		{"t/ecdsa_secp384r1_key_pkcs8.der", "", KeyAlgorithmECDSA, "P-384", KeyFormatPKCS8, 384},
+		{"t/ed25519_key_enc.der", testPassword, KeyAlgorithmEd25519, "", KeyFormatEncryptedPKCS8, 256},
+		{"t/myserver.jks", testPassword, KeyAlgorithmRSA, "", KeyFormatJKS, 2048},
+		{"t/ecdsa_prime256v1.jceks", testPassword, KeyAlgorithmECDSA, "P-256", KeyFormatJCEKS, 256},
+	}
+	for _, test := range tests {
+		keyBytes, err := ioutil.ReadFile(test.file)
+		assert.NoError(t, err)
+		key, err := DecodePrivateKey(keyBytes, test.password)
+		if !assert.NoError(t, err, test.file) {
+			continue
+		}
+		assert.Equal(t, test.algorithm, key.Algorithm, test.file)
+		assert.Equal(t, test.curve, key.Curve, test.file)
+		assert.Equal(t, test.format, key.Format, test.file)
+		assert.Equal(t, test.size, key.Size, test.file)
+
+		// The key can be used for signing
+		digest := sha256.Sum256([]byte("certmin"))
+		opts := crypto.SignerOpts(crypto.SHA256)
+		message := digest[:]
+		if key.Algorithm == KeyAlgorithmEd25519 {
+			opts = crypto.Hash(0)
+			message = []byte("certmin")
+		}
+		_, err = key.Signer.Sign(rand.Reader, message, opts)
+		assert.NoError(t, err, test.file)
+	}
+
+	// PKCS8 data with a PKCS1 label, as returned by DecodeKeyBytes
+	block, err := DecodeKeyFile("t/myserver_enc.key", testPassword)
+	assert.NoError(t, err)
+	key, err := DecodePrivateKey(pem.EncodeToMemory(block), "")
+	assert.NoError(t, err)
+	if assert.NotNil(t, key) {
+		assert.Equal(t, KeyFormatPKCS8, key.Format)
+	}
+
+	// A bundle with a certificate and a key
+	certBytes, err := ioutil.ReadFile("t/myserver.crt")
+	assert.NoError(t, err)
+	keyBytes, err := ioutil.ReadFile("t/myserver.key")
+	assert.NoError(t, err)
+	key, err = DecodePrivateKey(append(certBytes, keyBytes...), "")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+
+	_, err = DecodePrivateKey(certBytes, "")
+	assert.Error(t, err)
+	_, err = DecodePrivateKeyFile("t/myserver_enc.key", "wrong")
+	assert.Error(t, err)
+	_, err = DecodePrivateKeyFile("t/ca2.key", "wrong")
+	assert.Error(t, err)
+	_, err = DecodePrivateKeyFile("t/myserver_key_enc.der", "wrong")
+	assert.Error(t, err)
+	_, err = DecodePrivateKey([]byte("foo"), "")
+	assert.Error(t, err)
+}
+
+func TestDecodePrivateKeyFile(t *testing.T) {
+	key, err := DecodePrivateKeyFile("t/ecdsa_secp384r1.key", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+
+	_, err = DecodePrivateKeyFile("t/doesnotexist.key", "")
+	assert.Error(t, err)
+}
+
+func TestGeneratePrivateKey(t *testing.T) {
+	tests := []struct {
+		algorithm, curve, expectedCurve string
+		size, expectedSize              int
+	}{
+		{KeyAlgorithmRSA, "", "", 0, 2048},
+		{KeyAlgorithmRSA, "", "", 3072, 3072},
+		{KeyAlgorithmECDSA, "", "P-256", 0, 256},
+		{KeyAlgorithmECDSA, "P-384", "P-384", 0, 384},
+		{KeyAlgorithmECDSA, "P-521", "P-521", 0, 521},
+		{KeyAlgorithmEd25519, "", "", 0, 256},
+	}
+	for _, test := range tests {
+		key, err := GeneratePrivateKey(test.algorithm, test.size, test.curve)
+		if !assert.NoError(t, err, test.algorithm) {
+			continue
+		}
+		assert.Equal(t, test.algorithm, key.Algorithm)
+		assert.Equal(t, test.expectedCurve, key.Curve)
+		assert.Equal(t, test.expectedSize, key.Size)
+		block, err := key.PEMBlock()
+		assert.NoError(t, err)
+		parsedKey, err := DecodePrivateKey(pem.EncodeToMemory(block), "")
+		assert.NoError(t, err)
+		assert.Equal(t, key.Signer, parsedKey.Signer)
+	}
+
+	_, err := GeneratePrivateKey(KeyAlgorithmRSA, 1024, "")
+	assert.Error(t, err)
+	_, err = GeneratePrivateKey(KeyAlgorithmECDSA, 0, "P-224")
+	assert.Error(t, err)
+	_, err = GeneratePrivateKey("DSA", 0, "")
+	assert.Error(t, err)
+}
+
+func TestVerifyCertAndPrivateKey(t *testing.T) {
+	tests := []struct {
+		certFile, keyFile, reason string
+	}{
+		{"t/myserver.crt", "t/myserver.key", ""},
+		{"t/ecdsa_prime256v1.crt", "t/ecdsa_prime256v1.key", ""},
+		{"t/ed25519.crt", "t/ed25519.key", ""},
+		{"t/myserver.crt", "t/myserver-fromca2.key", MismatchPublicKey},
+		{"t/myserver.crt", "t/ecdsa_prime256v1.key", MismatchAlgorithm},
+		{"t/ecdsa_secp384r1.crt", "t/ecdsa_prime256v1.key", MismatchCurve},
+		{"t/ecdsa_prime256v1_2.crt", "t/ecdsa_prime256v1.key", MismatchPublicKey},
+		{"t/ed25519_2.crt", "t/ed25519.key", MismatchPublicKey},
+	}
+	for _, test := range tests {
+		certs, err := DecodeCertFile(test.certFile, "")
+		assert.NoError(t, err)
+		key, err := DecodePrivateKeyFile(test.keyFile, "")
+		assert.NoError(t, err)
+		err = VerifyCertAndPrivateKey(certs[0], key)
+		if test.reason == "" {
+			assert.NoError(t, err, test.certFile)
+			continue
+		}
+		var mismatch *KeyMismatchError
+		if assert.True(t, errors.As(err, &mismatch), test.certFile) {
+			assert.Equal(t, test.reason, mismatch.Reason, test.certFile)
+		}
+	}
+
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	key, err := DecodePrivateKeyFile("t/ecdsa_prime256v1.key", "")
+	assert.NoError(t, err)
+	assert.EqualError(t, VerifyCertAndPrivateKey(certs[0], key),
+		"algorithm mismatch (certificate: RSA 2048 bits, key: ECDSA P-256)")
+	assert.Error(t, VerifyCertAndPrivateKey(nil, key))
+	assert.Error(t, VerifyCertAndPrivateKey(certs[0], nil))
+}
+
+func TestPrivateKey_PEMBlock(t *testing.T) {
+	key, err := DecodePrivateKeyFile("t/myserver.key", "")
+	assert.NoError(t, err)
+	block, err := key.PEMBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVATE KEY", block.Type)
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Signer, parsedKey)
+
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	assert.True(t, VerifyCertAndKey(certs[0], block))
+}
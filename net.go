@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -11,6 +12,25 @@ import (
 	"time"
 )
 
+// ConnectionInfo represents the relevant information of a TLS connection to a remote
+// host. Certificates holds the certificates offered by the host (with the first element
+// being the certificate of the server). OCSPResponse is the raw stapled OCSP response
+// (if any), with OCSPResult the validated response or OCSPError the reason why it could
+// not be validated against the offered chain. SCTs holds the raw Signed Certificate
+// Timestamps sent in the TLS extension. Verified states if the chain was verified for
+// the server name derived from the address.
+type ConnectionInfo struct {
+	Certificates       []*x509.Certificate
+	OCSPResponse       []byte
+	OCSPResult         *OCSPResult
+	OCSPError          error
+	SCTs               [][]byte
+	Version            uint16
+	CipherSuite        uint16
+	NegotiatedProtocol string
+	Verified           bool
+}
+
 // RetrieveCertsFromAddr retrieves all the certificates offered by the remote host. As parameters
 // it takes an address string in the form of hostname:port and a time-out duration for the
 // connection. The time-out is used for both the TCP and the SSL connection, with 0 disabling it.
@@ -18,40 +38,80 @@ import (
 // of the server), an error with a warning (e.g. mismatch between the hostname and the CN or DNS alias
 // in the certificate) and an error in case of failure.
 func RetrieveCertsFromAddr(addr string, timeOut time.Duration) ([]*x509.Certificate, error, error) {
-	conn, err := net.DialTimeout("tcp", addr, timeOut)
+	return RetrieveCertsFromAddrStartTLS(addr, StartTLSNone, timeOut)
+}
+
+// RetrieveCertsFromAddrStartTLS retrieves all the certificates offered by the remote host after
+// upgrading a plaintext connection to TLS. As parameters it takes an address string in the form
+// of hostname:port, the protocol used for the upgrade (one of the StartTLS constants, with
+// StartTLSNone doing an immediate TLS handshake) and a time-out duration for the connection.
+// The time-out is used for both the TCP and the SSL connection, with 0 disabling it. The return
+// values are the same as for RetrieveCertsFromAddr.
+func RetrieveCertsFromAddrStartTLS(
+	addr, protocol string, timeOut time.Duration) ([]*x509.Certificate, error, error) {
+	info, warning, err := RetrieveConnectionInfoFromAddr(addr, protocol, timeOut)
 	if err != nil {
-		return nil, nil, err
+		return nil, warning, err
 	}
+	return info.Certificates, warning, nil
+}
 
+// RetrieveConnectionInfoFromAddr retrieves the certificates offered by the remote host, together
+// with the stapled OCSP response, the Signed Certificate Timestamps and the negotiated parameters
+// of the connection. The parameters are the same as for RetrieveCertsFromAddrStartTLS. The
+// return values are a *ConnectionInfo, an error with a warning (e.g. mismatch between the
+// hostname and the CN or DNS alias in the certificate) and an error in case of failure.
+func RetrieveConnectionInfoFromAddr(
+	addr, protocol string, timeOut time.Duration) (*ConnectionInfo, error, error) {
 	var warning error
-	rx := regexp.MustCompile(":\\d+$")
-	tlsConn := tls.Client(conn, &tls.Config{ServerName: rx.ReplaceAllString(addr, "")})
-	err = tlsConn.SetDeadline(time.Now().Add(timeOut))
+	serverName := regexp.MustCompile(":\\d+$").ReplaceAllString(addr, "")
+	tlsConn, err := dialTLS(addr, protocol, newTLSConfig(addr, serverName, protocol, false), timeOut)
 	if err != nil {
-		return nil, nil, err
-	}
-	err = tlsConn.Handshake()
-	if err != nil {
-		tlsConn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
-		err2 := tlsConn.SetDeadline(time.Now().Add(timeOut))
-		if err2 != nil {
-			return nil, nil, err2
+		if !isVerificationError(err) {
+			return nil, nil, err
 		}
-		err2 = tlsConn.Handshake()
+		// Retry without verification, the server still hands us its certificates
+		var err2 error
+		tlsConn, err2 = dialTLS(addr, protocol, newTLSConfig(addr, serverName, protocol, true), timeOut)
 		if err2 != nil {
 			return nil, nil, err2
 		}
 		warning = err
 	}
 	defer tlsConn.Close()
-	defer conn.Close()
 
-	if len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
 		err := errors.New("no certificates found")
 		return nil, warning, err
 	}
 
-	return tlsConn.ConnectionState().PeerCertificates, warning, nil
+	info := ConnectionInfo{
+		Certificates:       state.PeerCertificates,
+		OCSPResponse:       state.OCSPResponse,
+		SCTs:               state.SignedCertificateTimestamps,
+		Version:            state.Version,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		Verified:           warning == nil,
+	}
+	if len(state.OCSPResponse) > 0 {
+		info.OCSPResult, info.OCSPError = ParseOCSPResponse(state.OCSPResponse, SplitCertsAsTree(state.PeerCertificates))
+	}
+
+	return &info, warning, nil
+}
+
+// isVerificationError returns true if the error is caused by the verification
+// of the certificates offered by the server.
+func isVerificationError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var systemRoots x509.SystemRootsError
+	var insecureAlgorithm x509.InsecureAlgorithmError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) ||
+		errors.As(err, &systemRoots) || errors.As(err, &insecureAlgorithm)
 }
 
 // RetrieveChainFromIssuerURLs retrieves the chain for a certificate by following the
@@ -63,19 +123,35 @@ func RetrieveCertsFromAddr(addr string, timeOut time.Duration) ([]*x509.Certific
 func RetrieveChainFromIssuerURLs(cert *x509.Certificate, timeOut time.Duration) ([]*x509.Certificate, error) {
 	var chain []*x509.Certificate
 	var lastErr error
-	recursiveHopCerts(cert, &chain, &lastErr, timeOut)
+	recursiveHopCerts(cert, &chain, &lastErr, timeOut, false)
+	return chain, lastErr
+}
+
+// RetrieveChainWithoutRootFromIssuerURLs retrieves the chain for a certificate
+// like RetrieveChainFromIssuerURLs, but without the root: the Issuing
+// Certificate URLs are not followed for a certificate issued by a root of the
+// OS trust store and retrieved self-signed certificates are left out. The
+// parameters and return values are the same as for RetrieveChainFromIssuerURLs.
+func RetrieveChainWithoutRootFromIssuerURLs(cert *x509.Certificate, timeOut time.Duration) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	var lastErr error
+	recursiveHopCerts(cert, &chain, &lastErr, timeOut, true)
 	return chain, lastErr
 }
 
-// recursiveHopCerts follows the URL links recursively
-func recursiveHopCerts(
-	cert *x509.Certificate, chain *[]*x509.Certificate, lastErr *error, timeOut time.Duration) *x509.Certificate {
+// recursiveHopCerts follows the URL links recursively, stopping before the root
+// if requested.
+func recursiveHopCerts(cert *x509.Certificate, chain *[]*x509.Certificate, lastErr *error,
+	timeOut time.Duration, withoutRoot bool) *x509.Certificate {
 	if cert == nil {
 		return nil
 	}
 
 	client := http.Client{Timeout: timeOut}
 	*chain = append(*chain, cert)
+	if withoutRoot && len(systemIssuers(cert)) > 0 {
+		return nil
+	}
 	for _, url := range cert.IssuingCertificateURL {
 		resp, err := client.Get(url)
 		if err != nil {
@@ -97,8 +173,67 @@ func recursiveHopCerts(
 		}
 
 		*lastErr = nil
-		return recursiveHopCerts(decodedCerts[0], chain, lastErr, timeOut)
+		if withoutRoot && isSelfSigned(decodedCerts[0]) {
+			return nil
+		}
+		return recursiveHopCerts(decodedCerts[0], chain, lastErr, timeOut, withoutRoot)
 	}
 
 	return nil
 }
+
+// newTLSConfig returns the *tls.Config used to connect to a server. The HTTP
+// protocols are only offered with ALPN for an immediate TLS handshake on the
+// HTTPS port, as servers of other protocols may reject them.
+func newTLSConfig(addr, serverName, protocol string, insecure bool) *tls.Config {
+	config := tls.Config{ServerName: serverName, InsecureSkipVerify: insecure}
+	if _, port, err := net.SplitHostPort(addr); err == nil && port == "443" && protocol == StartTLSNone {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return &config
+}
+
+// dialTLS connects to addr, runs the plaintext negotiation of the given protocol
+// and returns the connection after a completed TLS handshake.
+func dialTLS(addr, protocol string, config *tls.Config, timeOut time.Duration) (*tls.Conn, error) {
+	negotiate, ok := startTLSFuncs[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unsupported StartTLS protocol (%s)", protocol)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeOut)
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline time.Time
+	if timeOut != 0 {
+		deadline = time.Now().Add(timeOut)
+	}
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if negotiate != nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		err = negotiate(conn, host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
@@ -1,12 +1,18 @@
 package certmin
 
 import (
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
 )
 
 func TestRetrieveCertsFromAddr(t *testing.T) {
@@ -33,6 +39,93 @@ func TestRetrieveCertsFromAddr(t *testing.T) {
 	}
 }
 
+func TestRetrieveConnectionInfoFromAddr(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	leaf, leafKey := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "certmin test server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca, caKey)
+	staple, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}, caKey)
+	assert.NoError(t, err)
+
+	addr := startFakeTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate:                 [][]byte{leaf.Raw, ca.Raw},
+			PrivateKey:                  leafKey,
+			OCSPStaple:                  staple,
+			SignedCertificateTimestamps: [][]byte{[]byte("sct1"), []byte("sct2")},
+		}},
+		NextProtos: []string{"h2"},
+	}, nil)
+
+	info, warn, err := RetrieveConnectionInfoFromAddr(addr, StartTLSNone, 2*time.Second)
+	assert.NoError(t, err)
+	assert.Error(t, warn) // unknown authority
+	if assert.NotNil(t, info) {
+		assert.Equal(t, []*x509.Certificate{leaf, ca}, info.Certificates)
+		assert.False(t, info.Verified)
+		assert.Equal(t, staple, info.OCSPResponse)
+		assert.NoError(t, info.OCSPError)
+		if assert.NotNil(t, info.OCSPResult) {
+			assert.Equal(t, OCSPGood, info.OCSPResult.Status)
+		}
+		assert.Equal(t, [][]byte{[]byte("sct1"), []byte("sct2")}, info.SCTs)
+		assert.Empty(t, info.NegotiatedProtocol) // not the HTTPS port
+		assert.NotZero(t, info.Version)
+		assert.NotZero(t, info.CipherSuite)
+	}
+
+	// Without stapling and ALPN
+	addr = startFakeServer(t, nil)
+	info, _, err = RetrieveConnectionInfoFromAddr(addr, StartTLSNone, 2*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, info) {
+		assert.Nil(t, info.OCSPResponse)
+		assert.Nil(t, info.OCSPResult)
+		assert.Empty(t, info.SCTs)
+		assert.Empty(t, info.NegotiatedProtocol)
+	}
+
+	// A server with strict ALPN for another protocol
+	addr = startFakeTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw, ca.Raw}, PrivateKey: leafKey}},
+		NextProtos:   []string{"imap"},
+	}, nil)
+	info, warn, err = RetrieveConnectionInfoFromAddr(addr, StartTLSNone, 2*time.Second)
+	assert.NoError(t, err)
+	assert.Error(t, warn) // unknown authority
+	if assert.NotNil(t, info) {
+		assert.Equal(t, []*x509.Certificate{leaf, ca}, info.Certificates)
+		assert.Empty(t, info.NegotiatedProtocol)
+	}
+
+	info, _, err = RetrieveConnectionInfoFromAddr("faa", StartTLSNone, time.Second)
+	assert.Nil(t, info)
+	assert.Error(t, err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	config := newTLSConfig("example.com:443", "example.com", StartTLSNone, false)
+	assert.Equal(t, "example.com", config.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, config.NextProtos)
+	assert.False(t, config.InsecureSkipVerify)
+
+	config = newTLSConfig("example.com:636", "example.com", StartTLSNone, true)
+	assert.Empty(t, config.NextProtos)
+	assert.True(t, config.InsecureSkipVerify)
+
+	config = newTLSConfig("example.com:443", "example.com", StartTLSSMTP, false)
+	assert.Empty(t, config.NextProtos)
+}
+
 func TestRetrieveChainFromIssuerURLs(t *testing.T) {
 	certs, err := DecodeCertFile("t/myserver.crt", "")
 	assert.NoError(t, err)
@@ -50,14 +143,55 @@ func TestRetrieveChainFromIssuerURLs(t *testing.T) {
 	}
 }
 
+func TestRetrieveChainWithoutRootFromIssuerURLs(t *testing.T) {
+	var root, inter *CA
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/root.crt":
+			w.Write(root.Certificate.Raw)
+		case "/inter.crt":
+			w.Write(inter.Certificate.Raw)
+		}
+	}))
+	defer server.Close()
+
+	root = newTestRootCA(t, "root")
+	key, err := GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	inter, err = root.IssueIntermediateCA(&CertProfile{Subject: CSRTemplate{CommonName: "intermediate"},
+		IssuingCertificateURLs: []string{server.URL + "/root.crt"}}, key)
+	assert.NoError(t, err)
+	key, err = GeneratePrivateKey(KeyAlgorithmECDSA, 0, "")
+	assert.NoError(t, err)
+	tree, err := inter.IssueCert(&CertProfile{Subject: CSRTemplate{CommonName: "leaf"},
+		IssuingCertificateURLs: []string{server.URL + "/inter.crt"}}, key.Signer.Public())
+	assert.NoError(t, err)
+
+	chain, err := RetrieveChainFromIssuerURLs(tree.Certificate, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(chain))
+
+	chain, err = RetrieveChainWithoutRootFromIssuerURLs(tree.Certificate, 5*time.Second)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(chain)) {
+		assert.Equal(t, tree.Certificate, chain[0])
+		assert.Equal(t, inter.Certificate.Raw, chain[1].Raw)
+	}
+
+	// A root given as certificate is kept
+	chain, err = RetrieveChainWithoutRootFromIssuerURLs(root.Certificate, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{root.Certificate}, chain)
+}
+
 func TestRecursiveHopCerts(t *testing.T) {
 	if os.Getenv("AUTHOR_TESTING") != "" {
 		certs, err := DecodeCertFile("t/kuleuven-be.pem", "")
 		assert.NoError(t, err)
 		var chain []*x509.Certificate
 		var lastErr error
-		recursiveHopCerts(certs[0], &chain, &lastErr, 5*time.Second)
-		leftOver := recursiveHopCerts(certs[0], &chain, &lastErr, 5*time.Second)
+		recursiveHopCerts(certs[0], &chain, &lastErr, 5*time.Second, false)
+		leftOver := recursiveHopCerts(certs[0], &chain, &lastErr, 5*time.Second, false)
 		assert.Nil(t, leftOver)
 		assert.True(t, len(chain) >= 2)
 	}
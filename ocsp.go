@@ -0,0 +1,196 @@
+package certmin
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Revocation statuses of an OCSPResult.
+const (
+	OCSPGood    = "good"
+	OCSPRevoked = "revoked"
+	OCSPUnknown = "unknown"
+)
+
+// OCSPResult represents the validated answer of an OCSP responder for a certificate.
+// RevokedAt and RevocationReason are only set when the Status is OCSPRevoked.
+// ResponderCert is the delegated responder certificate, if the response was not
+// signed by the issuer itself. SCTs holds the Signed Certificate Timestamps delivered
+// in the response (if any).
+type OCSPResult struct {
+	Status                 string
+	RevokedAt              time.Time
+	RevocationReason       int
+	ThisUpdate, NextUpdate time.Time
+	Responder              string
+	ResponderCert          *x509.Certificate
+	SCTs                   []*SCT
+}
+
+// CheckOCSP retrieves the revocation status of the Certificate of a CertTree by
+// querying the OCSP servers found in the certificate. The issuer of the certificate
+// must be present in the Intermediates or Roots of the CertTree. As parameters it
+// takes a *CertTree and a time-out duration for the HTTP connection with 0 disabling
+// it. The return values are an *OCSPResult and an error in case of failure. The
+// servers are tried in order until a valid response is received.
+func CheckOCSP(tree *CertTree, timeOut time.Duration) (*OCSPResult, error) {
+	if tree == nil || tree.Certificate == nil {
+		return nil, errors.New("no certificate found")
+	}
+	if len(tree.Certificate.OCSPServer) == 0 {
+		return nil, errors.New("no OCSP servers found in the certificate")
+	}
+	issuer, err := findIssuer(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := ocsp.CreateRequest(tree.Certificate, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: timeOut}
+	var lastErr error
+	for _, server := range tree.Certificate.OCSPServer {
+		resp, err := client.Post(server, "application/ocsp-request", bytes.NewReader(request))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("OCSP server %s returned %s", server, resp.Status)
+			continue
+		}
+
+		result, err := ParseOCSPResponse(respBytes, tree)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		result.Responder = server
+		return result, nil
+	}
+
+	return nil, lastErr
+}
+
+// ParseOCSPResponse parses and validates a DER encoded OCSP response (e.g. retrieved
+// from a responder or stapled to a TLS connection) for the Certificate of a CertTree.
+// The signature of the response must be made by the issuer of the certificate, found
+// in the Intermediates or Roots of the CertTree, or by a responder certificate issued
+// by it for OCSP signing. It returns an *OCSPResult and an error.
+func ParseOCSPResponse(respBytes []byte, tree *CertTree) (*OCSPResult, error) {
+	if tree == nil || tree.Certificate == nil {
+		return nil, errors.New("no certificate found")
+	}
+	issuer, err := findIssuer(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(respBytes, tree.Certificate, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	result := OCSPResult{
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+	}
+
+	if resp.Certificate != nil && !bytes.Equal(resp.Certificate.Raw, issuer.Raw) {
+		if !hasExtKeyUsage(resp.Certificate, x509.ExtKeyUsageOCSPSigning) {
+			return nil, errors.New("the OCSP responder certificate is not authorized for OCSP signing")
+		}
+		now := time.Now()
+		if now.Before(resp.Certificate.NotBefore) || now.After(resp.Certificate.NotAfter) {
+			return nil, errors.New("the OCSP responder certificate has expired or is not yet valid")
+		}
+		result.ResponderCert = resp.Certificate
+	}
+
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return nil, errors.New("the OCSP response is outdated")
+	}
+
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidExtensionOCSPSCTList) {
+			// A malformed SCT list does not invalidate the revocation status
+			result.SCTs, _ = parseSCTListExtension(ext.Value, SCTSourceOCSP)
+		}
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		result.Status = OCSPGood
+	case ocsp.Revoked:
+		result.Status = OCSPRevoked
+		result.RevokedAt = resp.RevokedAt
+		result.RevocationReason = resp.RevocationReason
+	default:
+		result.Status = OCSPUnknown
+	}
+
+	return &result, nil
+}
+
+// RevocationReason returns the name of a revocation reason code as used
+// in OCSP responses and CRLs (RFC 5280).
+func RevocationReason(reason int) string {
+	reasons := map[int]string{
+		ocsp.Unspecified:          "unspecified",
+		ocsp.KeyCompromise:        "key compromise",
+		ocsp.CACompromise:         "CA compromise",
+		ocsp.AffiliationChanged:   "affiliation changed",
+		ocsp.Superseded:           "superseded",
+		ocsp.CessationOfOperation: "cessation of operation",
+		ocsp.CertificateHold:      "certificate hold",
+		ocsp.RemoveFromCRL:        "remove from CRL",
+		ocsp.PrivilegeWithdrawn:   "privilege withdrawn",
+		ocsp.AACompromise:         "AA compromise",
+	}
+	if name, ok := reasons[reason]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown reason (%d)", reason)
+}
+
+// findIssuer returns the certificate of the Intermediates or Roots of a
+// CertTree that signed the Certificate.
+func findIssuer(tree *CertTree) (*x509.Certificate, error) {
+	candidates := append([]*x509.Certificate{}, tree.Intermediates...)
+	candidates = append(candidates, tree.Roots...)
+	for _, candidate := range candidates {
+		if tree.Certificate.CheckSignatureFrom(candidate) == nil {
+			return candidate, nil
+		}
+	}
+	if IsRootCA(tree.Certificate) && tree.Certificate.CheckSignatureFrom(tree.Certificate) == nil {
+		return tree.Certificate, nil
+	}
+	return nil, errors.New("the issuer of the certificate was not found")
+}
+
+// hasExtKeyUsage returns true if the certificate has the given extended key usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, certUsage := range cert.ExtKeyUsage {
+		if certUsage == usage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package certmin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newTestCert creates a certificate from a template with a new ECDSA key, signed by
+// parent and parentKey or self-signed when parent is nil. Unset validity fields are
+// filled in with a period valid now.
+func newTestCert(t *testing.T, template *x509.Certificate,
+	parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.SerialNumber == nil {
+		template.SerialNumber, _ = rand.Int(rand.Reader, big.NewInt(1<<62))
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	if template.IsCA {
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newTestOCSPServer starts an OCSP responder answering with the given status,
+// signed by responderCert and responderKey on behalf of issuer.
+func newTestOCSPServer(t *testing.T, issuer, responderCert *x509.Certificate,
+	responderKey crypto.Signer, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		template := ocsp.Response{
+			Status:       status,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if status == ocsp.Revoked {
+			template.RevokedAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+			template.RevocationReason = ocsp.KeyCompromise
+		}
+		if responderCert != issuer {
+			template.Certificate = responderCert
+		}
+		resp, err := ocsp.CreateResponse(issuer, responderCert, template, responderKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckOCSP(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test CA"},
+		IsCA:    true,
+	}, nil, nil)
+	responder, responderKey := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "certmin test OCSP responder"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, ca, caKey)
+	notResponder, notResponderKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin test server"},
+	}, ca, caKey)
+	other, otherKey := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "certmin other CA"},
+		IsCA:    true,
+	}, nil, nil)
+
+	newLeaf := func(server *httptest.Server) *CertTree {
+		leaf, _ := newTestCert(t, &x509.Certificate{
+			Subject:    pkix.Name{CommonName: "certmin test server"},
+			OCSPServer: []string{server.URL},
+		}, ca, caKey)
+		return &CertTree{Certificate: leaf, Roots: []*x509.Certificate{ca}}
+	}
+
+	// Signed by the issuer
+	tree := newLeaf(newTestOCSPServer(t, ca, ca, caKey, ocsp.Good))
+	result, err := CheckOCSP(tree, 2*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, OCSPGood, result.Status)
+		assert.Equal(t, tree.Certificate.OCSPServer[0], result.Responder)
+		assert.Nil(t, result.ResponderCert)
+	}
+
+	// Signed by a delegated responder
+	tree = newLeaf(newTestOCSPServer(t, ca, responder, responderKey, ocsp.Revoked))
+	result, err = CheckOCSP(tree, 2*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, OCSPRevoked, result.Status)
+		assert.Equal(t, ocsp.KeyCompromise, result.RevocationReason)
+		assert.False(t, result.RevokedAt.IsZero())
+		assert.Equal(t, responder, result.ResponderCert)
+	}
+
+	tree = newLeaf(newTestOCSPServer(t, ca, ca, caKey, ocsp.Unknown))
+	result, err = CheckOCSP(tree, 2*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, OCSPUnknown, result.Status)
+	}
+
+	// Delegated certificate without the OCSP signing usage
+	tree = newLeaf(newTestOCSPServer(t, ca, notResponder, notResponderKey, ocsp.Good))
+	result, err = CheckOCSP(tree, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+
+	// Signed by another CA
+	tree = newLeaf(newTestOCSPServer(t, ca, other, otherKey, ocsp.Good))
+	result, err = CheckOCSP(tree, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+
+	// No issuer
+	tree.Roots = nil
+	result, err = CheckOCSP(tree, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+
+	// No OCSP server
+	result, err = CheckOCSP(&CertTree{Certificate: ca}, 2*time.Second)
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestRevocationReason(t *testing.T) {
+	assert.Equal(t, "key compromise", RevocationReason(ocsp.KeyCompromise))
+	assert.Equal(t, "unknown reason (7)", RevocationReason(7))
+}
+
+func TestFindIssuer(t *testing.T) {
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	ca, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	ca2, err := DecodeCertFile("t/ca2.crt", "")
+	assert.NoError(t, err)
+
+	issuer, err := findIssuer(&CertTree{Certificate: certs[0], Roots: append(ca2, ca...)})
+	assert.NoError(t, err)
+	assert.Equal(t, ca[0], issuer)
+
+	_, err = findIssuer(&CertTree{Certificate: certs[0], Roots: ca2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,354 @@
+package certmin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Parameters of the AES (PBES2) PKCS12 encoding.
+const (
+	pkcs12Iterations = 2048
+	pkcs12SaltSize   = 16
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidPKCS8ShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509Certificate  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256           = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC                = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256                   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// ASN.1 structures of RFC 7292 (PKCS12) and RFC 8018 (PKCS5).
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// encodePKCS12AES creates a PKCS12 file like OpenSSL 3 does by default: the
+// certificates and the key are encrypted with AES-256-CBC using a
+// PBKDF2-HMAC-SHA256 derived key and the file is authenticated with a
+// HMAC-SHA256.
+func encodePKCS12AES(key interface{}, cert *x509.Certificate, caCerts []*x509.Certificate,
+	password string) ([]byte, error) {
+	var certBags []safeBag
+	for idx, caCert := range append([]*x509.Certificate{cert}, caCerts...) {
+		bagBytes, err := asn1.Marshal(certBag{ID: oidCertTypeX509Certificate, Data: caCert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: contextValue(bagBytes)}
+		if idx == 0 {
+			attr, err := localKeyIDAttribute(cert)
+			if err != nil {
+				return nil, err
+			}
+			bag.Attributes = []pkcs12Attribute{attr}
+		}
+		certBags = append(certBags, bag)
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, encrypted, err := encryptPBES2(keyBytes, password)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: algorithm,
+		EncryptedData:       encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	attr, err := localKeyIDAttribute(cert)
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]safeBag{{
+		ID:         oidPKCS8ShroudedKeyBag,
+		Value:      contextValue(shrouded),
+		Attributes: []pkcs12Attribute{attr},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := asn1.Marshal(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, encrypted, err = encryptPBES2(certContents, password)
+	if err != nil {
+		return nil, err
+	}
+	encryptedBytes, err := asn1.Marshal(encryptedData{
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: algorithm,
+			EncryptedContent:           encrypted,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSafe := []contentInfo{
+		{ContentType: oidEncryptedDataContentType, Content: contextValue(encryptedBytes)},
+		{ContentType: oidDataContentType, Content: contextValue(keyData)},
+	}
+
+	authSafeBytes, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+	authSafeData, err := asn1.Marshal(authSafeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pfx := pfxPdu{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: contextValue(authSafeData)},
+		MacData: macData{
+			Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+			MacSalt:    make([]byte, pkcs12SaltSize),
+			Iterations: pkcs12Iterations,
+		},
+	}
+	if _, err = rand.Read(pfx.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	bmpPassword, err := bmpStringZeroTerminated(password)
+	if err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(bmpPassword, pfx.MacData.MacSalt, pkcs12Iterations, 3, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafeBytes)
+	pfx.MacData.Mac.Digest = mac.Sum(nil)
+
+	return asn1.Marshal(pfx)
+}
+
+// bmpStringZeroTerminated returns a password as a zero terminated BMPString, as
+// needed for the PKCS12 key derivation.
+func bmpStringZeroTerminated(password string) ([]byte, error) {
+	var bmp []byte
+	for _, r := range password {
+		if r > 0xFFFF || utf16.IsSurrogate(r) {
+			return nil, errors.New("password contains characters not supported by PKCS12")
+		}
+		bmp = append(bmp, byte(r>>8), byte(r))
+	}
+	return append(bmp, 0, 0), nil
+}
+
+// contextValue wraps DER encoded bytes in an explicit [0] tag.
+func contextValue(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// encryptPBES2 encrypts data with AES-256-CBC and a key derived from the
+// password with PBKDF2-HMAC-SHA256. It returns the algorithm identifier and the
+// encrypted data.
+func encryptPBES2(data []byte, password string) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, pkcs12SaltSize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs12Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), salt, pkcs12Iterations, 32, sha256.New))
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	encrypted := make([]byte, len(data)+padding)
+	copy(encrypted, data)
+	for idx := len(data); idx < len(encrypted); idx++ {
+		encrypted[idx] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return algorithm, encrypted, nil
+}
+
+// localKeyIDAttribute returns the attribute linking the certificate and key
+// bags, being the SHA-1 fingerprint of the certificate.
+func localKeyIDAttribute(cert *x509.Certificate) (pkcs12Attribute, error) {
+	fingerprint := sha1.Sum(cert.Raw)
+	value, err := asn1.Marshal(fingerprint[:])
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+	}, nil
+}
+
+// pkcs12KDF derives size bytes from a BMPString password as described in
+// RFC 7292, Appendix B.2, using SHA-256. ID 3 is used for MAC keys.
+func pkcs12KDF(password, salt []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha256.Size, 64
+
+	D := make([]byte, v)
+	for idx := range D {
+		D[idx] = id
+	}
+	S := fillBlocks(salt, v)
+	P := fillBlocks(password, v)
+	I := append(S, P...)
+
+	c := (size + u - 1) / u
+	A := make([]byte, c*u)
+	one := big.NewInt(1)
+	for i := 0; i < c; i++ {
+		digest := sha256.Sum256(append(D, I...))
+		Ai := digest[:]
+		for j := 1; j < iterations; j++ {
+			digest = sha256.Sum256(Ai)
+			Ai = digest[:]
+		}
+		copy(A[i*u:], Ai)
+
+		if i < c-1 {
+			B := new(big.Int).SetBytes(fillBlocks(Ai, v)[:v])
+			B.Add(B, one)
+			for j := 0; j < len(I)/v; j++ {
+				Ij := new(big.Int).SetBytes(I[j*v : (j+1)*v])
+				Ij.Add(Ij, B)
+				IjBytes := Ij.Bytes()
+				if len(IjBytes) > v {
+					IjBytes = IjBytes[len(IjBytes)-v:]
+				}
+				block := make([]byte, v)
+				copy(block[v-len(IjBytes):], IjBytes)
+				copy(I[j*v:], block)
+			}
+		}
+	}
+	return A[:size]
+}
+
+// fillBlocks concatenates copies of data to a multiple of v bytes, as needed by
+// pkcs12KDF. Empty data results in an empty []byte.
+func fillBlocks(data []byte, v int) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	size := v * ((len(data) + v - 1) / v)
+	filled := make([]byte, size)
+	for idx := range filled {
+		filled[idx] = data[idx%len(data)]
+	}
+	return filled
+}
@@ -0,0 +1,44 @@
+package certmin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestBmpStringZeroTerminated(t *testing.T) {
+	bmp, err := bmpStringZeroTerminated("ab€")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 'a', 0, 'b', 0x20, 0xAC, 0, 0}, bmp)
+
+	bmp, err = bmpStringZeroTerminated("")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0}, bmp)
+
+	_, err = bmpStringZeroTerminated("😀")
+	assert.Error(t, err)
+}
+
+func TestEncodePKCS12AES(t *testing.T) {
+	certs, err := DecodeCertFile("t/ecdsa_secp384r1.crt", "")
+	assert.NoError(t, err)
+	block, err := DecodeKeyFile("t/ecdsa_secp384r1.key", "")
+	assert.NoError(t, err)
+	key, err := parseKeyBlock(block)
+	assert.NoError(t, err)
+
+	pfx, err := encodePKCS12AES(key, certs[0], nil, "")
+	assert.NoError(t, err)
+	decodedKey, cert, caCerts, err := pkcs12.DecodeChain(pfx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, key, decodedKey)
+	assert.Equal(t, certs[0].Raw, cert.Raw)
+	assert.Empty(t, caCerts)
+}
+
+func TestFillBlocks(t *testing.T) {
+	assert.Nil(t, fillBlocks(nil, 64))
+	assert.Equal(t, []byte{1, 2, 3, 1}, fillBlocks([]byte{1, 2, 3}, 4))
+	assert.Equal(t, 128, len(fillBlocks(make([]byte, 65), 64)))
+}
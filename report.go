@@ -0,0 +1,583 @@
+package certmin
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The report types below are serializable representations of the information
+// certmin retrieves, with a stable schema for JSON and YAML encoding. Fields
+// that are not relevant for a report are omitted.
+
+// LocationReport represents the information retrieved for a certificate or
+// CRL location. Which fields are set depends on the action. When skimming,
+// these are Certificates, KeyStore, SSHKeys, CSR and CRL. When verifying a
+// chain, these are Verification, OCSP, CRLStatus and CTPolicy. KeyMatch is set
+// when verifying a key, Diagnosis when diagnosing a served chain and
+// ChainPaths when the possible chain paths are requested. Files lists the
+// written certificate files (if any) and Error the reason of a failure.
+type LocationReport struct {
+	Location     string              `json:"location" yaml:"location"`
+	Warning      string              `json:"warning,omitempty" yaml:"warning,omitempty"`
+	Error        string              `json:"error,omitempty" yaml:"error,omitempty"`
+	Connection   *ConnectionReport   `json:"connection,omitempty" yaml:"connection,omitempty"`
+	Certificates []*CertReport       `json:"certificates,omitempty" yaml:"certificates,omitempty"`
+	KeyStore     *KeyStoreReport     `json:"keystore,omitempty" yaml:"keystore,omitempty"`
+	SSHKeys      []*SSHKeyReport     `json:"ssh_keys,omitempty" yaml:"ssh_keys,omitempty"`
+	CSR          *CSRReport          `json:"csr,omitempty" yaml:"csr,omitempty"`
+	CRL          *CRLReport          `json:"crl,omitempty" yaml:"crl,omitempty"`
+	Verification *VerificationReport `json:"verification,omitempty" yaml:"verification,omitempty"`
+	ChainPaths   []*ChainPathReport  `json:"chain_paths,omitempty" yaml:"chain_paths,omitempty"`
+	OCSP         *RevocationReport   `json:"ocsp,omitempty" yaml:"ocsp,omitempty"`
+	CRLStatus    *RevocationReport   `json:"crl_status,omitempty" yaml:"crl_status,omitempty"`
+	CTPolicy     *CTPolicyReport     `json:"ct_policy,omitempty" yaml:"ct_policy,omitempty"`
+	KeyMatch     *KeyMatchReport     `json:"key_match,omitempty" yaml:"key_match,omitempty"`
+	Diagnosis    *DiagnosisReport    `json:"diagnosis,omitempty" yaml:"diagnosis,omitempty"`
+	Files        []string            `json:"files,omitempty" yaml:"files,omitempty"`
+}
+
+// CertReport represents a certificate. Fingerprints are the hex encoded hashes of
+// the DER encoded certificate and PEM the certificate as PKCS1 PEM.
+type CertReport struct {
+	Subject                     string            `json:"subject" yaml:"subject"`
+	Issuer                      string            `json:"issuer" yaml:"issuer"`
+	IssuerCertificateURLs       []string          `json:"issuer_certificate_urls,omitempty" yaml:"issuer_certificate_urls,omitempty"`
+	DNSNames                    []string          `json:"dns_names,omitempty" yaml:"dns_names,omitempty"`
+	EmailAddresses              []string          `json:"email_addresses,omitempty" yaml:"email_addresses,omitempty"`
+	IPAddresses                 []string          `json:"ip_addresses,omitempty" yaml:"ip_addresses,omitempty"`
+	URIs                        []string          `json:"uris,omitempty" yaml:"uris,omitempty"`
+	SerialNumber                string            `json:"serial_number" yaml:"serial_number"`
+	Version                     int               `json:"version" yaml:"version"`
+	IsCA                        bool              `json:"is_ca" yaml:"is_ca"`
+	MaxPathLen                  int               `json:"max_path_len,omitempty" yaml:"max_path_len,omitempty"`
+	MaxPathLenZero              bool              `json:"max_path_len_zero,omitempty" yaml:"max_path_len_zero,omitempty"`
+	PublicKeyAlgorithm          string            `json:"public_key_algorithm" yaml:"public_key_algorithm"`
+	SignatureAlgorithm          string            `json:"signature_algorithm" yaml:"signature_algorithm"`
+	PermittedDNSDomainsCritical bool              `json:"permitted_dns_domains_critical,omitempty" yaml:"permitted_dns_domains_critical,omitempty"`
+	PermittedDNSDomains         []string          `json:"permitted_dns_domains,omitempty" yaml:"permitted_dns_domains,omitempty"`
+	ExcludedDNSDomains          []string          `json:"excluded_dns_domains,omitempty" yaml:"excluded_dns_domains,omitempty"`
+	PermittedURIDomains         []string          `json:"permitted_uri_domains,omitempty" yaml:"permitted_uri_domains,omitempty"`
+	ExcludedURIDomains          []string          `json:"excluded_uri_domains,omitempty" yaml:"excluded_uri_domains,omitempty"`
+	PermittedEmailAddresses     []string          `json:"permitted_email_addresses,omitempty" yaml:"permitted_email_addresses,omitempty"`
+	ExcludedEmailAddresses      []string          `json:"excluded_email_addresses,omitempty" yaml:"excluded_email_addresses,omitempty"`
+	PermittedIPRanges           []string          `json:"permitted_ip_ranges,omitempty" yaml:"permitted_ip_ranges,omitempty"`
+	ExcludedIPRanges            []string          `json:"excluded_ip_ranges,omitempty" yaml:"excluded_ip_ranges,omitempty"`
+	OCSPServers                 []string          `json:"ocsp_servers,omitempty" yaml:"ocsp_servers,omitempty"`
+	CRLLocations                []string          `json:"crl_locations,omitempty" yaml:"crl_locations,omitempty"`
+	NotBefore                   time.Time         `json:"not_before" yaml:"not_before"`
+	NotAfter                    time.Time         `json:"not_after" yaml:"not_after"`
+	Fingerprints                FingerprintReport `json:"fingerprints" yaml:"fingerprints"`
+	SCTs                        []*SCTReport      `json:"scts,omitempty" yaml:"scts,omitempty"`
+	PEM                         string            `json:"pem" yaml:"pem"`
+}
+
+// FingerprintReport represents the fingerprints of a certificate as colon
+// separated hexadecimal strings.
+type FingerprintReport struct {
+	SHA1   string `json:"sha1" yaml:"sha1"`
+	SHA256 string `json:"sha256" yaml:"sha256"`
+}
+
+// ConnectionReport represents a ConnectionInfo. OCSPStaple is the status of the
+// stapled OCSP response ("none", "invalid" or one of the OCSP constants).
+type ConnectionReport struct {
+	TLSVersion         string `json:"tls_version" yaml:"tls_version"`
+	CipherSuite        string `json:"cipher_suite" yaml:"cipher_suite"`
+	NegotiatedProtocol string `json:"negotiated_protocol,omitempty" yaml:"negotiated_protocol,omitempty"`
+	Verified           bool   `json:"verified" yaml:"verified"`
+	OCSPStaple         string `json:"ocsp_staple" yaml:"ocsp_staple"`
+	SCTs               int    `json:"scts" yaml:"scts"`
+}
+
+// CRLReport represents a CRL. DeltaBaseNumber is the number of the base CRL if
+// the CRL is a delta CRL.
+type CRLReport struct {
+	Issuer          string     `json:"issuer" yaml:"issuer"`
+	ThisUpdate      time.Time  `json:"this_update" yaml:"this_update"`
+	NextUpdate      *time.Time `json:"next_update,omitempty" yaml:"next_update,omitempty"`
+	Number          string     `json:"number,omitempty" yaml:"number,omitempty"`
+	DeltaBaseNumber string     `json:"delta_base_number,omitempty" yaml:"delta_base_number,omitempty"`
+	Entries         int        `json:"entries" yaml:"entries"`
+}
+
+// VerificationReport represents a VerificationResult. FailedCertificate is the
+// subject of the offending certificate (if known).
+type VerificationReport struct {
+	Verified          bool          `json:"verified" yaml:"verified"`
+	Failure           string        `json:"failure,omitempty" yaml:"failure,omitempty"`
+	Error             string        `json:"error,omitempty" yaml:"error,omitempty"`
+	FailedCertificate string        `json:"failed_certificate,omitempty" yaml:"failed_certificate,omitempty"`
+	Paths             []*PathReport `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+// PathReport represents a verified path from the certificate to a root.
+type PathReport struct {
+	Certificates []*PathCertReport `json:"certificates" yaml:"certificates"`
+}
+
+// ChainPathReport represents a ChainPath. Root is the source of the root the
+// path terminates in (empty if none) and Expired lists the subjects of the
+// expired certificates of the path.
+type ChainPathReport struct {
+	Root         string            `json:"root,omitempty" yaml:"root,omitempty"`
+	Expired      []string          `json:"expired,omitempty" yaml:"expired,omitempty"`
+	Certificates []*PathCertReport `json:"certificates" yaml:"certificates"`
+}
+
+// PathCertReport represents a certificate of a verified path with its source
+// (one of the Source constants).
+type PathCertReport struct {
+	Subject string `json:"subject" yaml:"subject"`
+	Source  string `json:"source" yaml:"source"`
+}
+
+// RevocationReport represents the revocation status of a certificate according
+// to OCSP or a CRL. Status is one of the OCSP constants, with the same values
+// used for CRLs. Responder is the OCSP responder or the CRL location.
+type RevocationReport struct {
+	Status     string     `json:"status,omitempty" yaml:"status,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" yaml:"revoked_at,omitempty"`
+	Reason     string     `json:"reason,omitempty" yaml:"reason,omitempty"`
+	ThisUpdate *time.Time `json:"this_update,omitempty" yaml:"this_update,omitempty"`
+	NextUpdate *time.Time `json:"next_update,omitempty" yaml:"next_update,omitempty"`
+	Responder  string     `json:"responder,omitempty" yaml:"responder,omitempty"`
+	Error      string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// SCTReport represents a verified SCT. LogID is base64 encoded, while Log and
+// Operator are only set if the log was found in the log list.
+type SCTReport struct {
+	LogID     string    `json:"log_id" yaml:"log_id"`
+	Log       string    `json:"log,omitempty" yaml:"log,omitempty"`
+	Operator  string    `json:"operator,omitempty" yaml:"operator,omitempty"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	Source    string    `json:"source" yaml:"source"`
+	Valid     bool      `json:"valid" yaml:"valid"`
+	Error     string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// CTPolicyReport represents the check of a CT policy requiring valid SCTs from
+// a number of distinct operators.
+type CTPolicyReport struct {
+	Operators int          `json:"operators" yaml:"operators"`
+	Met       bool         `json:"met" yaml:"met"`
+	Error     string       `json:"error,omitempty" yaml:"error,omitempty"`
+	SCTs      []*SCTReport `json:"scts,omitempty" yaml:"scts,omitempty"`
+}
+
+// KeyMatchReport represents the match of a key with a certificate. Reason
+// explains a mismatch.
+type KeyMatchReport struct {
+	Key         string `json:"key" yaml:"key"`
+	Certificate string `json:"certificate" yaml:"certificate"`
+	Match       bool   `json:"match" yaml:"match"`
+	Reason      string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// CSRReport represents a certificate signing request. PublicKey describes the
+// key (e.g. "RSA 2048 bits"), Extensions are the names of the requested
+// extensions and SignatureError explains an invalid signature.
+type CSRReport struct {
+	Subject            string   `json:"subject" yaml:"subject"`
+	DNSNames           []string `json:"dns_names,omitempty" yaml:"dns_names,omitempty"`
+	EmailAddresses     []string `json:"email_addresses,omitempty" yaml:"email_addresses,omitempty"`
+	IPAddresses        []string `json:"ip_addresses,omitempty" yaml:"ip_addresses,omitempty"`
+	URIs               []string `json:"uris,omitempty" yaml:"uris,omitempty"`
+	PublicKeyAlgorithm string   `json:"public_key_algorithm" yaml:"public_key_algorithm"`
+	PublicKey          string   `json:"public_key" yaml:"public_key"`
+	SignatureAlgorithm string   `json:"signature_algorithm" yaml:"signature_algorithm"`
+	SignatureValid     bool     `json:"signature_valid" yaml:"signature_valid"`
+	SignatureError     string   `json:"signature_error,omitempty" yaml:"signature_error,omitempty"`
+	Extensions         []string `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+}
+
+// DiagnosisReport represents a Diagnosis. Chain lists the subjects of the
+// chain that should be served.
+type DiagnosisReport struct {
+	Chain    []string         `json:"chain" yaml:"chain"`
+	Findings []*FindingReport `json:"findings,omitempty" yaml:"findings,omitempty"`
+}
+
+// FindingReport represents a Finding of a Diagnosis. Certificate is the
+// subject of the certificate concerned (if any).
+type FindingReport struct {
+	Severity    string `json:"severity" yaml:"severity"`
+	Kind        string `json:"kind" yaml:"kind"`
+	Message     string `json:"message" yaml:"message"`
+	Certificate string `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+}
+
+// KeyStoreReport represents the entries of a JKS or JCEKS keystore.
+type KeyStoreReport struct {
+	Type    string                 `json:"type" yaml:"type"`
+	Entries []*KeyStoreEntryReport `json:"entries" yaml:"entries"`
+}
+
+// KeyStoreEntryReport represents an entry of a keystore. Subjects are the
+// subjects of the certificates of the entry.
+type KeyStoreEntryReport struct {
+	Alias    string    `json:"alias" yaml:"alias"`
+	Type     string    `json:"type" yaml:"type"`
+	Created  time.Time `json:"created" yaml:"created"`
+	Subjects []string  `json:"subjects,omitempty" yaml:"subjects,omitempty"`
+}
+
+// SSHKeyReport represents an OpenSSH public key or certificate. Fingerprint is
+// the SHA256 fingerprint of the public key (of the certificate).
+type SSHKeyReport struct {
+	Type        string         `json:"type" yaml:"type"`
+	Fingerprint string         `json:"fingerprint" yaml:"fingerprint"`
+	Comment     string         `json:"comment,omitempty" yaml:"comment,omitempty"`
+	Options     []string       `json:"options,omitempty" yaml:"options,omitempty"`
+	Certificate *SSHCertReport `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+}
+
+// SSHCertReport represents the fields of an OpenSSH certificate. ValidAfter and
+// ValidBefore are omitted when the certificate is valid since always or forever.
+// SigningCA is the SHA256 fingerprint of the key of the CA.
+type SSHCertReport struct {
+	CertType        string            `json:"cert_type" yaml:"cert_type"`
+	KeyID           string            `json:"key_id" yaml:"key_id"`
+	Serial          uint64            `json:"serial" yaml:"serial"`
+	Principals      []string          `json:"principals,omitempty" yaml:"principals,omitempty"`
+	ValidAfter      *time.Time        `json:"valid_after,omitempty" yaml:"valid_after,omitempty"`
+	ValidBefore     *time.Time        `json:"valid_before,omitempty" yaml:"valid_before,omitempty"`
+	CriticalOptions map[string]string `json:"critical_options,omitempty" yaml:"critical_options,omitempty"`
+	Extensions      map[string]string `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	SigningCAType   string            `json:"signing_ca_type" yaml:"signing_ca_type"`
+	SigningCA       string            `json:"signing_ca" yaml:"signing_ca"`
+}
+
+// NewChainPathReport returns a *ChainPathReport for a ChainPath.
+func NewChainPathReport(path *ChainPath) *ChainPathReport {
+	report := ChainPathReport{Root: path.Root}
+	for _, cert := range path.Expired {
+		report.Expired = append(report.Expired, cert.Subject.String())
+	}
+	for idx, cert := range path.Certificates {
+		report.Certificates = append(report.Certificates,
+			&PathCertReport{Subject: cert.Subject.String(), Source: path.Sources[idx]})
+	}
+	return &report
+}
+
+// NewCRLReport returns a *CRLReport for a CRL.
+func NewCRLReport(crl *pkix.CertificateList) *CRLReport {
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	report := CRLReport{
+		Issuer:     issuer.String(),
+		ThisUpdate: crl.TBSCertList.ThisUpdate,
+		NextUpdate: optionalTime(crl.TBSCertList.NextUpdate),
+		Entries:    len(crl.TBSCertList.RevokedCertificates),
+	}
+	if number := CRLNumber(crl); number != nil {
+		report.Number = number.String()
+	}
+	if base := DeltaCRLIndicator(crl); base != nil {
+		report.DeltaBaseNumber = base.String()
+	}
+	return &report
+}
+
+// NewCRLResultReport returns a *RevocationReport for the result of a CRL check
+// or the error if the check failed.
+func NewCRLResultReport(result *CRLResult, err error) *RevocationReport {
+	if err != nil {
+		return &RevocationReport{Error: err.Error()}
+	}
+	report := RevocationReport{
+		Status:     OCSPGood,
+		ThisUpdate: optionalTime(result.ThisUpdate),
+		NextUpdate: optionalTime(result.NextUpdate),
+		Responder:  result.Location,
+	}
+	if result.Revoked {
+		report.Status = OCSPRevoked
+		report.RevokedAt = optionalTime(result.RevokedAt)
+		report.Reason = RevocationReason(result.RevocationReason)
+	}
+	return &report
+}
+
+// NewCertReport returns a *CertReport for a certificate.
+func NewCertReport(cert *x509.Certificate) *CertReport {
+	report := CertReport{
+		Subject:                     cert.Subject.String(),
+		Issuer:                      cert.Issuer.String(),
+		IssuerCertificateURLs:       cert.IssuingCertificateURL,
+		DNSNames:                    cert.DNSNames,
+		EmailAddresses:              cert.EmailAddresses,
+		SerialNumber:                cert.SerialNumber.String(),
+		Version:                     cert.Version,
+		IsCA:                        cert.IsCA,
+		MaxPathLen:                  cert.MaxPathLen,
+		MaxPathLenZero:              cert.MaxPathLenZero,
+		PublicKeyAlgorithm:          cert.PublicKeyAlgorithm.String(),
+		SignatureAlgorithm:          cert.SignatureAlgorithm.String(),
+		PermittedDNSDomainsCritical: cert.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         cert.PermittedDNSDomains,
+		ExcludedDNSDomains:          cert.ExcludedDNSDomains,
+		PermittedURIDomains:         cert.PermittedURIDomains,
+		ExcludedURIDomains:          cert.ExcludedURIDomains,
+		PermittedEmailAddresses:     cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      cert.ExcludedEmailAddresses,
+		OCSPServers:                 cert.OCSPServer,
+		CRLLocations:                cert.CRLDistributionPoints,
+		NotBefore:                   cert.NotBefore,
+		NotAfter:                    cert.NotAfter,
+	}
+	if report.MaxPathLen < 0 {
+		report.MaxPathLen = 0
+	}
+	for _, ip := range cert.IPAddresses {
+		report.IPAddresses = append(report.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		report.URIs = append(report.URIs, uri.String())
+	}
+	for _, ipr := range cert.PermittedIPRanges {
+		report.PermittedIPRanges = append(report.PermittedIPRanges, ipr.String())
+	}
+	for _, ipr := range cert.ExcludedIPRanges {
+		report.ExcludedIPRanges = append(report.ExcludedIPRanges, ipr.String())
+	}
+
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	report.Fingerprints.SHA1 = hexWithColons(sha1Sum[:])
+	report.Fingerprints.SHA256 = hexWithColons(sha256Sum[:])
+
+	pemBytes, _ := EncodeCertAsPKCS1PEM(cert)
+	report.PEM = string(pemBytes)
+
+	return &report
+}
+
+// NewConnectionReport returns a *ConnectionReport for a ConnectionInfo.
+func NewConnectionReport(info *ConnectionInfo) *ConnectionReport {
+	report := ConnectionReport{
+		TLSVersion:         TLSVersionName(info.Version),
+		CipherSuite:        tls.CipherSuiteName(info.CipherSuite),
+		NegotiatedProtocol: info.NegotiatedProtocol,
+		Verified:           info.Verified,
+		OCSPStaple:         "none",
+		SCTs:               len(info.SCTs),
+	}
+	switch {
+	case len(info.OCSPResponse) == 0:
+	case info.OCSPError != nil:
+		report.OCSPStaple = "invalid"
+	default:
+		report.OCSPStaple = info.OCSPResult.Status
+	}
+	return &report
+}
+
+// NewCTPolicyReport returns a *CTPolicyReport for the verified SCTs of a
+// certificate and the required number of distinct operators.
+func NewCTPolicyReport(results []*SCTResult, operators int) *CTPolicyReport {
+	report := CTPolicyReport{Operators: operators, Met: true}
+	if err := CheckCTPolicy(results, operators); err != nil {
+		report.Met = false
+		report.Error = err.Error()
+	}
+	for _, result := range results {
+		report.SCTs = append(report.SCTs, NewSCTReport(result))
+	}
+	return &report
+}
+
+// NewCSRReport returns a *CSRReport for a certificate signing request.
+func NewCSRReport(csr *x509.CertificateRequest) *CSRReport {
+	report := CSRReport{
+		Subject:            csr.Subject.String(),
+		DNSNames:           csr.DNSNames,
+		EmailAddresses:     csr.EmailAddresses,
+		PublicKeyAlgorithm: csr.PublicKeyAlgorithm.String(),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+	}
+	for _, ip := range csr.IPAddresses {
+		report.IPAddresses = append(report.IPAddresses, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		report.URIs = append(report.URIs, uri.String())
+	}
+	if key, err := newPrivateKeyInfo(csr.PublicKey); err == nil {
+		report.PublicKey = key.Description()
+	}
+	if err := VerifyCSR(csr); err != nil {
+		report.SignatureError = err.Error()
+	} else {
+		report.SignatureValid = true
+	}
+	for _, ext := range csr.Extensions {
+		name := ExtensionName(ext.Id)
+		if ext.Critical {
+			name += " (critical)"
+		}
+		report.Extensions = append(report.Extensions, name)
+	}
+	return &report
+}
+
+// NewDiagnosisReport returns a *DiagnosisReport for a Diagnosis.
+func NewDiagnosisReport(diagnosis *Diagnosis) *DiagnosisReport {
+	var report DiagnosisReport
+	for _, cert := range diagnosis.Chain {
+		report.Chain = append(report.Chain, cert.Subject.String())
+	}
+	for _, finding := range diagnosis.Findings {
+		findingReport := FindingReport{Severity: finding.Severity, Kind: finding.Kind, Message: finding.Message}
+		if finding.Certificate != nil {
+			findingReport.Certificate = finding.Certificate.Subject.String()
+		}
+		report.Findings = append(report.Findings, &findingReport)
+	}
+	return &report
+}
+
+// NewKeyStoreReport returns a *KeyStoreReport for a keystore.
+func NewKeyStoreReport(keyStore *KeyStore) *KeyStoreReport {
+	report := KeyStoreReport{Type: keyStore.Type}
+	for _, entry := range keyStore.Entries {
+		entryReport := KeyStoreEntryReport{Alias: entry.Alias, Type: entry.Type, Created: entry.Created}
+		for _, cert := range entry.Certificates {
+			entryReport.Subjects = append(entryReport.Subjects, cert.Subject.String())
+		}
+		report.Entries = append(report.Entries, &entryReport)
+	}
+	return &report
+}
+
+// NewOCSPResultReport returns a *RevocationReport for the result of an OCSP check
+// or the error if the check failed.
+func NewOCSPResultReport(result *OCSPResult, err error) *RevocationReport {
+	if err != nil {
+		return &RevocationReport{Error: err.Error()}
+	}
+	report := RevocationReport{
+		Status:     result.Status,
+		ThisUpdate: optionalTime(result.ThisUpdate),
+		NextUpdate: optionalTime(result.NextUpdate),
+		Responder:  result.Responder,
+	}
+	if result.Status == OCSPRevoked {
+		report.RevokedAt = optionalTime(result.RevokedAt)
+		report.Reason = RevocationReason(result.RevocationReason)
+	}
+	return &report
+}
+
+// NewSCTReport returns a *SCTReport for a verified SCT.
+func NewSCTReport(result *SCTResult) *SCTReport {
+	report := SCTReport{
+		LogID:     base64.StdEncoding.EncodeToString(result.SCT.LogID),
+		Timestamp: result.SCT.Timestamp,
+		Source:    result.SCT.Source,
+		Valid:     result.Valid,
+	}
+	if result.Log != nil {
+		report.Log = result.Log.Description
+		report.Operator = result.Log.Operator
+	}
+	if result.Err != nil {
+		report.Error = result.Err.Error()
+	}
+	return &report
+}
+
+// NewVerificationReport returns a *VerificationReport for a VerificationResult.
+func NewVerificationReport(result *VerificationResult) *VerificationReport {
+	report := VerificationReport{
+		Verified: result.Verified,
+		Failure:  result.Failure,
+	}
+	if result.Err != nil {
+		report.Error = result.Err.Error()
+	}
+	if result.FailedCert != nil {
+		report.FailedCertificate = result.FailedCert.Subject.String()
+	}
+	for _, path := range result.Paths {
+		var pathReport PathReport
+		for idx, cert := range path.Certificates {
+			pathReport.Certificates = append(pathReport.Certificates,
+				&PathCertReport{Subject: cert.Subject.String(), Source: path.Sources[idx]})
+		}
+		report.Paths = append(report.Paths, &pathReport)
+	}
+	return &report
+}
+
+// NewSSHKeyReport returns a *SSHKeyReport for an OpenSSH public key or
+// certificate.
+func NewSSHKeyReport(key *SSHKey) *SSHKeyReport {
+	report := SSHKeyReport{
+		Type:        key.PublicKey.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key.PublicKey),
+		Comment:     key.Comment,
+		Options:     key.Options,
+	}
+	cert := key.Certificate()
+	if cert == nil {
+		return &report
+	}
+
+	report.Fingerprint = ssh.FingerprintSHA256(cert.Key)
+	validAfter, validBefore := SSHCertValidity(cert)
+	report.Certificate = &SSHCertReport{
+		CertType:        SSHCertType(cert),
+		KeyID:           cert.KeyId,
+		Serial:          cert.Serial,
+		Principals:      cert.ValidPrincipals,
+		ValidAfter:      optionalTime(validAfter),
+		ValidBefore:     optionalTime(validBefore),
+		CriticalOptions: cert.CriticalOptions,
+		Extensions:      cert.Extensions,
+		SigningCAType:   cert.SignatureKey.Type(),
+		SigningCA:       ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+	return &report
+}
+
+// TLSVersionName returns the name of a TLS version.
+func TLSVersionName(version uint16) string {
+	names := map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}
+	if name, ok := names[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%04x)", version)
+}
+
+// hexWithColons returns the data as upper case hexadecimal bytes separated by colons.
+func hexWithColons(data []byte) string {
+	hexBytes := make([]string, len(data))
+	for idx, b := range data {
+		hexBytes[idx] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexBytes, ":")
+}
+
+// optionalTime returns a pointer to a time or nil for the zero time, so it can
+// be omitted when encoded.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
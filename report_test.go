@@ -0,0 +1,185 @@
+package certmin
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCertReport(t *testing.T) {
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	report := NewCertReport(certs[0])
+	assert.Equal(t, certs[0].Subject.String(), report.Subject)
+	assert.Equal(t, certs[0].SerialNumber.String(), report.SerialNumber)
+	assert.Regexp(t, "^([0-9A-F]{2}:){19}[0-9A-F]{2}$", report.Fingerprints.SHA1)
+	assert.Regexp(t, "^([0-9A-F]{2}:){31}[0-9A-F]{2}$", report.Fingerprints.SHA256)
+	assert.Contains(t, report.PEM, "-----BEGIN CERTIFICATE-----")
+}
+
+func TestNewChainPathReport(t *testing.T) {
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	roots, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+	tree := CertTree{Certificate: certs[0], Roots: roots}
+
+	paths := FindChainPaths(&tree, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	if assert.Equal(t, 1, len(paths)) {
+		report := NewChainPathReport(paths[0])
+		assert.Equal(t, SourceCertTree, report.Root)
+		assert.Empty(t, report.Expired)
+		if assert.Equal(t, 2, len(report.Certificates)) {
+			assert.Equal(t, roots[0].Subject.String(), report.Certificates[1].Subject)
+			assert.Equal(t, SourceCertTree, report.Certificates[1].Source)
+		}
+	}
+
+	paths = FindChainPaths(&tree, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	if assert.Equal(t, 1, len(paths)) {
+		report := NewChainPathReport(paths[0])
+		assert.Equal(t, []string{certs[0].Subject.String(), roots[0].Subject.String()}, report.Expired)
+	}
+}
+
+func TestNewCRLReport(t *testing.T) {
+	crl, err := DecodeCRLFile("t/ca.crl")
+	assert.NoError(t, err)
+	report := NewCRLReport(crl)
+	assert.Equal(t, "4096", report.Number)
+	assert.Equal(t, 1, report.Entries)
+	assert.Empty(t, report.DeltaBaseNumber)
+	assert.NotNil(t, report.NextUpdate)
+}
+
+func TestNewCSRReport(t *testing.T) {
+	csr, err := DecodeCSRFile("t/myserver.csr")
+	assert.NoError(t, err)
+	report := NewCSRReport(csr)
+	assert.Equal(t, "CN=myserver,O=certmin,C=BE", report.Subject)
+	assert.Equal(t, "RSA 2048 bits", report.PublicKey)
+	assert.Equal(t, []string{"10.0.0.1"}, report.IPAddresses)
+	assert.Equal(t, []string{"https://myserver.example.com"}, report.URIs)
+	assert.True(t, report.SignatureValid)
+	assert.Contains(t, report.Extensions, "Key Usage (critical)")
+	assert.Contains(t, report.Extensions, "Extended Key Usage")
+
+	csr, err = DecodeCSRFile("t/myserver_bad_signature.csr")
+	assert.NoError(t, err)
+	report = NewCSRReport(csr)
+	assert.False(t, report.SignatureValid)
+	assert.NotEmpty(t, report.SignatureError)
+}
+
+func TestNewDiagnosisReport(t *testing.T) {
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	roots, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+
+	diagnosis, err := DiagnoseChain([]*x509.Certificate{roots[0], certs[0]}, 0)
+	assert.NoError(t, err)
+	report := NewDiagnosisReport(diagnosis)
+	assert.Equal(t, []string{"CN=myserver"}, report.Chain)
+	if assert.Equal(t, 2, len(report.Findings)) {
+		assert.Equal(t, SeverityError, report.Findings[0].Severity)
+		assert.Equal(t, FindingLeafNotFirst, report.Findings[0].Kind)
+		assert.Equal(t, "CN=myserver", report.Findings[0].Certificate)
+		assert.Equal(t, FindingRootServed, report.Findings[1].Kind)
+	}
+}
+
+func TestNewKeyStoreReport(t *testing.T) {
+	keyStoreBytes, err := ioutil.ReadFile("t/myserver.jks")
+	assert.NoError(t, err)
+	keyStore, err := DecodeKeyStore(keyStoreBytes, testPassword, "")
+	assert.NoError(t, err)
+	report := NewKeyStoreReport(keyStore)
+	assert.Equal(t, KeyStoreTypeJKS, report.Type)
+	if assert.Equal(t, 2, len(report.Entries)) {
+		assert.Equal(t, "myserver", report.Entries[0].Alias)
+		assert.Equal(t, KeyStoreEntryPrivateKey, report.Entries[0].Type)
+		assert.Equal(t, 2, len(report.Entries[0].Subjects))
+		assert.Equal(t, KeyStoreEntryTrustedCert, report.Entries[1].Type)
+	}
+}
+
+func TestNewSSHKeyReport(t *testing.T) {
+	keys, err := DecodeSSHKeyFile("t/ssh_user-cert.pub")
+	assert.NoError(t, err)
+	report := NewSSHKeyReport(keys[0])
+	assert.Equal(t, "ecdsa-sha2-nistp256-cert-v01@openssh.com", report.Type)
+	assert.Regexp(t, "^SHA256:", report.Fingerprint)
+	if assert.NotNil(t, report.Certificate) {
+		assert.Equal(t, SSHCertTypeUser, report.Certificate.CertType)
+		assert.Equal(t, "alice", report.Certificate.KeyID)
+		assert.NotNil(t, report.Certificate.ValidBefore)
+		assert.Contains(t, report.Certificate.Extensions, "permit-pty")
+		assert.Equal(t, "ssh-ed25519", report.Certificate.SigningCAType)
+	}
+
+	keys, err = DecodeSSHKeyFile("t/ssh_host-cert.pub")
+	assert.NoError(t, err)
+	report = NewSSHKeyReport(keys[0])
+	if assert.NotNil(t, report.Certificate) {
+		assert.Nil(t, report.Certificate.ValidAfter)
+		assert.Nil(t, report.Certificate.ValidBefore)
+	}
+
+	keys, err = DecodeSSHKeyFile("t/ssh_ca.pub")
+	assert.NoError(t, err)
+	report = NewSSHKeyReport(keys[0])
+	assert.Equal(t, "certmin ssh ca", report.Comment)
+	assert.Nil(t, report.Certificate)
+}
+
+func TestNewRevocationReports(t *testing.T) {
+	report := NewOCSPResultReport(nil, errors.New("foo"))
+	assert.Equal(t, "foo", report.Error)
+	assert.Empty(t, report.Status)
+
+	report = NewOCSPResultReport(&OCSPResult{
+		Status:           OCSPRevoked,
+		RevokedAt:        time.Now(),
+		RevocationReason: 1,
+		ThisUpdate:       time.Now(),
+	}, nil)
+	assert.Equal(t, OCSPRevoked, report.Status)
+	assert.Equal(t, "key compromise", report.Reason)
+	assert.NotNil(t, report.RevokedAt)
+	assert.Nil(t, report.NextUpdate)
+
+	report = NewCRLResultReport(&CRLResult{Location: "http://crl.example.com"}, nil)
+	assert.Equal(t, OCSPGood, report.Status)
+	assert.Equal(t, "http://crl.example.com", report.Responder)
+	assert.Nil(t, report.RevokedAt)
+}
+
+func TestNewVerificationReport(t *testing.T) {
+	certs, err := DecodeCertFile("t/myserver.crt", "")
+	assert.NoError(t, err)
+	roots, err := DecodeCertFile("t/ca.crt", "")
+	assert.NoError(t, err)
+
+	result := VerifyChainWithOptions(&CertTree{Certificate: certs[0], Roots: roots},
+		VerifyOptions{CurrentTime: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)})
+	report := NewVerificationReport(result)
+	assert.True(t, report.Verified)
+	if assert.Equal(t, 1, len(report.Paths)) && assert.Equal(t, 2, len(report.Paths[0].Certificates)) {
+		assert.Equal(t, SourceCertTree, report.Paths[0].Certificates[1].Source)
+	}
+
+	report = NewVerificationReport(VerifyChain(&CertTree{Certificate: certs[0]}))
+	assert.False(t, report.Verified)
+	assert.NotEmpty(t, report.Failure)
+	assert.Empty(t, report.Paths)
+}
+
+func TestTLSVersionName(t *testing.T) {
+	assert.Equal(t, "TLS 1.2", TLSVersionName(0x0303))
+	assert.Equal(t, "unknown (0x0200)", TLSVersionName(0x0200))
+}
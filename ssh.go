@@ -0,0 +1,122 @@
+package certmin
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Types of an OpenSSH certificate.
+const (
+	SSHCertTypeUser = "user"
+	SSHCertTypeHost = "host"
+)
+
+// SSHKey is an OpenSSH public key or certificate as found in an authorized_keys
+// or *.pub file. PublicKey is a *ssh.Certificate for certificates. Comment and
+// Options are the ones of the line of the key (if any).
+type SSHKey struct {
+	PublicKey ssh.PublicKey
+	Comment   string
+	Options   []string
+}
+
+// DecodeSSHKeyBytes reads a []byte with OpenSSH public keys and certificates in
+// the authorized_keys format (one key per line, as in *.pub files) and returns a
+// []*SSHKey and an error if encountered. Empty lines, comments and lines that
+// can not be parsed are skipped.
+func DecodeSSHKeyBytes(keyBytes []byte) ([]*SSHKey, error) {
+	var keys []*SSHKey
+	rest := keyBytes
+	for len(rest) > 0 {
+		publicKey, comment, options, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+		keys = append(keys, &SSHKey{PublicKey: publicKey, Comment: comment, Options: options})
+		rest = next
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no OpenSSH keys found")
+	}
+	return keys, nil
+}
+
+// DecodeSSHKeyFile reads a file with OpenSSH public keys and certificates and
+// returns a []*SSHKey and an error if encountered.
+func DecodeSSHKeyFile(keyFile string) ([]*SSHKey, error) {
+	keyBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeSSHKeyBytes(keyBytes)
+}
+
+// SSHCertType returns the type of an OpenSSH certificate: SSHCertTypeUser or
+// SSHCertTypeHost.
+func SSHCertType(cert *ssh.Certificate) string {
+	if cert.CertType == ssh.HostCert {
+		return SSHCertTypeHost
+	}
+	return SSHCertTypeUser
+}
+
+// SSHCertValidity returns the validity window of an OpenSSH certificate. A zero
+// time.Time means that the certificate is valid since always or forever.
+func SSHCertValidity(cert *ssh.Certificate) (time.Time, time.Time) {
+	var validAfter, validBefore time.Time
+	if cert.ValidAfter != 0 {
+		validAfter = time.Unix(int64(cert.ValidAfter), 0).UTC()
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		validBefore = time.Unix(int64(cert.ValidBefore), 0).UTC()
+	}
+	return validAfter, validBefore
+}
+
+// VerifySSHKeyAndPrivateKey verifies that an OpenSSH public key or certificate
+// (ssh.PublicKey) and a key (*PrivateKey) match. It returns nil on a match and a
+// *KeyMismatchError with the reason otherwise.
+func VerifySSHKeyAndPrivateKey(publicKey ssh.PublicKey, key *PrivateKey) error {
+	if publicKey == nil {
+		return errors.New("no OpenSSH key found")
+	}
+	if cert, ok := publicKey.(*ssh.Certificate); ok {
+		publicKey = cert.Key
+	}
+	cryptoPublicKey, ok := publicKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return errors.New("unknown public key algorithm")
+	}
+	return verifyPublicKeyAndPrivateKey(cryptoPublicKey.CryptoPublicKey(), key)
+}
+
+// Certificate returns the key as a *ssh.Certificate or nil if the key is not a
+// certificate.
+func (key *SSHKey) Certificate() *ssh.Certificate {
+	cert, _ := key.PublicKey.(*ssh.Certificate)
+	return cert
+}
+
+// decodePrivateKeyOpenSSH parses a private key in the OpenSSH format, optionally
+// encrypted with a password.
+func decodePrivateKeyOpenSSH(block *pem.Block, password string) (*PrivateKey, error) {
+	pemBytes := pem.EncodeToMemory(block)
+	format := KeyFormatOpenSSH
+	parsedKey, err := ssh.ParseRawPrivateKey(pemBytes)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		format = KeyFormatEncryptedOpenSSH
+		parsedKey, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, []byte(password))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if edKey, ok := parsedKey.(*ed25519.PrivateKey); ok {
+		parsedKey = *edKey
+	}
+	return newPrivateKey(parsedKey, format)
+}
@@ -0,0 +1,115 @@
+package certmin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDecodeSSHKeyBytes(t *testing.T) {
+	keys, err := DecodeSSHKeyBytes([]byte("# comment\n\nssh-foo AAAA\n"))
+	assert.Error(t, err)
+	assert.Nil(t, keys)
+
+	_, err = DecodeSSHKeyBytes([]byte("foo"))
+	assert.Error(t, err)
+}
+
+func TestDecodeSSHKeyFile(t *testing.T) {
+	keys, err := DecodeSSHKeyFile("t/ssh_authorized_keys")
+	assert.NoError(t, err)
+	if assert.Equal(t, 3, len(keys)) {
+		assert.Equal(t, "alice@certmin", keys[0].Comment)
+		assert.Equal(t, ssh.KeyAlgoECDSA256, keys[0].PublicKey.Type())
+		assert.Equal(t, []string{`from="10.0.0.0/8"`, "no-pty"}, keys[1].Options)
+		assert.Equal(t, []string{"cert-authority"}, keys[2].Options)
+		assert.Nil(t, keys[0].Certificate())
+	}
+
+	keys, err = DecodeSSHKeyFile("t/ssh_user-cert.pub")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(keys)) {
+		cert := keys[0].Certificate()
+		if assert.NotNil(t, cert) {
+			assert.Equal(t, "alice", cert.KeyId)
+			assert.Equal(t, uint64(42), cert.Serial)
+			assert.Equal(t, []string{"alice", "root"}, cert.ValidPrincipals)
+			assert.Equal(t, "/usr/bin/uptime", cert.CriticalOptions["force-command"])
+		}
+	}
+
+	_, err = DecodeSSHKeyFile("t/myserver.crt")
+	assert.Error(t, err)
+	_, err = DecodeSSHKeyFile("t/doesnotexist.pub")
+	assert.Error(t, err)
+}
+
+func TestSSHCertType(t *testing.T) {
+	keys, err := DecodeSSHKeyFile("t/ssh_user-cert.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, SSHCertTypeUser, SSHCertType(keys[0].Certificate()))
+	keys, err = DecodeSSHKeyFile("t/ssh_host-cert.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, SSHCertTypeHost, SSHCertType(keys[0].Certificate()))
+}
+
+func TestSSHCertValidity(t *testing.T) {
+	keys, err := DecodeSSHKeyFile("t/ssh_user-cert.pub")
+	assert.NoError(t, err)
+	validAfter, validBefore := SSHCertValidity(keys[0].Certificate())
+	assert.Equal(t, 2021, validAfter.Year())
+	assert.Equal(t, 2031, validBefore.Year())
+
+	keys, err = DecodeSSHKeyFile("t/ssh_host-cert.pub")
+	assert.NoError(t, err)
+	validAfter, validBefore = SSHCertValidity(keys[0].Certificate())
+	assert.True(t, validAfter.IsZero())
+	assert.True(t, validBefore.IsZero())
+
+	cert := &ssh.Certificate{ValidAfter: 1609459200, ValidBefore: ssh.CertTimeInfinity}
+	validAfter, validBefore = SSHCertValidity(cert)
+	assert.True(t, validAfter.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, validBefore.IsZero())
+}
+
+func TestVerifySSHKeyAndPrivateKey(t *testing.T) {
+	tests := []struct {
+		keyFile, password, publicKeyFile, format, reason string
+	}{
+		{"t/ssh_user", testPassword, "t/ssh_user-cert.pub", KeyFormatEncryptedOpenSSH, ""},
+		{"t/ssh_user", testPassword, "t/ssh_user.pub", KeyFormatEncryptedOpenSSH, ""},
+		{"t/ssh_host", "", "t/ssh_host-cert.pub", KeyFormatOpenSSH, ""},
+		{"t/ssh_ca", "", "t/ssh_ca.pub", KeyFormatOpenSSH, ""},
+		{"t/ssh_ca", "", "t/ssh_user-cert.pub", KeyFormatOpenSSH, MismatchAlgorithm},
+		{"t/ecdsa_prime256v1.key", "", "t/ssh_user-cert.pub", KeyFormatPKCS8, MismatchPublicKey},
+		{"t/myserver.key", "", "t/ssh_host.pub", KeyFormatPKCS1, MismatchPublicKey},
+	}
+	for _, test := range tests {
+		key, err := DecodePrivateKeyFile(test.keyFile, test.password)
+		if !assert.NoError(t, err, test.keyFile) {
+			continue
+		}
+		assert.Equal(t, test.format, key.Format, test.keyFile)
+		keys, err := DecodeSSHKeyFile(test.publicKeyFile)
+		assert.NoError(t, err)
+
+		err = VerifySSHKeyAndPrivateKey(keys[0].PublicKey, key)
+		if test.reason == "" {
+			assert.NoError(t, err, test.publicKeyFile)
+			continue
+		}
+		var mismatch *KeyMismatchError
+		if assert.True(t, errors.As(err, &mismatch), test.publicKeyFile) {
+			assert.Equal(t, test.reason, mismatch.Reason, test.publicKeyFile)
+		}
+	}
+
+	_, err := DecodePrivateKeyFile("t/ssh_user", "wrong")
+	assert.Error(t, err)
+	_, err = DecodePrivateKeyFile("t/ssh_user", "")
+	assert.Error(t, err)
+	assert.Error(t, VerifySSHKeyAndPrivateKey(nil, nil))
+}
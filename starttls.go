@@ -0,0 +1,392 @@
+package certmin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Protocols that can be used to upgrade a plaintext connection to TLS
+// before retrieving the certificates (see RetrieveCertsFromAddrStartTLS).
+const (
+	StartTLSNone = ""
+	StartTLSSMTP = "smtp"
+	StartTLSIMAP = "imap"
+	StartTLSPOP3 = "pop3"
+	StartTLSFTP  = "ftp"
+	StartTLSLDAP = "ldap"
+	StartTLSXMPP = "xmpp"
+
+	StartTLSPostgres = "postgres"
+	StartTLSMySQL    = "mysql"
+)
+
+// startTLSFunc speaks the plaintext part of a protocol on conn until the
+// server is ready for a TLS handshake. The host is the name of the server.
+type startTLSFunc func(conn net.Conn, host string) error
+
+// startTLSFuncs maps the supported protocols to their negotiation. A nil
+// function means that the TLS handshake is done immediately.
+var startTLSFuncs = map[string]startTLSFunc{
+	StartTLSNone: nil,
+	StartTLSSMTP: startTLSSMTP,
+	StartTLSIMAP: startTLSIMAP,
+	StartTLSPOP3: startTLSPOP3,
+	StartTLSFTP:  startTLSFTP,
+	StartTLSLDAP: startTLSLDAP,
+	StartTLSXMPP: startTLSXMPP,
+
+	StartTLSPostgres: startTLSPostgres,
+	StartTLSMySQL:    startTLSMySQL,
+}
+
+// ldapStartTLSOID is the name of the LDAP StartTLS extended operation.
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// postgresSSLRequestCode is the request code of the PostgreSQL SSLRequest message.
+const postgresSSLRequestCode = 80877103
+
+// MySQL capability flags used in the SSLRequest packet.
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+// startTLSSMTP upgrades a SMTP connection with EHLO and STARTTLS.
+func startTLSSMTP(conn net.Conn, host string) error {
+	reader := bufio.NewReader(conn)
+	if _, err := readReplyCode(reader, "220"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "EHLO certmin\r\n"); err != nil {
+		return err
+	}
+	lines, err := readReplyCode(reader, "250")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(strings.Join(lines, "\n")), "STARTTLS") {
+		return errors.New("the SMTP server does not offer STARTTLS")
+	}
+	if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	_, err = readReplyCode(reader, "220")
+	return err
+}
+
+// startTLSIMAP upgrades an IMAP connection with STARTTLS.
+func startTLSIMAP(conn net.Conn, host string) error {
+	reader := bufio.NewReader(conn)
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("unexpected IMAP greeting (%s)", line)
+	}
+	if _, err := io.WriteString(conn, "certmin STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "certmin OK"):
+			return nil
+		case strings.HasPrefix(line, "certmin "):
+			return fmt.Errorf("IMAP STARTTLS failed (%s)", line)
+		}
+	}
+}
+
+// startTLSPOP3 upgrades a POP3 connection with STLS.
+func startTLSPOP3(conn net.Conn, host string) error {
+	reader := bufio.NewReader(conn)
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("unexpected POP3 greeting (%s)", line)
+	}
+	if _, err := io.WriteString(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	line, err = readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("POP3 STLS failed (%s)", line)
+	}
+	return nil
+}
+
+// startTLSFTP upgrades a FTP connection with AUTH TLS.
+func startTLSFTP(conn net.Conn, host string) error {
+	reader := bufio.NewReader(conn)
+	if _, err := readReplyCode(reader, "220"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "AUTH TLS\r\n"); err != nil {
+		return err
+	}
+	_, err := readReplyCode(reader, "234")
+	return err
+}
+
+// startTLSLDAP upgrades a LDAP connection with the StartTLS extended operation.
+func startTLSLDAP(conn net.Conn, host string) error {
+	// LDAPMessage { messageID 1, ExtendedRequest { requestName [0] OID } }
+	request := []byte{0x30, byte(7 + len(ldapStartTLSOID)), 0x02, 0x01, 0x01,
+		0x77, byte(2 + len(ldapStartTLSOID)), 0x80, byte(len(ldapStartTLSOID))}
+	request = append(request, ldapStartTLSOID...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	tag, msg, err := readBERElement(conn)
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return errors.New("unexpected LDAP response")
+	}
+	_, _, msg, err = nextBERElement(msg) // messageID
+	if err != nil {
+		return err
+	}
+	tag, response, _, err := nextBERElement(msg)
+	if err != nil {
+		return err
+	}
+	if tag != 0x78 { // [APPLICATION 24] ExtendedResponse
+		return errors.New("unexpected LDAP response")
+	}
+	tag, resultCode, _, err := nextBERElement(response)
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a || len(resultCode) != 1 {
+		return errors.New("unexpected LDAP response")
+	}
+	if resultCode[0] != 0 {
+		return fmt.Errorf("LDAP StartTLS failed (result code %d)", resultCode[0])
+	}
+	return nil
+}
+
+// startTLSXMPP upgrades a XMPP client connection with STARTTLS.
+func startTLSXMPP(conn net.Conn, host string) error {
+	stream := "<?xml version='1.0'?><stream:stream to='" + host + "' xmlns='jabber:client' " +
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"
+	if _, err := io.WriteString(conn, stream); err != nil {
+		return err
+	}
+	features, err := readUntil(conn, "</stream:features>")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(features, "<starttls") {
+		return errors.New("the XMPP server does not offer STARTTLS")
+	}
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	reply, err := readUntil(conn, "/>")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(reply, "<proceed") {
+		return fmt.Errorf("XMPP STARTTLS failed (%s)", reply)
+	}
+	return nil
+}
+
+// startTLSPostgres requests a TLS connection with the PostgreSQL SSLRequest message.
+func startTLSPostgres(conn net.Conn, host string) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch reply[0] {
+	case 'S':
+		return nil
+	case 'N':
+		return errors.New("the PostgreSQL server does not support SSL")
+	default:
+		return fmt.Errorf("unexpected PostgreSQL reply (%q)", reply[0])
+	}
+}
+
+// startTLSMySQL reads the MySQL initial handshake and, if the server
+// has the CLIENT_SSL capability, answers with a SSLRequest packet.
+func startTLSMySQL(conn net.Conn, host string) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	seq := header[3]
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return err
+	}
+
+	if len(payload) > 3 && payload[0] == 0xff { // ERR packet
+		return fmt.Errorf("MySQL error (%s)", payload[3:])
+	}
+	if len(payload) == 0 || payload[0] != 10 {
+		return errors.New("unsupported MySQL protocol version")
+	}
+	// protocol version, NUL terminated server version, connection id (4),
+	// auth-plugin-data-part-1 (8), filler (1), capability flags (lower 2)
+	versionEnd := bytes.IndexByte(payload[1:], 0)
+	if versionEnd < 0 || len(payload) < 1+versionEnd+1+4+8+1+2 {
+		return errors.New("truncated MySQL handshake")
+	}
+	capOffset := 1 + versionEnd + 1 + 4 + 8 + 1
+	capabilities := binary.LittleEndian.Uint16(payload[capOffset : capOffset+2])
+	if capabilities&mysqlClientSSL == 0 {
+		return errors.New("the MySQL server does not support SSL")
+	}
+
+	request := make([]byte, 4+32)
+	request[0] = 32
+	request[3] = seq + 1
+	binary.LittleEndian.PutUint32(request[4:8],
+		mysqlClientLongPassword|mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(request[8:12], 1<<24) // max packet size
+	request[12] = 33                                    // utf8_general_ci
+	_, err := conn.Write(request)
+	return err
+}
+
+// readLine reads a CRLF or LF terminated line and returns it without
+// the line ending.
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readReplyCode reads a (multi-line) SMTP or FTP style reply and
+// returns its lines and an error if the reply code is not the
+// expected one.
+func readReplyCode(reader *bufio.Reader, code string) ([]string, error) {
+	var lines []string
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+		if len(line) >= 4 && line[3] == '-' { // multi-line reply
+			continue
+		}
+		if !strings.HasPrefix(line, code) {
+			return nil, fmt.Errorf("unexpected reply (%s)", line)
+		}
+		return lines, nil
+	}
+}
+
+// readUntil reads from conn byte by byte (so nothing of the following
+// TLS handshake is consumed) until the marker is found.
+func readUntil(conn net.Conn, marker string) (string, error) {
+	var buf bytes.Buffer
+	b := make([]byte, 1)
+	for !strings.Contains(buf.String(), marker) {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		buf.Write(b)
+		if buf.Len() > 1<<16 {
+			return "", errors.New("response too long")
+		}
+	}
+	return buf.String(), nil
+}
+
+// readBERElement reads a single BER element with a definite length from
+// conn and returns its tag and contents.
+func readBERElement(conn net.Conn) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := int(header[1])
+	if header[1]&0x80 != 0 {
+		numBytes := int(header[1] & 0x7f)
+		if numBytes == 0 || numBytes > 4 {
+			return 0, nil, errors.New("unsupported BER length")
+		}
+		lengthBytes := make([]byte, numBytes)
+		if _, err := io.ReadFull(conn, lengthBytes); err != nil {
+			return 0, nil, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 1<<16 {
+		return 0, nil, errors.New("response too long")
+	}
+
+	contents := make([]byte, length)
+	if _, err := io.ReadFull(conn, contents); err != nil {
+		return 0, nil, err
+	}
+	return header[0], contents, nil
+}
+
+// nextBERElement splits the first BER element with a definite length
+// of data and returns its tag, its contents and the remaining bytes.
+// Unlike encoding/asn1 it accepts non-minimal length encodings, as
+// sent by some LDAP servers.
+func nextBERElement(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+
+	tag := data[0]
+	length := int(data[1])
+	offset := 2
+	if data[1]&0x80 != 0 {
+		numBytes := int(data[1] & 0x7f)
+		if numBytes == 0 || numBytes > 4 || len(data) < offset+numBytes {
+			return 0, nil, nil, errors.New("unsupported BER length")
+		}
+		length = 0
+		for _, b := range data[offset : offset+numBytes] {
+			length = length<<8 | int(b)
+		}
+		offset += numBytes
+	}
+	if length < 0 || len(data) < offset+length {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
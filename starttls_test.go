@@ -0,0 +1,240 @@
+package certmin
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeServer starts a TCP server on localhost that speaks the given
+// plaintext preamble before doing a TLS handshake with t/myserver.crt.
+// It returns the address of the server.
+func startFakeServer(t *testing.T, preamble func(conn net.Conn) error) string {
+	cert, err := tls.LoadX509KeyPair("t/myserver.crt", "t/myserver.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startFakeTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, preamble)
+}
+
+// startFakeTLSServer starts a TCP server on localhost that speaks the given
+// plaintext preamble before doing a TLS handshake with the given config.
+// It returns the address of the server.
+func startFakeTLSServer(t *testing.T, config *tls.Config, preamble func(conn net.Conn) error) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if preamble != nil {
+					if err := preamble(conn); err != nil {
+						return
+					}
+				}
+				tlsConn := tls.Server(conn, config)
+				tlsConn.Handshake()
+				tlsConn.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// expectLine reads a line from the client and checks it against the expected one.
+func expectLine(reader *bufio.Reader, expected string) error {
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, expected) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func TestRetrieveCertsFromAddrStartTLS(t *testing.T) {
+	preambles := map[string]func(conn net.Conn) error{
+		StartTLSNone: nil,
+		StartTLSSMTP: func(conn net.Conn) error {
+			reader := bufio.NewReader(conn)
+			io.WriteString(conn, "220-mail.example.com ESMTP\r\n220 ready\r\n")
+			if err := expectLine(reader, "EHLO"); err != nil {
+				return err
+			}
+			io.WriteString(conn, "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+			if err := expectLine(reader, "STARTTLS"); err != nil {
+				return err
+			}
+			_, err := io.WriteString(conn, "220 go ahead\r\n")
+			return err
+		},
+		StartTLSIMAP: func(conn net.Conn) error {
+			reader := bufio.NewReader(conn)
+			io.WriteString(conn, "* OK [CAPABILITY IMAP4rev1 STARTTLS] ready\r\n")
+			if err := expectLine(reader, "certmin STARTTLS"); err != nil {
+				return err
+			}
+			_, err := io.WriteString(conn, "certmin OK Begin TLS negotiation now\r\n")
+			return err
+		},
+		StartTLSPOP3: func(conn net.Conn) error {
+			reader := bufio.NewReader(conn)
+			io.WriteString(conn, "+OK POP3 ready\r\n")
+			if err := expectLine(reader, "STLS"); err != nil {
+				return err
+			}
+			_, err := io.WriteString(conn, "+OK Begin TLS negotiation\r\n")
+			return err
+		},
+		StartTLSFTP: func(conn net.Conn) error {
+			reader := bufio.NewReader(conn)
+			io.WriteString(conn, "220 FTP ready\r\n")
+			if err := expectLine(reader, "AUTH TLS"); err != nil {
+				return err
+			}
+			_, err := io.WriteString(conn, "234 AUTH TLS successful\r\n")
+			return err
+		},
+		StartTLSLDAP: func(conn net.Conn) error {
+			tag, _, err := readBERElement(conn)
+			if err != nil {
+				return err
+			}
+			if tag != 0x30 {
+				return io.ErrUnexpectedEOF
+			}
+			// Non-minimal length as sent by Active Directory
+			_, err = conn.Write([]byte{0x30, 0x84, 0x00, 0x00, 0x00, 0x0c, 0x02, 0x01, 0x01,
+				0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00})
+			return err
+		},
+		StartTLSXMPP: func(conn net.Conn) error {
+			if _, err := readUntil(conn, "version='1.0'>"); err != nil {
+				return err
+			}
+			io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='localhost' version='1.0'>"+
+				"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/>"+
+				"</starttls></stream:features>")
+			if _, err := readUntil(conn, "/>"); err != nil {
+				return err
+			}
+			_, err := io.WriteString(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+			return err
+		},
+		StartTLSPostgres: func(conn net.Conn) error {
+			request := make([]byte, 8)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return err
+			}
+			if binary.BigEndian.Uint32(request[4:]) != postgresSSLRequestCode {
+				return io.ErrUnexpectedEOF
+			}
+			_, err := conn.Write([]byte("S"))
+			return err
+		},
+		StartTLSMySQL: func(conn net.Conn) error {
+			payload := []byte{10}
+			payload = append(payload, "8.0.23\x00"...)
+			payload = append(payload, 1, 0, 0, 0)           // connection id
+			payload = append(payload, "abcdefgh\x00"...)    // auth data and filler
+			payload = append(payload, 0xff, 0xff, 33, 2, 0) // capabilities, charset, status
+			header := []byte{byte(len(payload)), 0, 0, 0}
+			conn.Write(append(header, payload...))
+
+			request := make([]byte, 4+32)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return err
+			}
+			if request[3] != 1 || binary.LittleEndian.Uint32(request[4:8])&mysqlClientSSL == 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		},
+	}
+
+	for protocol, preamble := range preambles {
+		addr := startFakeServer(t, preamble)
+		certs, warn, err := RetrieveCertsFromAddrStartTLS(addr, protocol, 2*time.Second)
+		assert.NoError(t, err, protocol)
+		assert.Error(t, warn, protocol) // self-signed CA
+		if assert.NotNil(t, certs, protocol) {
+			assert.Equal(t, "myserver", certs[0].Subject.CommonName, protocol)
+		}
+	}
+
+	// Server refusing the upgrade, not retried without verification
+	var connections int32
+	addr := startFakeServer(t, func(conn net.Conn) error {
+		atomic.AddInt32(&connections, 1)
+		reader := bufio.NewReader(conn)
+		io.WriteString(conn, "220 ready\r\n")
+		expectLine(reader, "EHLO")
+		io.WriteString(conn, "250 mail.example.com\r\n")
+		return io.EOF
+	})
+	certs, _, err := RetrieveCertsFromAddrStartTLS(addr, StartTLSSMTP, 2*time.Second)
+	assert.Nil(t, certs)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections))
+
+	// Database without SSL
+	addr = startFakeServer(t, func(conn net.Conn) error {
+		request := make([]byte, 8)
+		io.ReadFull(conn, request)
+		conn.Write([]byte("N"))
+		return io.EOF
+	})
+	certs, _, err = RetrieveCertsFromAddrStartTLS(addr, StartTLSPostgres, 2*time.Second)
+	assert.Nil(t, certs)
+	assert.Error(t, err)
+
+	// Unknown protocol
+	certs, _, err = RetrieveCertsFromAddrStartTLS(addr, "foo", 2*time.Second)
+	assert.Nil(t, certs)
+	assert.Error(t, err)
+}
+
+func TestReadReplyCode(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("250-foo\r\n250-bar\r\n250 baz\r\n"))
+	lines, err := readReplyCode(reader, "250")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"250-foo", "250-bar", "250 baz"}, lines)
+
+	reader = bufio.NewReader(strings.NewReader("554 go away\r\n"))
+	_, err = readReplyCode(reader, "220")
+	assert.Error(t, err)
+}
+
+func TestNextBERElement(t *testing.T) {
+	tag, contents, rest, err := nextBERElement([]byte{0x02, 0x01, 0x01, 0x0a, 0x01, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x02), tag)
+	assert.Equal(t, []byte{0x01}, contents)
+	assert.Equal(t, []byte{0x0a, 0x01, 0x00}, rest)
+
+	tag, contents, _, err = nextBERElement([]byte{0x04, 0x82, 0x00, 0x01, 0xff})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x04), tag)
+	assert.Equal(t, []byte{0xff}, contents)
+
+	_, _, _, err = nextBERElement([]byte{0x04, 0x05, 0x00})
+	assert.Error(t, err)
+}